@@ -0,0 +1,507 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.29.3
+// source: broadcaster/v1/broadcaster.proto
+
+package broadcasterv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PublishRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Channel string                 `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	Event   string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*PublishRequest_Json
+	//	*PublishRequest_Binary
+	Payload       isPublishRequest_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishRequest) Reset() {
+	*x = PublishRequest{}
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishRequest) ProtoMessage() {}
+
+func (x *PublishRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishRequest.ProtoReflect.Descriptor instead.
+func (*PublishRequest) Descriptor() ([]byte, []int) {
+	return file_broadcaster_v1_broadcaster_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PublishRequest) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetPayload() isPublishRequest_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetJson() *structpb.Value {
+	if x != nil {
+		if x, ok := x.Payload.(*PublishRequest_Json); ok {
+			return x.Json
+		}
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetBinary() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*PublishRequest_Binary); ok {
+			return x.Binary
+		}
+	}
+	return nil
+}
+
+type isPublishRequest_Payload interface {
+	isPublishRequest_Payload()
+}
+
+type PublishRequest_Json struct {
+	Json *structpb.Value `protobuf:"bytes,3,opt,name=json,proto3,oneof"`
+}
+
+type PublishRequest_Binary struct {
+	Binary []byte `protobuf:"bytes,4,opt,name=binary,proto3,oneof"`
+}
+
+func (*PublishRequest_Json) isPublishRequest_Payload() {}
+
+func (*PublishRequest_Binary) isPublishRequest_Payload() {}
+
+type PublishBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*PublishRequest      `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishBatchRequest) Reset() {
+	*x = PublishBatchRequest{}
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishBatchRequest) ProtoMessage() {}
+
+func (x *PublishBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishBatchRequest.ProtoReflect.Descriptor instead.
+func (*PublishBatchRequest) Descriptor() ([]byte, []int) {
+	return file_broadcaster_v1_broadcaster_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PublishBatchRequest) GetRequests() []*PublishRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type PublishBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*Message             `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishBatchResponse) Reset() {
+	*x = PublishBatchResponse{}
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishBatchResponse) ProtoMessage() {}
+
+func (x *PublishBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishBatchResponse.ProtoReflect.Descriptor instead.
+func (*PublishBatchResponse) Descriptor() ([]byte, []int) {
+	return file_broadcaster_v1_broadcaster_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PublishBatchResponse) GetMessages() []*Message {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type SubscribeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Channels are names or patterns.
+	Channels []string `protobuf:"bytes,1,rep,name=channels,proto3" json:"channels,omitempty"`
+	// After offsets replays the messages of named channels published after the
+	// offsets. The call fails with FAILED_PRECONDITION if any of them is gone.
+	AfterOffsets  map[string]uint64 `protobuf:"bytes,2,rep,name=after_offsets,json=afterOffsets,proto3" json:"after_offsets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_broadcaster_v1_broadcaster_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubscribeRequest) GetChannels() []string {
+	if x != nil {
+		return x.Channels
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetAfterOffsets() map[string]uint64 {
+	if x != nil {
+		return x.AfterOffsets
+	}
+	return nil
+}
+
+type Message struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Id         string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Seq        uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Offset     uint64                 `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	CreateTime *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	Channel    string                 `protobuf:"bytes,5,opt,name=channel,proto3" json:"channel,omitempty"`
+	Event      string                 `protobuf:"bytes,6,opt,name=event,proto3" json:"event,omitempty"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*Message_Json
+	//	*Message_Binary
+	Payload       isMessage_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Message) Reset() {
+	*x = Message{}
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Message) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Message) ProtoMessage() {}
+
+func (x *Message) ProtoReflect() protoreflect.Message {
+	mi := &file_broadcaster_v1_broadcaster_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Message.ProtoReflect.Descriptor instead.
+func (*Message) Descriptor() ([]byte, []int) {
+	return file_broadcaster_v1_broadcaster_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Message) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Message) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Message) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *Message) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+func (x *Message) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+func (x *Message) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *Message) GetPayload() isMessage_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Message) GetJson() *structpb.Value {
+	if x != nil {
+		if x, ok := x.Payload.(*Message_Json); ok {
+			return x.Json
+		}
+	}
+	return nil
+}
+
+func (x *Message) GetBinary() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*Message_Binary); ok {
+			return x.Binary
+		}
+	}
+	return nil
+}
+
+type isMessage_Payload interface {
+	isMessage_Payload()
+}
+
+type Message_Json struct {
+	Json *structpb.Value `protobuf:"bytes,7,opt,name=json,proto3,oneof"`
+}
+
+type Message_Binary struct {
+	Binary []byte `protobuf:"bytes,8,opt,name=binary,proto3,oneof"`
+}
+
+func (*Message_Json) isMessage_Payload() {}
+
+func (*Message_Binary) isMessage_Payload() {}
+
+var File_broadcaster_v1_broadcaster_proto protoreflect.FileDescriptor
+
+const file_broadcaster_v1_broadcaster_proto_rawDesc = "" +
+	"\n" +
+	" broadcaster/v1/broadcaster.proto\x12\x0ebroadcaster.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x93\x01\n" +
+	"\x0ePublishRequest\x12\x18\n" +
+	"\achannel\x18\x01 \x01(\tR\achannel\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12,\n" +
+	"\x04json\x18\x03 \x01(\v2\x16.google.protobuf.ValueH\x00R\x04json\x12\x18\n" +
+	"\x06binary\x18\x04 \x01(\fH\x00R\x06binaryB\t\n" +
+	"\apayload\"Q\n" +
+	"\x13PublishBatchRequest\x12:\n" +
+	"\brequests\x18\x01 \x03(\v2\x1e.broadcaster.v1.PublishRequestR\brequests\"K\n" +
+	"\x14PublishBatchResponse\x123\n" +
+	"\bmessages\x18\x01 \x03(\v2\x17.broadcaster.v1.MessageR\bmessages\"\xc8\x01\n" +
+	"\x10SubscribeRequest\x12\x1a\n" +
+	"\bchannels\x18\x01 \x03(\tR\bchannels\x12W\n" +
+	"\rafter_offsets\x18\x02 \x03(\v22.broadcaster.v1.SubscribeRequest.AfterOffsetsEntryR\fafterOffsets\x1a?\n" +
+	"\x11AfterOffsetsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x04R\x05value:\x028\x01\"\x83\x02\n" +
+	"\aMessage\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x04R\x06offset\x12;\n" +
+	"\vcreate_time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\x12\x18\n" +
+	"\achannel\x18\x05 \x01(\tR\achannel\x12\x14\n" +
+	"\x05event\x18\x06 \x01(\tR\x05event\x12,\n" +
+	"\x04json\x18\a \x01(\v2\x16.google.protobuf.ValueH\x00R\x04json\x12\x18\n" +
+	"\x06binary\x18\b \x01(\fH\x00R\x06binaryB\t\n" +
+	"\apayload2\xf6\x01\n" +
+	"\vBroadcaster\x12B\n" +
+	"\aPublish\x12\x1e.broadcaster.v1.PublishRequest\x1a\x17.broadcaster.v1.Message\x12Y\n" +
+	"\fPublishBatch\x12#.broadcaster.v1.PublishBatchRequest\x1a$.broadcaster.v1.PublishBatchResponse\x12H\n" +
+	"\tSubscribe\x12 .broadcaster.v1.SubscribeRequest\x1a\x17.broadcaster.v1.Message0\x01BAZ?github.com/goevery/broadcaster/api/broadcaster/v1;broadcasterv1b\x06proto3"
+
+var (
+	file_broadcaster_v1_broadcaster_proto_rawDescOnce sync.Once
+	file_broadcaster_v1_broadcaster_proto_rawDescData []byte
+)
+
+func file_broadcaster_v1_broadcaster_proto_rawDescGZIP() []byte {
+	file_broadcaster_v1_broadcaster_proto_rawDescOnce.Do(func() {
+		file_broadcaster_v1_broadcaster_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_broadcaster_v1_broadcaster_proto_rawDesc), len(file_broadcaster_v1_broadcaster_proto_rawDesc)))
+	})
+	return file_broadcaster_v1_broadcaster_proto_rawDescData
+}
+
+var file_broadcaster_v1_broadcaster_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_broadcaster_v1_broadcaster_proto_goTypes = []any{
+	(*PublishRequest)(nil),        // 0: broadcaster.v1.PublishRequest
+	(*PublishBatchRequest)(nil),   // 1: broadcaster.v1.PublishBatchRequest
+	(*PublishBatchResponse)(nil),  // 2: broadcaster.v1.PublishBatchResponse
+	(*SubscribeRequest)(nil),      // 3: broadcaster.v1.SubscribeRequest
+	(*Message)(nil),               // 4: broadcaster.v1.Message
+	nil,                           // 5: broadcaster.v1.SubscribeRequest.AfterOffsetsEntry
+	(*structpb.Value)(nil),        // 6: google.protobuf.Value
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_broadcaster_v1_broadcaster_proto_depIdxs = []int32{
+	6, // 0: broadcaster.v1.PublishRequest.json:type_name -> google.protobuf.Value
+	0, // 1: broadcaster.v1.PublishBatchRequest.requests:type_name -> broadcaster.v1.PublishRequest
+	4, // 2: broadcaster.v1.PublishBatchResponse.messages:type_name -> broadcaster.v1.Message
+	5, // 3: broadcaster.v1.SubscribeRequest.after_offsets:type_name -> broadcaster.v1.SubscribeRequest.AfterOffsetsEntry
+	7, // 4: broadcaster.v1.Message.create_time:type_name -> google.protobuf.Timestamp
+	6, // 5: broadcaster.v1.Message.json:type_name -> google.protobuf.Value
+	0, // 6: broadcaster.v1.Broadcaster.Publish:input_type -> broadcaster.v1.PublishRequest
+	1, // 7: broadcaster.v1.Broadcaster.PublishBatch:input_type -> broadcaster.v1.PublishBatchRequest
+	3, // 8: broadcaster.v1.Broadcaster.Subscribe:input_type -> broadcaster.v1.SubscribeRequest
+	4, // 9: broadcaster.v1.Broadcaster.Publish:output_type -> broadcaster.v1.Message
+	2, // 10: broadcaster.v1.Broadcaster.PublishBatch:output_type -> broadcaster.v1.PublishBatchResponse
+	4, // 11: broadcaster.v1.Broadcaster.Subscribe:output_type -> broadcaster.v1.Message
+	9, // [9:12] is the sub-list for method output_type
+	6, // [6:9] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_broadcaster_v1_broadcaster_proto_init() }
+func file_broadcaster_v1_broadcaster_proto_init() {
+	if File_broadcaster_v1_broadcaster_proto != nil {
+		return
+	}
+	file_broadcaster_v1_broadcaster_proto_msgTypes[0].OneofWrappers = []any{
+		(*PublishRequest_Json)(nil),
+		(*PublishRequest_Binary)(nil),
+	}
+	file_broadcaster_v1_broadcaster_proto_msgTypes[4].OneofWrappers = []any{
+		(*Message_Json)(nil),
+		(*Message_Binary)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_broadcaster_v1_broadcaster_proto_rawDesc), len(file_broadcaster_v1_broadcaster_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_broadcaster_v1_broadcaster_proto_goTypes,
+		DependencyIndexes: file_broadcaster_v1_broadcaster_proto_depIdxs,
+		MessageInfos:      file_broadcaster_v1_broadcaster_proto_msgTypes,
+	}.Build()
+	File_broadcaster_v1_broadcaster_proto = out.File
+	file_broadcaster_v1_broadcaster_proto_goTypes = nil
+	file_broadcaster_v1_broadcaster_proto_depIdxs = nil
+}
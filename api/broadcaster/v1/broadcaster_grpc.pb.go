@@ -0,0 +1,219 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: broadcaster/v1/broadcaster.proto
+
+package broadcasterv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Broadcaster_Publish_FullMethodName      = "/broadcaster.v1.Broadcaster/Publish"
+	Broadcaster_PublishBatch_FullMethodName = "/broadcaster.v1.Broadcaster/PublishBatch"
+	Broadcaster_Subscribe_FullMethodName    = "/broadcaster.v1.Broadcaster/Subscribe"
+)
+
+// BroadcasterClient is the client API for Broadcaster service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Broadcaster publishes messages and streams the messages of channels for
+// backend services. Calls are authenticated with an API key sent in the
+// `authorization` metadata as `Bearer <key>`.
+type BroadcasterClient interface {
+	// Publish broadcasts a message. It requires the publish scope.
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Message, error)
+	// PublishBatch broadcasts messages in order. It stops at the first message
+	// that fails, and the messages before it stay published.
+	PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error)
+	// Subscribe streams the messages of channels until the call is cancelled.
+	// It requires the subscribe scope.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Message], error)
+}
+
+type broadcasterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBroadcasterClient(cc grpc.ClientConnInterface) BroadcasterClient {
+	return &broadcasterClient{cc}
+}
+
+func (c *broadcasterClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, Broadcaster_Publish_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *broadcasterClient) PublishBatch(ctx context.Context, in *PublishBatchRequest, opts ...grpc.CallOption) (*PublishBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishBatchResponse)
+	err := c.cc.Invoke(ctx, Broadcaster_PublishBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *broadcasterClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Message], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Broadcaster_ServiceDesc.Streams[0], Broadcaster_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, Message]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Broadcaster_SubscribeClient = grpc.ServerStreamingClient[Message]
+
+// BroadcasterServer is the server API for Broadcaster service.
+// All implementations must embed UnimplementedBroadcasterServer
+// for forward compatibility.
+//
+// Broadcaster publishes messages and streams the messages of channels for
+// backend services. Calls are authenticated with an API key sent in the
+// `authorization` metadata as `Bearer <key>`.
+type BroadcasterServer interface {
+	// Publish broadcasts a message. It requires the publish scope.
+	Publish(context.Context, *PublishRequest) (*Message, error)
+	// PublishBatch broadcasts messages in order. It stops at the first message
+	// that fails, and the messages before it stay published.
+	PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error)
+	// Subscribe streams the messages of channels until the call is cancelled.
+	// It requires the subscribe scope.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Message]) error
+	mustEmbedUnimplementedBroadcasterServer()
+}
+
+// UnimplementedBroadcasterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBroadcasterServer struct{}
+
+func (UnimplementedBroadcasterServer) Publish(context.Context, *PublishRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedBroadcasterServer) PublishBatch(context.Context, *PublishBatchRequest) (*PublishBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishBatch not implemented")
+}
+func (UnimplementedBroadcasterServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Message]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedBroadcasterServer) mustEmbedUnimplementedBroadcasterServer() {}
+func (UnimplementedBroadcasterServer) testEmbeddedByValue()                     {}
+
+// UnsafeBroadcasterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BroadcasterServer will
+// result in compilation errors.
+type UnsafeBroadcasterServer interface {
+	mustEmbedUnimplementedBroadcasterServer()
+}
+
+func RegisterBroadcasterServer(s grpc.ServiceRegistrar, srv BroadcasterServer) {
+	// If the following call pancis, it indicates UnimplementedBroadcasterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Broadcaster_ServiceDesc, srv)
+}
+
+func _Broadcaster_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BroadcasterServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Broadcaster_Publish_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BroadcasterServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Broadcaster_PublishBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BroadcasterServer).PublishBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Broadcaster_PublishBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BroadcasterServer).PublishBatch(ctx, req.(*PublishBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Broadcaster_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BroadcasterServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, Message]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Broadcaster_SubscribeServer = grpc.ServerStreamingServer[Message]
+
+// Broadcaster_ServiceDesc is the grpc.ServiceDesc for Broadcaster service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Broadcaster_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "broadcaster.v1.Broadcaster",
+	HandlerType: (*BroadcasterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _Broadcaster_Publish_Handler,
+		},
+		{
+			MethodName: "PublishBatch",
+			Handler:    _Broadcaster_PublishBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Broadcaster_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "broadcaster/v1/broadcaster.proto",
+}
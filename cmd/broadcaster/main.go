@@ -4,48 +4,127 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Netflix/go-env"
 	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/cluster"
 	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ingest"
+	"github.com/goevery/broadcaster/internal/pglisten"
 	"github.com/goevery/broadcaster/internal/server"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 type App struct {
 	logger          *zap.Logger
 	settings        Settings
+	authenticator   *auth.Authenticator
+	messageStore    broadcaster.MessageStore
+	registry        broadcaster.Registry
+	ingester        *ingest.PostgresIngester
 	websocketServer *server.WebSocketServer
+	sseServer       *server.SSEServer
+	longPollServer  *server.LongPollServer
 	restServer      *server.RESTServer
+	grpcServer      *server.GRPCServer
+	mqttServer      *server.MQTTServer
+	pusherServer    *server.PusherServer
 }
 
-func NewApp(logger *zap.Logger, settings Settings) *App {
-	originChecker := server.NewOriginChecker()
+func NewApp(logger *zap.Logger, settings Settings) (*App, error) {
+	// Cookies are sent whatever page opens the connection, so the pages
+	// allowed to use them must be known.
+	if settings.AuthCookie != "" && len(settings.AllowedOrigins) == 0 {
+		return nil, errors.New("ALLOWED_ORIGINS is required with AUTH_COOKIE")
+	}
+
+	// Replays are queued at once in the send channel of the connections, which
+	// holds 1024 messages.
+	if settings.HistoryReplayLimit <= 0 || settings.HistoryReplayLimit > 1024 {
+		return nil, errors.New("HISTORY_REPLAY_LIMIT must be between 1 and 1024")
+	}
+
+	if settings.SSEKeepAliveInterval <= 0 {
+		return nil, errors.New("SSE_KEEP_ALIVE_INTERVAL must be positive")
+	}
+
+	// Idle sessions are looked for every quarter of the TTL.
+	if settings.LongPollSessionTTL < time.Second {
+		return nil, errors.New("LONG_POLL_SESSION_TTL must be at least 1s")
+	}
+
+	originChecker := server.NewOriginChecker(settings.AllowedOrigins)
 	websocketUpgrader := &websocket.Upgrader{
 		ReadBufferSize:    1024,
 		WriteBufferSize:   1024,
 		CheckOrigin:       originChecker.Check,
 		EnableCompression: true,
+		Subprotocols:      server.WebSocketSubprotocols,
+	}
+
+	keySet, err := buildKeySet(logger, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := buildAPIKeys(settings)
+	if err != nil {
+		return nil, err
 	}
 
-	authenticator := auth.NewAuthenticator(settings.JWTSecret, settings.APIKeys)
+	revocations := auth.NewInMemoryRevocationList()
+	authenticator := auth.NewAuthenticator(
+		keySet,
+		settings.JWTIssuer,
+		settings.JWTAudience,
+		apiKeys,
+		revocations,
+	)
 
 	channelValidator := handler.NewChannelValidator()
-	registry := broadcaster.NewInMemoryRegistry(logger)
+	messageStore, err := buildMessageStore(logger, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := buildRegistry(logger, settings, messageStore)
+	if err != nil {
+		return nil, err
+	}
 
 	heartbeatHandler := handler.NewHeartbeatHandler()
-	subscribeHandler := handler.NewSubscribeHandler(channelValidator, registry)
+	subscribeHandler := handler.NewSubscribeHandler(channelValidator, registry, settings.HistoryReplayLimit)
 	unsubscribeHandler := handler.NewUnsubscribeHandler(channelValidator, registry)
 	publishHandler := handler.NewPublishHandler(channelValidator, registry)
 	authHandler := handler.NewAuthHandler(authenticator)
+	historyHandler := handler.NewHistoryHandler(channelValidator, registry)
+	resumeHandler := handler.NewResumeHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
+	refreshHandler := handler.NewRefreshHandler(authenticator, registry)
+	revokeHandler := handler.NewRevokeHandler(revocations, registry, settings.RevocationTTL)
+
+	// The revocations forwarded by the other nodes are applied like local ones.
+	if clusterRegistry, ok := registry.(*broadcaster.ClusterRegistry); ok {
+		clusterRegistry.OnRevocation(func(revocation broadcaster.Revocation) {
+			revokeHandler.Apply(revocation)
+		})
+	}
 
 	router := server.NewRouter(
 		logger,
@@ -54,6 +133,9 @@ func NewApp(logger *zap.Logger, settings Settings) *App {
 		unsubscribeHandler,
 		publishHandler,
 		authHandler,
+		resumeHandler,
+		presenceHandler,
+		refreshHandler,
 	)
 
 	websocketServer := server.NewWebSocketServer(
@@ -61,24 +143,387 @@ func NewApp(logger *zap.Logger, settings Settings) *App {
 		websocketUpgrader,
 		registry,
 		router,
+		authenticator,
+		settings.TokenExpiryWarning,
+		settings.AuthDeadline,
+		settings.AuthCookie,
 	)
+	sseServer := server.NewSSEServer(
+		logger,
+		registry,
+		router,
+		subscribeHandler,
+		authenticator,
+		originChecker,
+		settings.SSEKeepAliveInterval,
+		settings.AuthCookie,
+	)
+	longPollServer := server.NewLongPollServer(
+		logger,
+		registry,
+		router,
+		authenticator,
+		originChecker,
+		settings.TokenExpiryWarning,
+		settings.AuthDeadline,
+		settings.AuthCookie,
+		settings.LongPollTimeout,
+		settings.LongPollSessionTTL,
+	)
+	// Every node listens to the ingested Postgres channels, so each one only
+	// delivers the notifications to its own connections instead of forwarding
+	// them to the other nodes.
+	ingestRegistry := registry
+	if clusterRegistry, ok := registry.(*broadcaster.ClusterRegistry); ok {
+		ingestRegistry = clusterRegistry.InMemoryRegistry
+	}
+
+	ingestPublishHandler := handler.NewPublishHandler(channelValidator, ingestRegistry)
+	ingester, err := buildIngester(logger, settings, ingestPublishHandler)
+	if err != nil {
+		return nil, err
+	}
+
 	restServer := server.NewRESTServer(
 		logger,
 		publishHandler,
+		historyHandler,
+		presenceHandler,
+		revokeHandler,
 		authenticator,
 	)
 
+	var grpcServer *server.GRPCServer
+	if settings.GRPCPort != 0 {
+		grpcServer = server.NewGRPCServer(
+			logger,
+			registry,
+			publishHandler,
+			subscribeHandler,
+			authenticator,
+		)
+	}
+
+	var mqttServer *server.MQTTServer
+	if settings.MQTTPort != 0 {
+		mqttServer = server.NewMQTTServer(
+			logger,
+			registry,
+			publishHandler,
+			subscribeHandler,
+			unsubscribeHandler,
+			authenticator,
+			settings.AuthDeadline,
+		)
+	}
+
+	var pusherServer *server.PusherServer
+	if settings.PusherKey != "" {
+		if settings.PusherAppId == "" || settings.PusherSecret == "" {
+			return nil, errors.New("PUSHER_APP_ID and PUSHER_SECRET are required with PUSHER_KEY")
+		}
+
+		pusherServer = server.NewPusherServer(
+			logger,
+			websocketUpgrader,
+			registry,
+			router,
+			publishHandler,
+			settings.PusherAppId,
+			settings.PusherKey,
+			settings.PusherSecret,
+			settings.PusherPublicChannels,
+		)
+	}
+
 	return &App{
 		logger,
 		settings,
+		authenticator,
+		messageStore,
+		registry,
+		ingester,
 		websocketServer,
+		sseServer,
+		longPollServer,
 		restServer,
+		grpcServer,
+		mqttServer,
+		pusherServer,
+	}, nil
+}
+
+func buildKeySet(logger *zap.Logger, settings Settings) (auth.KeySet, error) {
+	keySets := auth.KeySets{}
+
+	switch {
+	case settings.JWTSecret != "" && len(settings.JWTSecrets) > 0:
+		return nil, errors.New("JWT_SECRET and JWT_SECRETS cannot both be set")
+	case settings.JWTSecret != "":
+		keySets = append(keySets, auth.NewHMACKeySet(settings.JWTSecret))
+	case len(settings.JWTSecrets) > 0:
+		keySet, err := buildHMACKeySet(settings.JWTSecrets, settings.JWTPrimarySecretId)
+		if err != nil {
+			return nil, err
+		}
+
+		keySets = append(keySets, keySet)
+	}
+
+	if len(settings.JWTPublicKeyFiles) > 0 {
+		keySet, err := auth.LoadPEMKeySet(settings.JWTPublicKeyFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		keySets = append(keySets, keySet)
+	}
+
+	if settings.JWKSURL != "" {
+		keySet := auth.NewJWKSKeySet(
+			logger,
+			settings.JWKSURL,
+			&http.Client{},
+			settings.JWKSRefreshInterval,
+		)
+
+		err := keySet.Start()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+		}
+
+		keySets = append(keySets, keySet)
+	}
+
+	if len(keySets) == 0 {
+		return nil, errors.New("one of JWT_SECRET, JWT_SECRETS, JWT_PUBLIC_KEY_FILES or JWKS_URL is required")
+	}
+
+	return keySets, nil
+}
+
+// buildAPIKeys loads the keys of API_KEYS_FILE. The plaintext keys of
+// API_KEYS are still accepted as admin keys named `api`.
+func buildAPIKeys(settings Settings) ([]auth.APIKey, error) {
+	apiKeys := []auth.APIKey{}
+
+	if settings.APIKeysFile != "" {
+		fileKeys, err := auth.LoadAPIKeys(settings.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeys = append(apiKeys, fileKeys...)
+	}
+
+	for _, apiKey := range settings.APIKeys {
+		apiKeys = append(apiKeys, auth.APIKey{
+			Name:  "api",
+			Hash:  auth.HashAPIKey(apiKey),
+			Scope: []string{auth.ScopePublish, auth.ScopeAdmin},
+		})
+	}
+
+	if len(apiKeys) == 0 {
+		return nil, errors.New("one of API_KEYS_FILE or API_KEYS is required")
+	}
+
+	return apiKeys, nil
+}
+
+// buildHMACKeySet parses secrets given as `id:secret`. The first one is the
+// primary secret unless another id is given.
+func buildHMACKeySet(secrets []string, primaryKeyId string) (*auth.HMACKeySet, error) {
+	keys := make([]auth.HMACKey, 0, len(secrets))
+	for _, secret := range secrets {
+		id, secret, ok := strings.Cut(secret, ":")
+		if !ok {
+			return nil, errors.New("JWT_SECRETS entries must be formatted as id:secret")
+		}
+
+		keys = append(keys, auth.HMACKey{Id: id, Secret: secret})
+	}
+
+	if primaryKeyId == "" {
+		primaryKeyId = keys[0].Id
+	}
+
+	return auth.NewNamedHMACKeySet(keys, primaryKeyId)
+}
+
+func buildMessageStore(logger *zap.Logger, settings Settings) (broadcaster.MessageStore, error) {
+	switch settings.HistoryStore {
+	case "memory":
+		return broadcaster.NewInMemoryMessageStore(settings.HistorySize, settings.HistoryTTL), nil
+	case "file":
+		return broadcaster.OpenFileMessageStore(
+			logger,
+			settings.HistoryDir,
+			settings.HistorySegmentBytes,
+			settings.HistoryRetentionBytes,
+			settings.HistoryTTL,
+			time.Minute,
+			settings.HistorySyncInterval,
+		)
+	default:
+		return nil, fmt.Errorf("unknown history store: %s", settings.HistoryStore)
+	}
+}
+
+func buildRegistry(
+	logger *zap.Logger,
+	settings Settings,
+	messageStore broadcaster.MessageStore,
+) (broadcaster.Registry, error) {
+	registry := broadcaster.NewInMemoryRegistry(logger, messageStore, settings.ResumeTTL)
+
+	nodeId := settings.ClusterNodeId
+	if nodeId == "" {
+		nodeId = gonanoid.Must()
+	}
+
+	var bus broadcaster.Bus
+	switch settings.ClusterBus {
+	case "":
+		return registry, nil
+	case "redis":
+		options, err := redis.ParseURL(settings.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+
+		bus = cluster.NewRedisBus(logger, nodeId, settings.RedisPrefix, redis.NewClient(options))
+	case "nats":
+		conn, err := nats.Connect(settings.NATSURL,
+			nats.Name("broadcaster "+nodeId),
+			nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to nats: %w", err)
+		}
+
+		bus = cluster.NewNATSBus(logger, nodeId, settings.NATSPrefix, conn)
+	case "peer":
+		if settings.ClusterSecret == "" {
+			return nil, errors.New("CLUSTER_SECRET is required for the peer cluster bus")
+		}
+
+		var discovery cluster.PeerDiscovery = cluster.StaticPeers(settings.ClusterPeers)
+		if settings.ClusterPeersSRV != "" {
+			discovery = cluster.NewSRVPeers(settings.ClusterPeersSRV)
+		}
+
+		listener, err := net.Listen("tcp", settings.ClusterListenAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for cluster peers: %w", err)
+		}
+
+		bus = cluster.NewPeerBus(
+			logger,
+			nodeId,
+			settings.ClusterSecret,
+			listener,
+			discovery,
+			settings.ClusterDiscoveryInterval,
+		)
+	case "postgres":
+		connConfig, err := pgx.ParseConfig(settings.PostgresURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid postgres url: %w", err)
+		}
+
+		pool, err := pgxpool.New(context.Background(), settings.PostgresURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create postgres pool: %w", err)
+		}
+
+		listener := pglisten.NewListener(logger, connConfig, []string{settings.PostgresBusChannel})
+		bus = cluster.NewPostgresBus(logger, nodeId, settings.PostgresBusChannel, pool, listener)
+	default:
+		return nil, fmt.Errorf("unknown cluster bus: %s", settings.ClusterBus)
 	}
+
+	logger.Info("joining cluster",
+		zap.String("bus", settings.ClusterBus),
+		zap.String("nodeId", nodeId))
+
+	return broadcaster.NewClusterRegistry(logger, registry, bus)
+}
+
+func buildIngester(
+	logger *zap.Logger,
+	settings Settings,
+	publishHandler handler.PublishHandlerInterface,
+) (*ingest.PostgresIngester, error) {
+	if len(settings.PostgresIngestChannels) == 0 {
+		return nil, nil
+	}
+
+	connConfig, err := pgx.ParseConfig(settings.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres url: %w", err)
+	}
+
+	listener := pglisten.NewListener(logger, connConfig, settings.PostgresIngestChannels)
+	ingester := ingest.NewPostgresIngester(
+		logger,
+		listener,
+		publishHandler,
+		settings.PostgresIngestMaxPayloadBytes,
+	)
+	ingester.Start()
+
+	return ingester, nil
 }
 
 func (a *App) setup(ctx context.Context) error {
+	err := a.startGRPCServer()
+	if err != nil {
+		return err
+	}
+
+	err = a.startMQTTServer()
+	if err != nil {
+		return err
+	}
+
 	a.startHttpServer(ctx)
 
+	if a.grpcServer != nil {
+		a.stopGRPCServer()
+	}
+
+	if a.mqttServer != nil {
+		a.logger.Info("stopping mqtt server")
+
+		err = a.mqttServer.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close mqtt server: %w", err)
+		}
+	}
+
+	if a.ingester != nil {
+		a.ingester.Close()
+	}
+
+	err = a.authenticator.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close authenticator: %w", err)
+	}
+
+	if closer, ok := a.registry.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close registry: %w", err)
+		}
+	}
+
+	if closer, ok := a.messageStore.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close message store: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -88,16 +533,25 @@ func (a *App) startHttpServer(ctx context.Context) {
 
 	address := fmt.Sprintf("0.0.0.0:%d", a.settings.Port)
 
-	router := mux.NewRouter().
+	rootRouter := mux.NewRouter()
+
+	// Pusher clients expect the Pusher routes at the root of the server.
+	if a.pusherServer != nil {
+		a.pusherServer.Register(rootRouter)
+	}
+
+	router := rootRouter.
 		PathPrefix(a.settings.BasePath).
 		Subrouter()
 
 	a.websocketServer.Register(router)
+	a.sseServer.Register(router)
+	a.longPollServer.Register(router)
 	a.restServer.Register(router)
 
 	httpServer := &http.Server{
 		Addr:    address,
-		Handler: router,
+		Handler: rootRouter,
 	}
 
 	a.logger.Info("starting http server",
@@ -128,6 +582,71 @@ func (a *App) startHttpServer(ctx context.Context) {
 	a.logger.Info("http server stopped")
 }
 
+// startGRPCServer serves the gRPC API when GRPC_PORT is set.
+func (a *App) startGRPCServer() error {
+	if a.grpcServer == nil {
+		return nil
+	}
+
+	address := fmt.Sprintf("0.0.0.0:%d", a.settings.GRPCPort)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for grpc: %w", err)
+	}
+
+	a.logger.Info("starting grpc server",
+		zap.String("address", address))
+
+	go func() {
+		err := a.grpcServer.Serve(listener)
+		if err != nil {
+			a.logger.Fatal("failed to start grpc server",
+				zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+func (a *App) stopGRPCServer() {
+	a.logger.Info("stopping grpc server")
+
+	shutdownCtx, shutdownCtxCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCtxCancel()
+
+	a.grpcServer.Shutdown(shutdownCtx)
+
+	a.logger.Info("grpc server stopped")
+}
+
+// startMQTTServer serves MQTT clients when MQTT_PORT is set.
+func (a *App) startMQTTServer() error {
+	if a.mqttServer == nil {
+		return nil
+	}
+
+	address := fmt.Sprintf("0.0.0.0:%d", a.settings.MQTTPort)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for mqtt: %w", err)
+	}
+
+	a.logger.Info("starting mqtt server",
+		zap.String("address", address))
+
+	go func() {
+		err := a.mqttServer.Serve(listener)
+		if err != nil {
+			a.logger.Fatal("failed to start mqtt server",
+				zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
 func main() {
 	ctx := context.Background()
 
@@ -140,7 +659,10 @@ func main() {
 	logger, err := buildZapLogger(settings.LogEncoding)
 	defer logger.Sync()
 
-	app := NewApp(logger, settings)
+	app, err := NewApp(logger, settings)
+	if err != nil {
+		logger.Fatal("failed to create app", zap.Error(err))
+	}
 
 	err = app.setup(ctx)
 	if err != nil {
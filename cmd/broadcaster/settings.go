@@ -1,9 +1,63 @@
 package main
 
+import "time"
+
 type Settings struct {
 	Port        int      `env:"PORT,default=8000"`
+	GRPCPort    int      `env:"GRPC_PORT"`
+	MQTTPort    int      `env:"MQTT_PORT"`
 	LogEncoding string   `env:"LOG_ENCODING,default=console"`
-	JWTSecret   string   `env:"JWT_SECRET,required=true"`
-	APIKeys     []string `env:"API_KEYS,required=true"`
+	APIKeys     []string `env:"API_KEYS"`
+	APIKeysFile string   `env:"API_KEYS_FILE"`
 	BasePath    string   `env:"BASE_PATH,default=/broadcaster"`
+
+	JWTSecret           string        `env:"JWT_SECRET"`
+	JWTSecrets          []string      `env:"JWT_SECRETS"`
+	JWTPrimarySecretId  string        `env:"JWT_PRIMARY_SECRET_ID"`
+	JWTPublicKeyFiles   []string      `env:"JWT_PUBLIC_KEY_FILES"`
+	JWKSURL             string        `env:"JWKS_URL"`
+	JWKSRefreshInterval time.Duration `env:"JWKS_REFRESH_INTERVAL,default=1h"`
+	JWTIssuer           string        `env:"JWT_ISSUER"`
+	JWTAudience         string        `env:"JWT_AUDIENCE,default=broadcaster"`
+	TokenExpiryWarning  time.Duration `env:"TOKEN_EXPIRY_WARNING,default=1m"`
+	RevocationTTL       time.Duration `env:"REVOCATION_TTL,default=24h"`
+	AuthDeadline        time.Duration `env:"AUTH_DEADLINE,default=10s"`
+	AuthCookie          string        `env:"AUTH_COOKIE"`
+	AllowedOrigins      []string      `env:"ALLOWED_ORIGINS"`
+
+	SSEKeepAliveInterval time.Duration `env:"SSE_KEEP_ALIVE_INTERVAL,default=15s"`
+	LongPollTimeout      time.Duration `env:"LONG_POLL_TIMEOUT,default=25s"`
+	LongPollSessionTTL   time.Duration `env:"LONG_POLL_SESSION_TTL,default=1m"`
+
+	PusherAppId          string   `env:"PUSHER_APP_ID"`
+	PusherKey            string   `env:"PUSHER_KEY"`
+	PusherSecret         string   `env:"PUSHER_SECRET"`
+	PusherPublicChannels []string `env:"PUSHER_PUBLIC_CHANNELS"`
+
+	HistoryStore          string        `env:"HISTORY_STORE,default=memory"`
+	HistorySize           int           `env:"HISTORY_SIZE,default=100"`
+	HistoryTTL            time.Duration `env:"HISTORY_TTL,default=1h"`
+	HistoryReplayLimit    int           `env:"HISTORY_REPLAY_LIMIT,default=500"`
+	HistoryDir            string        `env:"HISTORY_DIR,default=data/history"`
+	HistorySegmentBytes   int64         `env:"HISTORY_SEGMENT_BYTES,default=1048576"`
+	HistoryRetentionBytes int64         `env:"HISTORY_RETENTION_BYTES,default=67108864"`
+	HistorySyncInterval   time.Duration `env:"HISTORY_SYNC_INTERVAL,default=0s"`
+	ResumeTTL             time.Duration `env:"RESUME_TTL,default=2m"`
+
+	ClusterBus               string        `env:"CLUSTER_BUS"`
+	ClusterNodeId            string        `env:"CLUSTER_NODE_ID"`
+	ClusterListenAddress     string        `env:"CLUSTER_LISTEN_ADDRESS,default=0.0.0.0:7946"`
+	ClusterPeers             []string      `env:"CLUSTER_PEERS"`
+	ClusterPeersSRV          string        `env:"CLUSTER_PEERS_SRV"`
+	ClusterSecret            string        `env:"CLUSTER_SECRET"`
+	ClusterDiscoveryInterval time.Duration `env:"CLUSTER_DISCOVERY_INTERVAL,default=30s"`
+	RedisURL                 string        `env:"REDIS_URL,default=redis://localhost:6379/0"`
+	RedisPrefix              string        `env:"REDIS_PREFIX,default=broadcaster:"`
+	NATSURL                  string        `env:"NATS_URL,default=nats://localhost:4222"`
+	NATSPrefix               string        `env:"NATS_PREFIX,default=broadcaster"`
+
+	PostgresURL                   string   `env:"POSTGRES_URL"`
+	PostgresBusChannel            string   `env:"POSTGRES_BUS_CHANNEL,default=broadcaster"`
+	PostgresIngestChannels        []string `env:"POSTGRES_INGEST_CHANNELS"`
+	PostgresIngestMaxPayloadBytes int      `env:"POSTGRES_INGEST_MAX_PAYLOAD_BYTES,default=7999"`
 }
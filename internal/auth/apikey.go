@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeSubscribe = "subscribe"
+	ScopePublish   = "publish"
+	ScopeHistory   = "history"
+	ScopePresence  = "presence"
+	// ScopeAdmin grants every scope on every channel.
+	ScopeAdmin = "admin"
+)
+
+var apiKeyScopes = []string{ScopeSubscribe, ScopePublish, ScopeHistory, ScopePresence, ScopeAdmin}
+
+const apiKeyHashPrefix = "sha256:"
+
+// APIKey is a key of a server, identified by its name in the logs. Only the
+// hash of the key is configured.
+type APIKey struct {
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 digest of the key, prefixed with
+	// `sha256:`.
+	Hash     string   `json:"hash"`
+	Scope    []string `json:"scope"`
+	Channels []string `json:"channels,omitempty"`
+}
+
+// HashAPIKey returns the hash to configure for a key.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func (k APIKey) IsAdmin() bool {
+	return slices.Contains(k.Scope, ScopeAdmin)
+}
+
+func (k APIKey) Validate() error {
+	if k.Name == "" {
+		return errors.New("api key name cannot be empty")
+	}
+
+	digest, ok := strings.CutPrefix(k.Hash, apiKeyHashPrefix)
+	if !ok {
+		return fmt.Errorf("api key %s: hash must start with %s", k.Name, apiKeyHashPrefix)
+	}
+
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("api key %s: invalid sha256 hash", k.Name)
+	}
+
+	if len(k.Scope) == 0 {
+		return fmt.Errorf("api key %s: scope cannot be empty", k.Name)
+	}
+
+	for _, scope := range k.Scope {
+		if !slices.Contains(apiKeyScopes, scope) {
+			return fmt.Errorf("api key %s: unknown scope %s", k.Name, scope)
+		}
+	}
+
+	if !k.IsAdmin() && len(k.Channels) == 0 {
+		return fmt.Errorf("api key %s: channels cannot be empty", k.Name)
+	}
+
+	_, err = compileChannelMatcher(k.Name, k.Channels)
+	if err != nil {
+		return fmt.Errorf("api key %s: invalid channels: %w", k.Name, err)
+	}
+
+	return nil
+}
+
+// LoadAPIKeys reads a JSON array of API keys.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+
+	var apiKeys []APIKey
+	err = json.Unmarshal(data, &apiKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api keys: %w", err)
+	}
+
+	hashes := make(map[string]struct{}, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		err := apiKey.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		hash := strings.ToLower(apiKey.Hash)
+		if _, ok := hashes[hash]; ok {
+			return nil, fmt.Errorf("api key %s: duplicate hash", apiKey.Name)
+		}
+		hashes[hash] = struct{}{}
+	}
+
+	return apiKeys, nil
+}
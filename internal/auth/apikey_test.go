@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	load := func(content string) ([]APIKey, error) {
+		path := filepath.Join(dir, "api-keys.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		return LoadAPIKeys(path)
+	}
+
+	hash := HashAPIKey("partner-api-key")
+
+	t.Run("valid", func(t *testing.T) {
+		apiKeys, err := load(`[
+			{"name": "partner", "hash": "` + hash + `", "scope": ["publish"], "channels": ["partner:*"]},
+			{"name": "ops", "hash": "` + HashAPIKey("ops-api-key") + `", "scope": ["admin"]}
+		]`)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []APIKey{
+			{Name: "partner", Hash: hash, Scope: []string{"publish"}, Channels: []string{"partner:*"}},
+			{Name: "ops", Hash: HashAPIKey("ops-api-key"), Scope: []string{"admin"}},
+		}, apiKeys)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"not json":         `{`,
+			"missing name":     `[{"hash": "` + hash + `", "scope": ["publish"], "channels": ["a"]}]`,
+			"plaintext key":    `[{"name": "a", "hash": "partner-api-key", "scope": ["publish"], "channels": ["a"]}]`,
+			"short hash":       `[{"name": "a", "hash": "sha256:abcd", "scope": ["publish"], "channels": ["a"]}]`,
+			"unknown scope":    `[{"name": "a", "hash": "` + hash + `", "scope": ["write"], "channels": ["a"]}]`,
+			"missing scope":    `[{"name": "a", "hash": "` + hash + `", "channels": ["a"]}]`,
+			"missing channels": `[{"name": "a", "hash": "` + hash + `", "scope": ["publish"]}]`,
+			"invalid channels": `[{"name": "a", "hash": "` + hash + `", "scope": ["publish"], "channels": ["a::*"]}]`,
+			"duplicate hash": `[
+				{"name": "a", "hash": "` + hash + `", "scope": ["admin"]},
+				{"name": "b", "hash": "` + hash + `", "scope": ["admin"]}
+			]`,
+		} {
+			_, err := load(content)
+			assert.Error(t, err, name)
+		}
+	})
+}
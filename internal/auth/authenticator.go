@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
-	"crypto/subtle"
 	"errors"
+	"fmt"
+	"io"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/goevery/broadcaster/internal/ierr"
@@ -15,23 +18,46 @@ type Claims struct {
 	jwt.RegisteredClaims
 	AuthorizedChannels []string `json:"authorizedChannels,omitempty"`
 	Scope              []string `json:"scope,omitempty"`
+	// Permissions maps channels and patterns to the actions granted on them.
+	// When set, it replaces AuthorizedChannels and Scope.
+	Permissions map[string][]string `json:"permissions,omitempty"`
 }
 
 type Authentication struct {
-	Subject            string
+	Subject string
+	// AuthorizedChannels lists channels and patterns, which may refer to the
+	// subject with `{sub}`. It is compiled on first use and must not be
+	// changed afterwards.
 	AuthorizedChannels []string
 	Scope              []string
-	IsAdmin            bool
+	// Permissions maps channels and patterns to the actions granted on them,
+	// instead of granting the actions of Scope on every authorized channel.
+	// Like AuthorizedChannels, it must not be changed once used.
+	Permissions map[string][]string
+	IsAdmin     bool
+	// TokenId is the `jti` claim of the token, if any.
+	TokenId string
+	// ExpireTime is when the token expires. It is zero for API keys.
+	ExpireTime time.Time
+
+	matcherOnce sync.Once
+	matcher     *permissionMatcher
 }
 
 func (a *Authentication) IsPublisher() bool {
-	return slices.Contains(a.Scope, "publish")
+	return slices.Contains(a.Scope, ScopePublish)
 }
 
 func (a *Authentication) IsSubscriber() bool {
-	return slices.Contains(a.Scope, "subscribe")
+	return slices.Contains(a.Scope, ScopeSubscribe)
+}
+
+// HasScope reports whether the scope is granted, which admins always are.
+func (a *Authentication) HasScope(scope string) bool {
+	return a.IsAdmin || slices.Contains(a.Scope, scope)
 }
 
+// IsAuthorized reports whether any action is granted on the channel.
 func (a *Authentication) IsAuthorized(channel string) bool {
 	if a.Subject == "" {
 		return false
@@ -41,7 +67,37 @@ func (a *Authentication) IsAuthorized(channel string) bool {
 		return true
 	}
 
-	return slices.Contains(a.AuthorizedChannels, channel)
+	return a.permissionMatcher().channels.authorizes(channel)
+}
+
+// Can reports whether the action, one of subscribe, publish, presence and
+// history, is granted on the channel.
+func (a *Authentication) Can(action string, channel string) bool {
+	if a.Subject == "" {
+		return false
+	}
+
+	if a.IsAdmin {
+		return true
+	}
+
+	matcher := a.permissionMatcher()
+
+	// Without permissions, the scope grants its actions on every authorized
+	// channel.
+	if matcher.actions == nil && !a.HasScope(action) {
+		return false
+	}
+
+	return matcher.allows(action, channel)
+}
+
+func (a *Authentication) permissionMatcher() *permissionMatcher {
+	a.matcherOnce.Do(func() {
+		a.matcher, _ = compilePermissionMatcher(a.Subject, a.AuthorizedChannels, a.Permissions)
+	})
+
+	return a.matcher
 }
 
 type contextKey string
@@ -58,32 +114,85 @@ func AuthenticationFromContext(ctx context.Context) (*Authentication, bool) {
 }
 
 type Authenticator struct {
-	secret    []byte
-	apiKeys   []string
-	jwtParser *jwt.Parser
+	keySet      KeySet
+	apiKeys     map[string]APIKey
+	revocations RevocationList
+	jwtParser   *jwt.Parser
 }
 
-func NewAuthenticator(secret string, apiKeys []string) *Authenticator {
-	jwtParser := jwt.NewParser(
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
-		jwt.WithLeeway(30*time.Second),
+// NewAuthenticator verifies JWTs with the keys of the key set. The issuer and
+// audience claims are only checked when they are set.
+func NewAuthenticator(
+	keySet KeySet,
+	issuer string,
+	audience string,
+	apiKeys []APIKey,
+	revocations RevocationList,
+) *Authenticator {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{
+			jwt.SigningMethodHS256.Alg(),
+			jwt.SigningMethodRS256.Alg(),
+			jwt.SigningMethodES256.Alg(),
+			jwt.SigningMethodEdDSA.Alg(),
+		}),
+		jwt.WithLeeway(30 * time.Second),
 		jwt.WithExpirationRequired(),
 		jwt.WithIssuedAt(),
-		jwt.WithAudience("broadcaster"),
-	)
+	}
+
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	apiKeysByHash := make(map[string]APIKey, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		apiKeysByHash[strings.ToLower(apiKey.Hash)] = apiKey
+	}
 
 	return &Authenticator{
-		secret:    []byte(secret),
-		apiKeys:   apiKeys,
-		jwtParser: jwtParser,
+		keySet:      keySet,
+		apiKeys:     apiKeysByHash,
+		revocations: revocations,
+		jwtParser:   jwt.NewParser(options...),
 	}
 }
 
+// Close releases the key set, such as the background refresh of a JWKS.
+func (a *Authenticator) Close() error {
+	if closer, ok := a.keySet.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
 func (a *Authenticator) keyFunc(token *jwt.Token) (any, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-		return nil, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("unexpected signing method"))
+	keyId, _ := token.Header["kid"].(string)
+
+	keys, err := a.keySet.Keys(keyId, token.Method)
+	if err != nil {
+		return nil, err
 	}
-	return a.secret, nil
+
+	if len(keys) == 0 {
+		return nil, errors.New("no key found for token")
+	}
+
+	// Tokens without a key id may match several keys, so the key that verifies
+	// the signature is picked here.
+	signingString := token.Raw[:strings.LastIndex(token.Raw, ".")]
+	for _, key := range keys {
+		if token.Method.Verify(signingString, token.Signature, key) == nil {
+			return key, nil
+		}
+	}
+
+	return keys[0], nil
 }
 
 func (a *Authenticator) AuthenticateJWT(tokenString string) (*Authentication, error) {
@@ -99,28 +208,69 @@ func (a *Authenticator) AuthenticateJWT(tokenString string) (*Authentication, er
 		return nil, ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid subject claim"))
 	}
 
-	if len(claims.AuthorizedChannels) == 0 {
+	// Tokens without an issue time are considered issued before any
+	// revocation of their subject.
+	var issueTime time.Time
+	if claims.IssuedAt != nil {
+		issueTime = claims.IssuedAt.Time
+	}
+
+	if a.revocations.IsRevoked(claims.ID, subject, issueTime) {
+		return nil, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("token has been revoked"))
+	}
+
+	authorizedChannels := claims.AuthorizedChannels
+	if len(claims.Permissions) > 0 {
+		authorizedChannels = nil
+	} else if len(authorizedChannels) == 0 {
 		return nil, ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("authorized channels cannot be empty"))
 	}
 
-	return &Authentication{
+	matcher, err := compilePermissionMatcher(subject, authorizedChannels, claims.Permissions)
+	if err != nil && len(claims.Permissions) > 0 {
+		return nil, ierr.New(ierr.ErrorCodeInvalidArgument, fmt.Errorf("invalid permissions: %w", err))
+	} else if err != nil {
+		return nil, ierr.New(ierr.ErrorCodeInvalidArgument, fmt.Errorf("invalid authorized channels: %w", err))
+	}
+
+	authentication := &Authentication{
 		Subject:            subject,
-		AuthorizedChannels: claims.AuthorizedChannels,
+		AuthorizedChannels: authorizedChannels,
 		Scope:              claims.Scope,
+		Permissions:        claims.Permissions,
 		IsAdmin:            false,
-	}, nil
+		TokenId:            claims.ID,
+		ExpireTime:         claims.ExpiresAt.Time,
+	}
+	authentication.matcherOnce.Do(func() {
+		authentication.matcher = matcher
+	})
+
+	return authentication, nil
 }
 
 func (a *Authenticator) AuthenticateAPIKey(apiKey string) (*Authentication, error) {
-	for _, key := range a.apiKeys {
-		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
-			return &Authentication{
-				Subject: "api",
-				Scope:   []string{"publish"},
-				IsAdmin: true,
-			}, nil
-		}
+	// Keys are looked up by hash, so the lookup time reveals nothing about the
+	// configured keys.
+	key, ok := a.apiKeys[HashAPIKey(apiKey)]
+	if !ok {
+		return nil, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("invalid api key"))
+	}
+
+	matcher, err := compilePermissionMatcher(key.Name, key.Channels, nil)
+	if err != nil {
+		return nil, ierr.New(ierr.ErrorCodeInvalidArgument, fmt.Errorf("invalid api key channels: %w", err))
+	}
+
+	authentication := &Authentication{
+		Subject:            key.Name,
+		AuthorizedChannels: key.Channels,
+		Scope:              key.Scope,
+		IsAdmin:            key.IsAdmin(),
 	}
+	authentication.matcherOnce.Do(func() {
+		authentication.matcher = matcher
+	})
 
-	return nil, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("invalid api key"))
+	return authentication, nil
 }
@@ -9,8 +9,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var testAPIKeys = []APIKey{
+	{Name: "api", Hash: HashAPIKey("test-api-key"), Scope: []string{ScopePublish, ScopeAdmin}},
+	{Name: "partner", Hash: HashAPIKey("partner-api-key"), Scope: []string{ScopePublish, ScopeHistory}, Channels: []string{"partner:*", "partner-{sub}"}},
+}
+
 func TestAuthenticator_AuthenticateJWT(t *testing.T) {
-	authenticator := NewAuthenticator("test-secret", []string{"test-api-key"})
+	authenticator := NewAuthenticator(NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, NewInMemoryRevocationList())
 
 	t.Run("valid jwt", func(t *testing.T) {
 		claims := jwt.MapClaims{
@@ -118,8 +123,202 @@ func TestAuthenticator_AuthenticateJWT(t *testing.T) {
 	})
 }
 
+func TestAuthenticator_AuthenticateJWT_ChannelPatterns(t *testing.T) {
+	authenticator := NewAuthenticator(NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, NewInMemoryRevocationList())
+
+	authenticate := func(subject string, authorizedChannels []string) (*Authentication, error) {
+		claims := jwt.MapClaims{
+			"sub":                subject,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": authorizedChannels,
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		return authenticator.AuthenticateJWT(tokenString)
+	}
+
+	t.Run("wildcards", func(t *testing.T) {
+		auth, err := authenticate("test-user", []string{"org:42:*", "team:7:**", "lobby"})
+		assert.NoError(t, err)
+
+		assert.True(t, auth.IsAuthorized("lobby"))
+		assert.True(t, auth.IsAuthorized("org:42:room"))
+		assert.False(t, auth.IsAuthorized("org:42"))
+		assert.False(t, auth.IsAuthorized("org:42:room:7"))
+		assert.False(t, auth.IsAuthorized("org:43:room"))
+		assert.True(t, auth.IsAuthorized("team:7:room"))
+		assert.True(t, auth.IsAuthorized("team:7:room:1"))
+		assert.False(t, auth.IsAuthorized("team:7"))
+	})
+
+	t.Run("requested patterns", func(t *testing.T) {
+		auth, err := authenticate("test-user", []string{"org:42:*", "team:7:**"})
+		assert.NoError(t, err)
+
+		assert.True(t, auth.IsAuthorized("org:42:*"))
+		assert.False(t, auth.IsAuthorized("org:42:**"))
+		assert.False(t, auth.IsAuthorized("org:*:room"))
+		assert.True(t, auth.IsAuthorized("team:7:*"))
+		assert.True(t, auth.IsAuthorized("team:7:**"))
+		assert.True(t, auth.IsAuthorized("team:7:*:chat"))
+		assert.False(t, auth.IsAuthorized("team:**"))
+	})
+
+	t.Run("subject interpolation", func(t *testing.T) {
+		auth, err := authenticate("alice", []string{"user:{sub}:*", "dm:{sub}"})
+		assert.NoError(t, err)
+
+		assert.True(t, auth.IsAuthorized("user:alice:inbox"))
+		assert.True(t, auth.IsAuthorized("dm:alice"))
+		assert.False(t, auth.IsAuthorized("user:bob:inbox"))
+		assert.False(t, auth.IsAuthorized("user:{sub}:inbox"))
+	})
+
+	t.Run("subject that is not a segment", func(t *testing.T) {
+		for _, subject := range []string{"alice:admin", "*", "**"} {
+			auth, err := authenticate(subject, []string{"user:{sub}:*"})
+
+			assert.Nil(t, auth, subject)
+			if assert.Error(t, err, subject) {
+				assert.Equal(t, ierr.ErrorCodeInvalidArgument, err.(ierr.Error).Code)
+			}
+		}
+	})
+
+	t.Run("empty segments", func(t *testing.T) {
+		auth, err := authenticate("test-user", []string{"org:*", "team:**"})
+		assert.NoError(t, err)
+
+		assert.False(t, auth.IsAuthorized("org:"))
+		assert.False(t, auth.IsAuthorized("org::"))
+		assert.False(t, auth.IsAuthorized("team:"))
+		assert.False(t, auth.IsAuthorized("team::room"))
+		assert.False(t, auth.IsAuthorized("team:room:"))
+
+		for _, authorizedChannel := range []string{"org::*", "org:*:", ":*", "org:**:room"} {
+			auth, err := authenticate("test-user", []string{authorizedChannel})
+
+			assert.Nil(t, auth, authorizedChannel)
+			if assert.Error(t, err, authorizedChannel) {
+				assert.Equal(t, ierr.ErrorCodeInvalidArgument, err.(ierr.Error).Code)
+			}
+		}
+	})
+
+	t.Run("compiled once", func(t *testing.T) {
+		auth := &Authentication{Subject: "test-user", AuthorizedChannels: []string{"org:42:*"}}
+
+		assert.True(t, auth.IsAuthorized("org:42:room"))
+		matcher := auth.matcher
+
+		assert.False(t, auth.IsAuthorized("org:43:room"))
+		assert.Same(t, matcher, auth.matcher)
+	})
+}
+
+func TestAuthenticator_AuthenticateJWT_Permissions(t *testing.T) {
+	authenticator := NewAuthenticator(NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, NewInMemoryRevocationList())
+
+	authenticate := func(claims jwt.MapClaims) (*Authentication, error) {
+		claims["sub"] = "alice"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		claims["iat"] = time.Now().Unix()
+		claims["aud"] = "broadcaster"
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		return authenticator.AuthenticateJWT(tokenString)
+	}
+
+	t.Run("actions per channel", func(t *testing.T) {
+		auth, err := authenticate(jwt.MapClaims{
+			"permissions": map[string][]string{
+				"room:*":            {"subscribe", "presence", "history"},
+				"user:{sub}:typing": {"publish"},
+				"announcements":     {"subscribe"},
+				"room:lobby":        {"publish"},
+			},
+		})
+		assert.NoError(t, err)
+
+		assert.True(t, auth.Can(ScopeSubscribe, "room:42"))
+		assert.True(t, auth.Can(ScopeSubscribe, "room:*"))
+		assert.True(t, auth.Can(ScopePresence, "room:42"))
+		assert.True(t, auth.Can(ScopeHistory, "room:42"))
+		assert.False(t, auth.Can(ScopePublish, "room:42"))
+		assert.True(t, auth.Can(ScopePublish, "room:lobby"))
+		assert.True(t, auth.Can(ScopeSubscribe, "room:lobby"))
+		assert.True(t, auth.Can(ScopePublish, "user:alice:typing"))
+		assert.False(t, auth.Can(ScopeSubscribe, "user:alice:typing"))
+		assert.False(t, auth.Can(ScopePublish, "user:bob:typing"))
+		assert.True(t, auth.Can(ScopeSubscribe, "announcements"))
+		assert.False(t, auth.Can(ScopeHistory, "announcements"))
+
+		assert.True(t, auth.IsAuthorized("user:alice:typing"))
+		assert.False(t, auth.IsAuthorized("user:bob:typing"))
+	})
+
+	t.Run("permissions replace authorized channels and scope", func(t *testing.T) {
+		auth, err := authenticate(jwt.MapClaims{
+			"authorizedChannels": []string{"lobby"},
+			"scope":              []string{"subscribe", "publish"},
+			"permissions":        map[string][]string{"room:42": {"subscribe"}},
+		})
+		assert.NoError(t, err)
+
+		assert.Empty(t, auth.AuthorizedChannels)
+		assert.False(t, auth.IsAuthorized("lobby"))
+		assert.False(t, auth.Can(ScopePublish, "room:42"))
+		assert.True(t, auth.Can(ScopeSubscribe, "room:42"))
+	})
+
+	t.Run("authorized channels and scope", func(t *testing.T) {
+		auth, err := authenticate(jwt.MapClaims{
+			"authorizedChannels": []string{"room:*"},
+			"scope":              []string{"subscribe", "history"},
+		})
+		assert.NoError(t, err)
+
+		assert.True(t, auth.Can(ScopeSubscribe, "room:42"))
+		assert.False(t, auth.Can(ScopePublish, "room:42"))
+		assert.False(t, auth.Can(ScopePresence, "room:42"))
+		assert.True(t, auth.Can(ScopeHistory, "room:42"))
+		assert.False(t, auth.Can(ScopeSubscribe, "lobby"))
+		assert.False(t, auth.Can(ScopeHistory, "lobby"))
+	})
+
+	t.Run("invalid permissions", func(t *testing.T) {
+		for name, permissions := range map[string]map[string][]string{
+			"empty actions":   {"room:42": {}},
+			"unknown action":  {"room:42": {"subscribe", "admin"}},
+			"invalid channel": {"room::*": {"subscribe"}},
+		} {
+			auth, err := authenticate(jwt.MapClaims{"permissions": permissions})
+
+			assert.Nil(t, auth, name)
+			if assert.Error(t, err, name) {
+				assert.Equal(t, ierr.ErrorCodeInvalidArgument, err.(ierr.Error).Code, name)
+			}
+		}
+	})
+
+	t.Run("admin api key", func(t *testing.T) {
+		auth, err := authenticator.AuthenticateAPIKey("test-api-key")
+		assert.NoError(t, err)
+
+		assert.True(t, auth.Can(ScopePublish, "room:42"))
+		assert.True(t, auth.Can(ScopeSubscribe, "room:42"))
+	})
+}
+
 func TestAuthenticator_AuthenticateAPIKey(t *testing.T) {
-	authenticator := NewAuthenticator("test-secret", []string{"test-api-key"})
+	authenticator := NewAuthenticator(NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, NewInMemoryRevocationList())
 
 	t.Run("valid api key", func(t *testing.T) {
 		auth, err := authenticator.AuthenticateAPIKey("test-api-key")
@@ -127,8 +326,26 @@ func TestAuthenticator_AuthenticateAPIKey(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, auth)
 		assert.Equal(t, "api", auth.Subject)
-		assert.Equal(t, []string{"publish"}, auth.Scope)
+		assert.Equal(t, []string{"publish", "admin"}, auth.Scope)
 		assert.True(t, auth.IsAdmin)
+		assert.True(t, auth.HasScope(ScopePresence))
+	})
+
+	t.Run("scoped api key", func(t *testing.T) {
+		auth, err := authenticator.AuthenticateAPIKey("partner-api-key")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "partner", auth.Subject)
+		assert.False(t, auth.IsAdmin)
+		assert.True(t, auth.HasScope(ScopePublish))
+		assert.True(t, auth.HasScope(ScopeHistory))
+		assert.False(t, auth.HasScope(ScopePresence))
+		assert.True(t, auth.IsAuthorized("partner:news"))
+		assert.True(t, auth.IsAuthorized("partner-partner"))
+		assert.False(t, auth.IsAuthorized("news"))
+		assert.True(t, auth.Can(ScopeHistory, "partner:news"))
+		assert.False(t, auth.Can(ScopePresence, "partner:news"))
+		assert.False(t, auth.Can(ScopeSubscribe, "partner:news"))
 	})
 
 	t.Run("invalid api key", func(t *testing.T) {
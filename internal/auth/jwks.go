@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	jwksFetchTimeout = 10 * time.Second
+	// jwksMinRefreshInterval bounds how often a token with an unknown key id
+	// can trigger a fetch, so that forged key ids cannot flood the provider.
+	jwksMinRefreshInterval = 30 * time.Second
+	jwksMaxResponseBytes   = 1 << 20
+)
+
+// JWKSKeySet verifies tokens with the keys published at a JWKS URL. The keys
+// are cached, refreshed in the background and fetched again when a token
+// refers to an unknown key id.
+type JWKSKeySet struct {
+	logger             *zap.Logger
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	mu      sync.RWMutex
+	entries []keyEntry
+
+	fetchMu   sync.Mutex
+	fetchTime time.Time
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewJWKSKeySet(
+	logger *zap.Logger,
+	url string,
+	client *http.Client,
+	refreshInterval time.Duration,
+) *JWKSKeySet {
+	return &JWKSKeySet{
+		logger:             logger,
+		url:                url,
+		client:             client,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: jwksMinRefreshInterval,
+		done:               make(chan struct{}),
+	}
+}
+
+// Start fetches the keys and refreshes them in the background until the key
+// set is closed.
+func (s *JWKSKeySet) Start() error {
+	err := s.fetch()
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go s.refreshLoop()
+
+	return nil
+}
+
+func (s *JWKSKeySet) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *JWKSKeySet) Keys(keyId string, method jwt.SigningMethod) ([]any, error) {
+	if keyId != "" && !s.hasKeyId(keyId) {
+		fetched, err := s.fetchIfStale()
+		if err != nil {
+			s.logger.Error("failed to fetch jwks for unknown key id",
+				zap.String("keyId", keyId),
+				zap.Error(err))
+		} else if fetched {
+			s.logger.Info("fetched jwks for unknown key id",
+				zap.String("keyId", keyId))
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return selectKeys(s.entries, keyId, method), nil
+}
+
+func (s *JWKSKeySet) hasKeyId(keyId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.entries {
+		if entry.id == keyId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *JWKSKeySet) refreshLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			err := s.fetch()
+			if err != nil {
+				s.logger.Error("failed to refresh jwks",
+					zap.String("url", s.url),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// fetchIfStale fetches the keys unless they were fetched recently and reports
+// whether it did.
+func (s *JWKSKeySet) fetchIfStale() (bool, error) {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	if time.Since(s.fetchTime) < s.minRefreshInterval {
+		return false, nil
+	}
+
+	return true, s.fetchLocked()
+}
+
+func (s *JWKSKeySet) fetch() error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	return s.fetchLocked()
+}
+
+func (s *JWKSKeySet) fetchLocked() error {
+	// A failed fetch also counts, so that an unavailable provider is not
+	// retried on every token.
+	s.fetchTime = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected jwks response status: %d", response.StatusCode)
+	}
+
+	var document jwksDocument
+	err = json.NewDecoder(http.MaxBytesReader(nil, response.Body, jwksMaxResponseBytes)).Decode(&document)
+	if err != nil {
+		return fmt.Errorf("invalid jwks: %w", err)
+	}
+
+	entries := make([]keyEntry, 0, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			s.logger.Warn("skipping unsupported jwk",
+				zap.String("keyId", jwk.Kid),
+				zap.Error(err))
+
+			continue
+		}
+
+		entries = append(entries, keyEntry{
+			id:  jwk.Kid,
+			alg: jwk.Alg,
+			key: key,
+		})
+	}
+
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
+
+	return nil
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testJWKSServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int32
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	s := &testJWKSServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testJWKSServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = keys
+}
+
+func encodeJWKInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func rsaJWK(keyId string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": keyId,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   encodeJWKInt(key.N),
+		"e":   encodeJWKInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(keyId string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": keyId,
+		"crv": "P-256",
+		"x":   encodeJWKInt(key.X),
+		"y":   encodeJWKInt(key.Y),
+	}
+}
+
+func ed25519JWK(keyId string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "OKP",
+		"kid": keyId,
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(key),
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, keyId string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"sub":                "test-user",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"aud":                "broadcaster",
+		"authorizedChannels": []string{"test-channel"},
+		"scope":              []string{"subscribe"},
+	}
+	for name, value := range claims {
+		base[name] = value
+	}
+
+	token := jwt.NewWithClaims(method, base)
+	if keyId != "" {
+		token.Header["kid"] = keyId
+	}
+
+	tokenString, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return tokenString
+}
+
+func TestAuthenticator_AuthenticateJWT_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.setKeys(
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		ecJWK("ec-1", &ecKey.PublicKey),
+		ed25519JWK("ed-1", edPublicKey),
+	)
+
+	keySet := NewJWKSKeySet(zap.NewNop(), server.URL, server.Client(), time.Hour)
+	require.NoError(t, keySet.Start())
+	t.Cleanup(func() { keySet.Close() })
+
+	authenticator := NewAuthenticator(keySet, "https://issuer.example", "broadcaster", nil, NewInMemoryRevocationList())
+	issuer := jwt.MapClaims{"iss": "https://issuer.example"}
+
+	t.Run("supported algorithms", func(t *testing.T) {
+		tokens := map[string]string{
+			"RS256": signTestToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, issuer),
+			"ES256": signTestToken(t, jwt.SigningMethodES256, "ec-1", ecKey, issuer),
+			"EdDSA": signTestToken(t, jwt.SigningMethodEdDSA, "ed-1", edKey, issuer),
+		}
+
+		for alg, token := range tokens {
+			auth, err := authenticator.AuthenticateJWT(token)
+			if assert.NoError(t, err, alg) {
+				assert.Equal(t, "test-user", auth.Subject, alg)
+			}
+		}
+	})
+
+	t.Run("without key id", func(t *testing.T) {
+		auth, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodES256, "", ecKey, issuer))
+
+		assert.NoError(t, err)
+		assert.NotNil(t, auth)
+	})
+
+	t.Run("key id of another key", func(t *testing.T) {
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "ec-1", rsaKey, issuer))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS512, "rsa-1", rsaKey, issuer))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("public key used as hmac secret", func(t *testing.T) {
+		secret, err := json.Marshal(rsaJWK("rsa-1", &rsaKey.PublicKey))
+		require.NoError(t, err)
+
+		_, err = authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodHS256, "rsa-1", secret, issuer))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("issuer and audience", func(t *testing.T) {
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, nil))
+		assert.Error(t, err)
+
+		_, err = authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey,
+			jwt.MapClaims{"iss": "https://other.example"}))
+		assert.Error(t, err)
+
+		_, err = authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey,
+			jwt.MapClaims{"iss": "https://issuer.example", "aud": "other"}))
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKSKeySet_Refresh(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	t.Run("unknown key id", func(t *testing.T) {
+		server := newTestJWKSServer(t)
+		server.setKeys(rsaJWK("old", &oldKey.PublicKey))
+
+		keySet := NewJWKSKeySet(zap.NewNop(), server.URL, server.Client(), time.Hour)
+		keySet.minRefreshInterval = 0
+		require.NoError(t, keySet.Start())
+		t.Cleanup(func() { keySet.Close() })
+
+		authenticator := NewAuthenticator(keySet, "", "broadcaster", nil, NewInMemoryRevocationList())
+
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "old", oldKey, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), server.fetches.Load(), "known key ids are served from the cache")
+
+		server.setKeys(rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey))
+
+		_, err = authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodRS256, "new", newKey, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), server.fetches.Load())
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		server := newTestJWKSServer(t)
+		server.setKeys(rsaJWK("old", &oldKey.PublicKey))
+
+		keySet := NewJWKSKeySet(zap.NewNop(), server.URL, server.Client(), time.Hour)
+		require.NoError(t, keySet.Start())
+		t.Cleanup(func() { keySet.Close() })
+
+		for range 3 {
+			keys, err := keySet.Keys("forged", jwt.SigningMethodRS256)
+			assert.NoError(t, err)
+			assert.Empty(t, keys)
+		}
+
+		assert.Equal(t, int32(1), server.fetches.Load())
+	})
+
+	t.Run("background", func(t *testing.T) {
+		server := newTestJWKSServer(t)
+		server.setKeys(rsaJWK("old", &oldKey.PublicKey))
+
+		keySet := NewJWKSKeySet(zap.NewNop(), server.URL, server.Client(), 10*time.Millisecond)
+		require.NoError(t, keySet.Start())
+		t.Cleanup(func() { keySet.Close() })
+
+		server.setKeys(rsaJWK("new", &newKey.PublicKey))
+
+		assert.Eventually(t, func() bool {
+			return !keySet.hasKeyId("old") && keySet.hasKeyId("new")
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(server.Close)
+
+		keySet := NewJWKSKeySet(zap.NewNop(), server.URL, server.Client(), time.Hour)
+
+		assert.Error(t, keySet.Start())
+	})
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet resolves the keys that may verify a token.
+type KeySet interface {
+	// Keys returns the candidate keys for a token signed with the method. The
+	// key id is the `kid` header of the token and is empty when it has none.
+	Keys(keyId string, method jwt.SigningMethod) ([]any, error)
+}
+
+// keyEntry is a verification key and the id and algorithm it is published
+// with. Empty fields match any token.
+type keyEntry struct {
+	id  string
+	alg string
+	key any
+}
+
+func selectKeys(entries []keyEntry, keyId string, method jwt.SigningMethod) []any {
+	keys := []any{}
+	for _, entry := range entries {
+		if keyId != "" && entry.id != "" && entry.id != keyId {
+			continue
+		}
+
+		if entry.alg != "" && entry.alg != method.Alg() {
+			continue
+		}
+
+		if !keyMatchesMethod(entry.key, method) {
+			continue
+		}
+
+		keys = append(keys, entry.key)
+	}
+
+	return keys
+}
+
+// keyMatchesMethod reports whether the key has the type the method verifies
+// with, so that a public key is never used as an HMAC secret.
+func keyMatchesMethod(key any, method jwt.SigningMethod) bool {
+	switch method := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		key, ok := key.(*ecdsa.PublicKey)
+		return ok && key.Curve.Params().BitSize == method.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
+
+// HMACKey is a shared secret and the key id tokens refer to it with.
+type HMACKey struct {
+	Id     string
+	Secret string
+}
+
+// HMACKeySet verifies tokens signed with shared secrets. Tokens with a key id
+// are verified with the secret of that id, tokens without one with the primary
+// secret. The other secrets are accept-only: they keep the tokens signed
+// before a rotation valid until the issuers have moved to the primary one.
+type HMACKeySet struct {
+	primary keyEntry
+	entries []keyEntry
+}
+
+// NewHMACKeySet verifies tokens with a single secret, whatever their key id.
+func NewHMACKeySet(secret string) *HMACKeySet {
+	entry := keyEntry{key: []byte(secret)}
+
+	return &HMACKeySet{
+		primary: entry,
+		entries: []keyEntry{entry},
+	}
+}
+
+func NewNamedHMACKeySet(keys []HMACKey, primaryKeyId string) (*HMACKeySet, error) {
+	s := &HMACKeySet{}
+
+	ids := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if key.Id == "" || key.Secret == "" {
+			return nil, errors.New("hmac keys must have an id and a secret")
+		}
+
+		if _, ok := ids[key.Id]; ok {
+			return nil, fmt.Errorf("duplicate hmac key id: %s", key.Id)
+		}
+		ids[key.Id] = struct{}{}
+
+		entry := keyEntry{id: key.Id, key: []byte(key.Secret)}
+		if key.Id == primaryKeyId {
+			s.primary = entry
+		}
+
+		s.entries = append(s.entries, entry)
+	}
+
+	if s.primary.key == nil {
+		return nil, fmt.Errorf("unknown primary hmac key id: %s", primaryKeyId)
+	}
+
+	return s, nil
+}
+
+func (s *HMACKeySet) Keys(keyId string, method jwt.SigningMethod) ([]any, error) {
+	if keyId == "" {
+		return selectKeys([]keyEntry{s.primary}, "", method), nil
+	}
+
+	return selectKeys(s.entries, keyId, method), nil
+}
+
+// StaticKeySet holds public keys known at startup.
+type StaticKeySet struct {
+	entries []keyEntry
+}
+
+// LoadPEMKeySet reads one public key per file, either as a PKIX or PKCS #1
+// public key or as a certificate. The key id of each key is the name of its
+// file without the extension.
+func LoadPEMKeySet(paths []string) (*StaticKeySet, error) {
+	entries := make([]keyEntry, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+
+		key, err := parsePEMPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+
+		name := filepath.Base(path)
+		entries = append(entries, keyEntry{
+			id:  strings.TrimSuffix(name, filepath.Ext(name)),
+			key: key,
+		})
+	}
+
+	return &StaticKeySet{entries: entries}, nil
+}
+
+func parsePEMPublicKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		certificate, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = certificate.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported pem block: %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", key)
+	}
+}
+
+func (s *StaticKeySet) Keys(keyId string, method jwt.SigningMethod) ([]any, error) {
+	return selectKeys(s.entries, keyId, method), nil
+}
+
+// KeySets combines the keys of several key sets.
+type KeySets []KeySet
+
+func (s KeySets) Keys(keyId string, method jwt.SigningMethod) ([]any, error) {
+	keys := []any{}
+	for _, keySet := range s {
+		setKeys, err := keySet.Keys(keyId, method)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, setKeys...)
+	}
+
+	return keys, nil
+}
+
+func (s KeySets) Close() error {
+	var errs []error
+	for _, keySet := range s {
+		if closer, ok := keySet.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
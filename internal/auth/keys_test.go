@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPEMKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	writeKey := func(name string) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+
+		data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+
+		return key
+	}
+
+	currentKey := writeKey("current.pem")
+	nextKey := writeKey("next.pem")
+
+	keySet, err := LoadPEMKeySet([]string{
+		filepath.Join(dir, "current.pem"),
+		filepath.Join(dir, "next.pem"),
+	})
+	require.NoError(t, err)
+
+	authenticator := NewAuthenticator(keySet, "", "broadcaster", nil, NewInMemoryRevocationList())
+
+	t.Run("key id is the file name", func(t *testing.T) {
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodES256, "next", nextKey, nil))
+		assert.NoError(t, err)
+
+		_, err = authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodES256, "next", currentKey, nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("without key id", func(t *testing.T) {
+		for _, key := range []*ecdsa.PrivateKey{currentKey, nextKey} {
+			_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodES256, "", key, nil))
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("not a key"), 0o600))
+
+		_, err := LoadPEMKeySet([]string{filepath.Join(dir, "invalid.pem")})
+		assert.Error(t, err)
+
+		_, err = LoadPEMKeySet([]string{filepath.Join(dir, "missing.pem")})
+		assert.Error(t, err)
+	})
+}
+
+func TestNamedHMACKeySet(t *testing.T) {
+	keySet, err := NewNamedHMACKeySet([]HMACKey{
+		{Id: "2024-01", Secret: "old-secret"},
+		{Id: "2024-06", Secret: "new-secret"},
+	}, "2024-06")
+	require.NoError(t, err)
+
+	authenticator := NewAuthenticator(keySet, "", "broadcaster", nil, NewInMemoryRevocationList())
+
+	authenticate := func(keyId string, secret string) error {
+		_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodHS256, keyId, []byte(secret), nil))
+		return err
+	}
+
+	t.Run("selected by key id", func(t *testing.T) {
+		assert.NoError(t, authenticate("2024-06", "new-secret"))
+		assert.NoError(t, authenticate("2024-01", "old-secret"))
+		assert.Error(t, authenticate("2024-01", "new-secret"))
+		assert.Error(t, authenticate("2025-01", "new-secret"))
+	})
+
+	t.Run("primary without key id", func(t *testing.T) {
+		assert.NoError(t, authenticate("", "new-secret"))
+		assert.Error(t, authenticate("", "old-secret"))
+	})
+
+	t.Run("single secret ignores key ids", func(t *testing.T) {
+		authenticator := NewAuthenticator(NewHMACKeySet("secret"), "", "broadcaster", nil, NewInMemoryRevocationList())
+
+		for _, keyId := range []string{"", "any"} {
+			_, err := authenticator.AuthenticateJWT(signTestToken(t, jwt.SigningMethodHS256, keyId, []byte("secret"), nil))
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewNamedHMACKeySet([]HMACKey{{Id: "a", Secret: "secret"}}, "b")
+		assert.Error(t, err)
+
+		_, err = NewNamedHMACKeySet([]HMACKey{{Id: "a", Secret: "secret"}, {Id: "a", Secret: "other"}}, "a")
+		assert.Error(t, err)
+
+		_, err = NewNamedHMACKeySet([]HMACKey{{Id: "a", Secret: ""}}, "a")
+		assert.Error(t, err)
+	})
+}
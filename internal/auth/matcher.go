@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goevery/broadcaster/internal/pattern"
+)
+
+// subjectPlaceholder is replaced with the subject of the token in authorized
+// channels, so that `user:{sub}:*` grants every channel of the user.
+const subjectPlaceholder = "{sub}"
+
+// channelMatcher is the compiled form of the authorized channels of an
+// authentication. Plain channels are looked up directly and patterns are only
+// parsed once.
+type channelMatcher struct {
+	channels map[string]struct{}
+	patterns []pattern.Pattern
+}
+
+// compileChannelMatcher builds the matcher of the authorized channels. Entries
+// that are not valid are left out of the matcher and reported in the error.
+func compileChannelMatcher(subject string, authorizedChannels []string) (*channelMatcher, error) {
+	matcher := &channelMatcher{
+		channels: make(map[string]struct{}),
+	}
+
+	var errs []error
+
+	for _, authorizedChannel := range authorizedChannels {
+		if strings.Contains(authorizedChannel, subjectPlaceholder) {
+			// A subject such as `a:b` or `*` would otherwise widen the grant.
+			if !pattern.IsSegmentValue(subject) {
+				errs = append(errs, fmt.Errorf("%s: subject cannot be used as a channel segment", authorizedChannel))
+				continue
+			}
+
+			authorizedChannel = strings.ReplaceAll(authorizedChannel, subjectPlaceholder, subject)
+		}
+
+		if !pattern.IsPattern(authorizedChannel) {
+			matcher.channels[authorizedChannel] = struct{}{}
+			continue
+		}
+
+		parsed, err := pattern.Parse(authorizedChannel)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", authorizedChannel, err))
+			continue
+		}
+
+		matcher.patterns = append(matcher.patterns, parsed)
+	}
+
+	return matcher, errors.Join(errs...)
+}
+
+// authorizes reports whether the channel, or every channel matched by the
+// pattern, is authorized.
+func (m *channelMatcher) authorizes(channel string) bool {
+	if _, ok := m.channels[channel]; ok {
+		return true
+	}
+
+	requested := pattern.Pattern(strings.Split(channel, pattern.Separator))
+	for _, authorized := range m.patterns {
+		if authorized.Covers(requested) {
+			return true
+		}
+	}
+
+	return false
+}
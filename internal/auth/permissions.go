@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// channelActions are the actions a token can grant per channel in its
+// permissions claim.
+var channelActions = []string{ScopeSubscribe, ScopePublish, ScopePresence, ScopeHistory}
+
+// permissionMatcher is the compiled form of the channels granted to an
+// authentication.
+type permissionMatcher struct {
+	// channels authorizes the channels granted for any action.
+	channels *channelMatcher
+	// actions authorizes the channels granted for each action. It is nil when
+	// the grants come from authorized channels and a global scope.
+	actions map[string]*channelMatcher
+}
+
+// compilePermissionMatcher builds the matcher of the permissions, or of the
+// authorized channels when there are no permissions.
+func compilePermissionMatcher(
+	subject string,
+	authorizedChannels []string,
+	permissions map[string][]string,
+) (*permissionMatcher, error) {
+	if len(permissions) == 0 {
+		channels, err := compileChannelMatcher(subject, authorizedChannels)
+
+		return &permissionMatcher{channels: channels}, err
+	}
+
+	var errs []error
+
+	channelsByAction := make(map[string][]string)
+	for _, channel := range slices.Sorted(maps.Keys(permissions)) {
+		actions := permissions[channel]
+		if len(actions) == 0 {
+			errs = append(errs, fmt.Errorf("%s: actions cannot be empty", channel))
+		}
+
+		for _, action := range actions {
+			if !slices.Contains(channelActions, action) {
+				errs = append(errs, fmt.Errorf("%s: unknown action %s", channel, action))
+				continue
+			}
+
+			channelsByAction[action] = append(channelsByAction[action], channel)
+		}
+	}
+
+	channels, err := compileChannelMatcher(subject, slices.Sorted(maps.Keys(permissions)))
+	errs = append(errs, err)
+
+	matcher := &permissionMatcher{
+		channels: channels,
+		actions:  make(map[string]*channelMatcher, len(channelsByAction)),
+	}
+
+	for action, actionChannels := range channelsByAction {
+		// Invalid channels have been reported when compiling every channel.
+		matcher.actions[action], _ = compileChannelMatcher(subject, actionChannels)
+	}
+
+	return matcher, errors.Join(errs...)
+}
+
+// allows reports whether the action is granted on the channel. Without
+// permissions, subscribing and publishing also require the matching scope,
+// which is checked by the caller.
+func (m *permissionMatcher) allows(action string, channel string) bool {
+	if m.actions == nil {
+		return m.channels.authorizes(channel)
+	}
+
+	actionMatcher, ok := m.actions[action]
+
+	return ok && actionMatcher.authorizes(channel)
+}
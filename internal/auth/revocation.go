@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList records the tokens that must no longer be accepted.
+type RevocationList interface {
+	// RevokeToken rejects the token with the id until it expires.
+	RevokeToken(tokenId string, expireTime time.Time)
+	// RevokeSubject rejects the tokens of the subject issued at or before
+	// revokeTime. Tokens issued afterwards are accepted again, so a banned
+	// user must also stop being issued tokens. The revocation is forgotten at
+	// expireTime, once every token it covers has expired.
+	RevokeSubject(subject string, revokeTime time.Time, expireTime time.Time)
+	IsRevoked(tokenId string, subject string, issueTime time.Time) bool
+}
+
+type subjectRevocation struct {
+	revokeTime time.Time
+	expireTime time.Time
+}
+
+type InMemoryRevocationList struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+func NewInMemoryRevocationList() *InMemoryRevocationList {
+	return &InMemoryRevocationList{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+func (l *InMemoryRevocationList) RevokeToken(tokenId string, expireTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(time.Now())
+
+	if current, ok := l.tokens[tokenId]; ok && current.After(expireTime) {
+		return
+	}
+
+	l.tokens[tokenId] = expireTime
+}
+
+func (l *InMemoryRevocationList) RevokeSubject(subject string, revokeTime time.Time, expireTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(time.Now())
+
+	revocation := l.subjects[subject]
+	if revokeTime.After(revocation.revokeTime) {
+		revocation.revokeTime = revokeTime
+	}
+
+	if expireTime.After(revocation.expireTime) {
+		revocation.expireTime = expireTime
+	}
+
+	l.subjects[subject] = revocation
+}
+
+func (l *InMemoryRevocationList) IsRevoked(tokenId string, subject string, issueTime time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+
+	if tokenId != "" {
+		if expireTime, ok := l.tokens[tokenId]; ok && now.Before(expireTime) {
+			return true
+		}
+	}
+
+	revocation, ok := l.subjects[subject]
+	if ok && now.Before(revocation.expireTime) && !issueTime.After(revocation.revokeTime) {
+		return true
+	}
+
+	return false
+}
+
+// pruneLocked forgets the revocations that no longer reject any token. Revoking
+// is rare, so the whole list is scanned.
+//
+// IMPORTANT: It must be called only when a write lock is already held.
+func (l *InMemoryRevocationList) pruneLocked(now time.Time) {
+	for tokenId, expireTime := range l.tokens {
+		if !now.Before(expireTime) {
+			delete(l.tokens, tokenId)
+		}
+	}
+
+	for subject, revocation := range l.subjects {
+		if !now.Before(revocation.expireTime) {
+			delete(l.subjects, subject)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryRevocationList(t *testing.T) {
+	now := time.Now()
+
+	t.Run("token", func(t *testing.T) {
+		list := NewInMemoryRevocationList()
+		list.RevokeToken("token-1", now.Add(time.Hour))
+
+		assert.True(t, list.IsRevoked("token-1", "user", now))
+		assert.False(t, list.IsRevoked("token-2", "user", now))
+		assert.False(t, list.IsRevoked("", "user", now))
+	})
+
+	t.Run("subject", func(t *testing.T) {
+		list := NewInMemoryRevocationList()
+		list.RevokeSubject("user", now, now.Add(time.Hour))
+
+		assert.True(t, list.IsRevoked("", "user", now.Add(-time.Minute)))
+		assert.True(t, list.IsRevoked("token-1", "user", now))
+		assert.False(t, list.IsRevoked("", "user", now.Add(time.Second)))
+		assert.False(t, list.IsRevoked("", "other", now.Add(-time.Minute)))
+	})
+
+	t.Run("expired revocations are pruned", func(t *testing.T) {
+		list := NewInMemoryRevocationList()
+		list.RevokeToken("token-1", now.Add(-time.Second))
+		list.RevokeSubject("user", now, now.Add(-time.Second))
+
+		assert.False(t, list.IsRevoked("token-1", "user", now.Add(-time.Minute)))
+
+		list.RevokeToken("token-2", now.Add(time.Hour))
+
+		assert.Len(t, list.tokens, 1)
+		assert.Empty(t, list.subjects)
+	})
+}
+
+func TestAuthenticator_AuthenticateJWT_Revoked(t *testing.T) {
+	revocations := NewInMemoryRevocationList()
+	authenticator := NewAuthenticator(NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, revocations)
+
+	issueTime := time.Now().Add(-time.Minute)
+	token := func(tokenId string) string {
+		claims := jwt.MapClaims{"jti": tokenId, "iat": issueTime.Unix()}
+		if tokenId == "" {
+			delete(claims, "jti")
+		}
+
+		return signTestToken(t, jwt.SigningMethodHS256, "", []byte("test-secret"), claims)
+	}
+
+	revocations.RevokeToken("leaked", time.Now().Add(time.Hour))
+
+	_, err := authenticator.AuthenticateJWT(token("leaked"))
+	assert.Error(t, err)
+
+	auth, err := authenticator.AuthenticateJWT(token("other"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "other", auth.TokenId)
+	}
+
+	revocations.RevokeSubject("test-user", time.Now(), time.Now().Add(time.Hour))
+
+	_, err = authenticator.AuthenticateJWT(token("other"))
+	assert.Error(t, err)
+}
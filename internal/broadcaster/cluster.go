@@ -0,0 +1,257 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/pattern"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.uber.org/zap"
+)
+
+// Bus carries published messages between the nodes of a cluster.
+type Bus interface {
+	// Start begins delivering the messages published by other nodes to the
+	// handler. Messages published by this node must not be delivered back.
+	Start(handler func(message Message)) error
+	Publish(message Message) error
+	// Subscribe and Unsubscribe tell the bus whether this node has local
+	// subscribers for a channel. Buses may use it to only receive the messages
+	// of those channels.
+	Subscribe(channelId string) error
+	Unsubscribe(channelId string) error
+	Close() error
+}
+
+// revocationChannel carries the revocations between the nodes of a cluster.
+// It is not a valid channel id, so clients cannot publish or subscribe to it.
+const revocationChannel = "$revocations"
+
+// Revocation revokes, on every node of a cluster, the tokens of a subject
+// issued until RevokeTime and the token with TokenId.
+type Revocation struct {
+	Subject    string    `json:"subject,omitempty"`
+	TokenId    string    `json:"tokenId,omitempty"`
+	RevokeTime time.Time `json:"revokeTime"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// ErrPresenceUnavailable is returned when subscribing to a presence channel in a
+// cluster, whose nodes only know their own members.
+var ErrPresenceUnavailable = errors.New("presence is not available in a cluster")
+
+// ClusterRegistry fans messages out to the local connections through an
+// InMemoryRegistry and forwards every broadcast to the other nodes through a
+// Bus. Messages keep the offset given by the node they were published on, so
+// that the nodes agree on the offsets of the messages they have both stored.
+// Sessions stay local to each node. Presence channels are rejected, since the
+// members of the other nodes would be missing.
+type ClusterRegistry struct {
+	*InMemoryRegistry
+
+	logger *zap.Logger
+	bus    Bus
+
+	mu                 sync.Mutex
+	busChannels        map[string]struct{}
+	revocationListener func(revocation Revocation)
+
+	receivedMu  sync.Mutex
+	receivedIds map[string]struct{}
+	receivedLog []string
+}
+
+// receivedIdCount bounds the number of message ids remembered to drop the
+// copies of a message that the bus delivers once per matching subscription,
+// such as a channel and a pattern that both match it.
+const receivedIdCount = 4096
+
+func NewClusterRegistry(
+	logger *zap.Logger,
+	local *InMemoryRegistry,
+	bus Bus,
+) (*ClusterRegistry, error) {
+	r := &ClusterRegistry{
+		InMemoryRegistry: local,
+		logger:           logger,
+		bus:              bus,
+		busChannels:      make(map[string]struct{}),
+		receivedIds:      make(map[string]struct{}),
+	}
+
+	local.OnChannelChange(r.syncChannel)
+
+	err := bus.Start(r.receive)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bus.Subscribe(revocationChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// OnRevocation registers the listener applying the revocations received from
+// the other nodes.
+func (r *ClusterRegistry) OnRevocation(listener func(revocation Revocation)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revocationListener = listener
+}
+
+// PublishRevocation forwards a revocation applied on this node to the other
+// nodes.
+func (r *ClusterRegistry) PublishRevocation(revocation Revocation) error {
+	return r.bus.Publish(Message{
+		Id:         gonanoid.Must(),
+		Channel:    revocationChannel,
+		Payload:    revocation,
+		CreateTime: time.Now(),
+	})
+}
+
+func (r *ClusterRegistry) Broadcast(message Message) (Message, error) {
+	message, err := r.InMemoryRegistry.Broadcast(message)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// The message has already been delivered locally, so a bus failure only
+	// affects the other nodes and is not reported to the publisher.
+	err = r.bus.Publish(message)
+	if err != nil {
+		r.logger.Error("failed to publish message to cluster bus",
+			zap.String("channelId", message.Channel),
+			zap.Error(err))
+	}
+
+	return message, nil
+}
+
+func (r *ClusterRegistry) Subscribe(channelId string, connectionId string, options SubscribeOptions) error {
+	if IsPresenceChannel(channelId) && !pattern.IsPattern(channelId) {
+		return ErrPresenceUnavailable
+	}
+
+	return r.InMemoryRegistry.Subscribe(channelId, connectionId, options)
+}
+
+func (r *ClusterRegistry) Resume(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions) error {
+	for channelId := range subscriptions {
+		if IsPresenceChannel(channelId) && !pattern.IsPattern(channelId) {
+			return ErrPresenceUnavailable
+		}
+	}
+
+	return r.InMemoryRegistry.Resume(sessionId, connectionId, subscriptions)
+}
+
+// TracksPresence reports that the members of presence channels are not known.
+func (r *ClusterRegistry) TracksPresence() bool {
+	return false
+}
+
+func (r *ClusterRegistry) Close() error {
+	return r.bus.Close()
+}
+
+func (r *ClusterRegistry) receive(message Message) {
+	if !r.firstReceived(message.Id) {
+		return
+	}
+
+	if message.Channel == revocationChannel {
+		r.receiveRevocation(message)
+
+		return
+	}
+
+	_, err := r.InMemoryRegistry.replicate(message)
+	if err != nil {
+		r.logger.Error("failed to broadcast message from cluster bus",
+			zap.String("channelId", message.Channel),
+			zap.Error(err))
+	}
+}
+
+func (r *ClusterRegistry) receiveRevocation(message Message) {
+	// The payload is decoded by the bus as any JSON value.
+	var revocation Revocation
+	data, err := json.Marshal(message.Payload)
+	if err == nil {
+		err = json.Unmarshal(data, &revocation)
+	}
+
+	if err != nil {
+		r.logger.Error("failed to decode revocation from cluster bus", zap.Error(err))
+
+		return
+	}
+
+	r.mu.Lock()
+	listener := r.revocationListener
+	r.mu.Unlock()
+
+	if listener != nil {
+		listener(revocation)
+	}
+}
+
+func (r *ClusterRegistry) firstReceived(messageId string) bool {
+	r.receivedMu.Lock()
+	defer r.receivedMu.Unlock()
+
+	if _, ok := r.receivedIds[messageId]; ok {
+		return false
+	}
+
+	if len(r.receivedLog) == receivedIdCount {
+		delete(r.receivedIds, r.receivedLog[0])
+		r.receivedLog = r.receivedLog[1:]
+	}
+
+	r.receivedIds[messageId] = struct{}{}
+	r.receivedLog = append(r.receivedLog, messageId)
+
+	return true
+}
+
+// syncChannel aligns the bus subscription of a channel with the presence of
+// local subscribers. Changes are reported out of order under concurrency, so
+// the current state is checked instead of trusting the notification.
+func (r *ClusterRegistry) syncChannel(channelId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, subscribed := r.busChannels[channelId]
+	active := r.HasSubscribers(channelId)
+
+	switch {
+	case active && !subscribed:
+		err := r.bus.Subscribe(channelId)
+		if err != nil {
+			r.logger.Error("failed to subscribe to cluster bus channel",
+				zap.String("channelId", channelId),
+				zap.Error(err))
+
+			return
+		}
+
+		r.busChannels[channelId] = struct{}{}
+	case !active && subscribed:
+		err := r.bus.Unsubscribe(channelId)
+		if err != nil {
+			r.logger.Error("failed to unsubscribe from cluster bus channel",
+				zap.String("channelId", channelId),
+				zap.Error(err))
+		}
+
+		delete(r.busChannels, channelId)
+	}
+}
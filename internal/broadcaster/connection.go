@@ -7,13 +7,28 @@ import (
 	"github.com/goevery/broadcaster/internal/auth"
 )
 
+// CloseReason tells a client why the server closed its connection. Codes are
+// in the range reserved for applications by the WebSocket protocol.
+type CloseReason struct {
+	Code int
+	Text string
+}
+
+var (
+	CloseReasonTokenExpired          = CloseReason{Code: 4001, Text: "token expired"}
+	CloseReasonAuthenticationTimeout = CloseReason{Code: 4002, Text: "authentication timeout"}
+	CloseReasonRevoked               = CloseReason{Code: 4003, Text: "authentication revoked"}
+)
+
 type Connection struct {
 	Id   string
 	Send chan Message
 
-	mu             sync.RWMutex
-	Seq            uint64
-	authentication *auth.Authentication
+	mu                    sync.RWMutex
+	Seq                   uint64
+	authentication        *auth.Authentication
+	authenticationChanged chan struct{}
+	closeReason           *CloseReason
 }
 
 func (c *Connection) NextSeq() uint64 {
@@ -29,6 +44,47 @@ func (c *Connection) SetAuthentication(auth *auth.Authentication) {
 	defer c.mu.Unlock()
 
 	c.authentication = auth
+
+	if c.authenticationChanged != nil {
+		select {
+		case c.authenticationChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// AuthenticationChanged is signaled when the authentication of the connection
+// is set or replaced. Changes are coalesced, so the current authentication
+// must be read after receiving.
+func (c *Connection) AuthenticationChanged() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.authenticationChanged == nil {
+		c.authenticationChanged = make(chan struct{}, 1)
+	}
+
+	return c.authenticationChanged
+}
+
+// SetCloseReason records why the connection is about to be closed. It must be
+// called before the connection is disconnected from the registry.
+func (c *Connection) SetCloseReason(reason CloseReason) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeReason = &reason
+}
+
+func (c *Connection) CloseReason() (CloseReason, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closeReason == nil {
+		return CloseReason{}, false
+	}
+
+	return *c.closeReason, true
 }
 
 func (c *Connection) GetAuthentication() *auth.Authentication {
@@ -60,6 +116,18 @@ func (c *Connection) IsAuthorized(channelId string) bool {
 	return c.authentication.IsAuthorized(channelId)
 }
 
+// Can reports whether the action is granted on the channel.
+func (c *Connection) Can(action string, channelId string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.authentication == nil {
+		return false
+	}
+
+	return c.authentication.Can(action, channelId)
+}
+
 type contextKey string
 
 const connectionKey contextKey = "connection"
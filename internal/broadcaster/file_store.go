@@ -0,0 +1,865 @@
+package broadcaster
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	segmentLogExtension   = ".log"
+	segmentIndexExtension = ".index"
+
+	// A record is a 4 bytes length and a 4 bytes CRC-32 of the data, followed
+	// by the JSON encoded message.
+	recordHeaderSize = 8
+	// An index entry is the 8 bytes position of a record in the segment log,
+	// followed by the 8 bytes creation time of its message in Unix nanoseconds.
+	indexEntrySize = 16
+)
+
+// FileMessageStore persists messages in an append-only log per channel, split
+// into segments. Every segment is a log file holding the records and an index
+// file holding the position and creation time of each record; segments are
+// named after the offset of their first message.
+//
+// Retention is applied to whole segments: a segment is deleted once its newest
+// message is older than the retention age, or when the channel grows over the
+// retention size. The last segment of a channel is kept, so offsets keep
+// increasing across restarts, until the channel holds no message and was not
+// written to during a compaction interval: the channel is then forgotten.
+//
+// Writes are synced to disk before they are acknowledged, or every sync
+// interval when it is positive.
+type FileMessageStore struct {
+	logger         *zap.Logger
+	dir            string
+	segmentBytes   int64
+	retentionBytes int64
+	retentionAge   time.Duration
+	syncInterval   time.Duration
+
+	mu       sync.Mutex
+	channels map[string]*channelLog
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type channelLog struct {
+	mu          sync.Mutex
+	dir         string
+	segments    []*logSegment
+	logFile     *os.File
+	indexFile   *os.File
+	lastWriteAt time.Time
+	// unsynced tells whether the open files hold writes not synced yet.
+	unsynced bool
+	// removed tells that the channel was forgotten and its files deleted.
+	removed bool
+}
+
+type logSegment struct {
+	baseOffset uint64
+	size       int64
+	entries    []indexEntry
+}
+
+type indexEntry struct {
+	position   int64
+	createTime int64
+}
+
+func OpenFileMessageStore(
+	logger *zap.Logger,
+	dir string,
+	segmentBytes int64,
+	retentionBytes int64,
+	retentionAge time.Duration,
+	compactionInterval time.Duration,
+	syncInterval time.Duration,
+) (*FileMessageStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileMessageStore{
+		logger:         logger,
+		dir:            dir,
+		segmentBytes:   segmentBytes,
+		retentionBytes: retentionBytes,
+		retentionAge:   retentionAge,
+		syncInterval:   syncInterval,
+		channels:       make(map[string]*channelLog),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		channelId, err := url.QueryUnescape(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel directory %q: %w", entry.Name(), err)
+		}
+
+		log, err := loadChannelLog(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load channel %q: %w", channelId, err)
+		}
+
+		s.channels[channelId] = log
+	}
+
+	go s.run(compactionInterval)
+
+	return s, nil
+}
+
+func (s *FileMessageStore) Append(message Message) (Message, error) {
+	return s.store(message, false)
+}
+
+func (s *FileMessageStore) Replicate(message Message) (Message, error) {
+	return s.store(message, true)
+}
+
+// store writes the message with the next offset of its channel, or with its
+// own offset when it is replicated.
+func (s *FileMessageStore) store(message Message, replicated bool) (Message, error) {
+	log, err := s.lockChannelLog(message.Channel)
+	if err != nil {
+		return Message{}, err
+	}
+
+	defer log.mu.Unlock()
+
+	active := log.activeSegment()
+	if replicated && message.Offset != active.nextOffset() {
+		nextOffset := active.nextOffset()
+
+		err := log.restart(max(message.Offset, nextOffset))
+		if err != nil {
+			return Message{}, err
+		}
+
+		if message.Offset < nextOffset {
+			return message, nil
+		}
+
+		active = log.activeSegment()
+	}
+
+	if active.size >= s.segmentBytes && len(active.entries) > 0 {
+		err := log.roll()
+		if err != nil {
+			return Message{}, err
+		}
+
+		log.enforceSize(s.retentionBytes)
+		active = log.activeSegment()
+	}
+
+	if !replicated {
+		message.Offset = active.nextOffset()
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = log.write(data, message.CreateTime)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if s.syncInterval <= 0 {
+		err = log.sync()
+		if err != nil {
+			return Message{}, err
+		}
+	}
+
+	return message, nil
+}
+
+func (s *FileMessageStore) Query(channelId string, query HistoryQuery) ([]Message, error) {
+	log, err := s.channelLog(channelId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if log != nil {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+	}
+
+	if log == nil || log.removed {
+		if query.AfterOffset != nil && *query.AfterOffset > 0 {
+			return nil, ErrHistoryUnavailable
+		}
+
+		return nil, nil
+	}
+
+	firstOffset := log.segments[0].baseOffset
+	lastOffset := log.activeSegment().nextOffset() - 1
+
+	if query.AfterOffset != nil {
+		afterOffset := *query.AfterOffset
+
+		if afterOffset > lastOffset || afterOffset+1 < firstOffset {
+			return nil, ErrHistoryUnavailable
+		}
+	}
+
+	type location struct {
+		segment *logSegment
+		index   int
+	}
+
+	// The index is walked from the newest entry, so that only the most recent
+	// messages are read when the query is limited.
+	var locations []location
+walk:
+	for j := len(log.segments) - 1; j >= 0; j-- {
+		segment := log.segments[j]
+
+		for i := len(segment.entries) - 1; i >= 0; i-- {
+			if query.Limit > 0 && len(locations) == query.Limit {
+				break walk
+			}
+
+			offset := segment.baseOffset + uint64(i)
+			if query.AfterOffset != nil && offset <= *query.AfterOffset {
+				break walk
+			}
+
+			if !query.Since.IsZero() && segment.entries[i].createTime <= query.Since.UnixNano() {
+				continue
+			}
+
+			locations = append(locations, location{segment, i})
+		}
+	}
+
+	slices.Reverse(locations)
+
+	messages := make([]Message, 0, len(locations))
+
+	var file *os.File
+	var fileSegment *logSegment
+
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for _, location := range locations {
+		if fileSegment != location.segment {
+			if file != nil {
+				file.Close()
+			}
+
+			file, err = os.Open(log.segmentPath(location.segment, segmentLogExtension))
+			if err != nil {
+				return nil, err
+			}
+
+			fileSegment = location.segment
+		}
+
+		message, err := readRecord(file, location.segment, location.index)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// Close stops the background compaction and flushes the open segments.
+func (s *FileMessageStore) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, log := range s.channels {
+		log.mu.Lock()
+		errs = append(errs, log.closeFiles())
+		log.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *FileMessageStore) channelLog(channelId string, create bool) (*channelLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, ok := s.channels[channelId]
+	if ok || !create {
+		return log, nil
+	}
+
+	dir := filepath.Join(s.dir, url.QueryEscape(channelId))
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	log = &channelLog{
+		dir:      dir,
+		segments: []*logSegment{{baseOffset: 1}},
+	}
+
+	s.channels[channelId] = log
+
+	return log, nil
+}
+
+// lockChannelLog returns the locked log of the channel, creating it if needed.
+// A log forgotten before it could be locked is created again.
+func (s *FileMessageStore) lockChannelLog(channelId string) (*channelLog, error) {
+	for {
+		log, err := s.channelLog(channelId, true)
+		if err != nil {
+			return nil, err
+		}
+
+		log.mu.Lock()
+		if !log.removed {
+			return log, nil
+		}
+
+		log.mu.Unlock()
+	}
+}
+
+// run compacts the channels every compaction interval and syncs their writes
+// every sync interval, until the store is closed.
+func (s *FileMessageStore) run(compactionInterval time.Duration) {
+	defer close(s.done)
+
+	var compactionC <-chan time.Time
+	if compactionInterval > 0 {
+		ticker := time.NewTicker(compactionInterval)
+		defer ticker.Stop()
+
+		compactionC = ticker.C
+	}
+
+	var syncC <-chan time.Time
+	if s.syncInterval > 0 {
+		ticker := time.NewTicker(s.syncInterval)
+		defer ticker.Stop()
+
+		syncC = ticker.C
+	}
+
+	for {
+		select {
+		case <-compactionC:
+			s.compact(time.Now(), compactionInterval)
+		case <-syncC:
+			s.sync()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sync flushes to disk the writes of every channel.
+func (s *FileMessageStore) sync() {
+	for _, log := range s.logs() {
+		log.mu.Lock()
+
+		err := log.sync()
+		if err != nil {
+			s.logger.Error("failed to sync channel log",
+				zap.String("dir", log.dir),
+				zap.Error(err))
+		}
+
+		log.mu.Unlock()
+	}
+}
+
+func (s *FileMessageStore) logs() []*channelLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs := make([]*channelLog, 0, len(s.channels))
+	for _, log := range s.channels {
+		logs = append(logs, log)
+	}
+
+	return logs
+}
+
+// compact deletes the expired segments of every channel and closes the files
+// of the channels that were not written to during the last idle period. The
+// channels left without messages are then forgotten.
+func (s *FileMessageStore) compact(now time.Time, idle time.Duration) {
+	for _, log := range s.logs() {
+		log.mu.Lock()
+
+		if s.retentionAge > 0 {
+			err := log.enforceAge(now.Add(-s.retentionAge))
+			if err != nil {
+				s.logger.Error("failed to compact channel log",
+					zap.String("dir", log.dir),
+					zap.Error(err))
+			}
+		}
+
+		log.enforceSize(s.retentionBytes)
+
+		if log.logFile != nil && now.Sub(log.lastWriteAt) > idle {
+			err := log.closeFiles()
+			if err != nil {
+				s.logger.Error("failed to close channel log",
+					zap.String("dir", log.dir),
+					zap.Error(err))
+			}
+		}
+
+		log.mu.Unlock()
+	}
+
+	s.evictEmpty(now, idle)
+}
+
+// evictEmpty forgets the channels that hold no message and were not written
+// to during the last idle period, and deletes their files. Their offsets then
+// start again at 1.
+func (s *FileMessageStore) evictEmpty(now time.Time, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for channelId, log := range s.channels {
+		log.mu.Lock()
+
+		if len(log.segments) == 1 && len(log.activeSegment().entries) == 0 && now.Sub(log.lastWriteAt) > idle {
+			err := log.remove()
+			if err != nil {
+				s.logger.Error("failed to remove channel log",
+					zap.String("dir", log.dir),
+					zap.Error(err))
+			}
+
+			if log.removed {
+				delete(s.channels, channelId)
+			}
+		}
+
+		log.mu.Unlock()
+	}
+}
+
+func loadChannelLog(dir string) (*channelLog, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &channelLog{
+		dir: dir,
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, segmentLogExtension) {
+			continue
+		}
+
+		baseOffset, err := strconv.ParseUint(strings.TrimSuffix(name, segmentLogExtension), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment name %q: %w", name, err)
+		}
+
+		log.segments = append(log.segments, &logSegment{baseOffset: baseOffset})
+	}
+
+	slices.SortFunc(log.segments, func(a, b *logSegment) int {
+		return cmp.Compare(a.baseOffset, b.baseOffset)
+	})
+
+	if len(log.segments) == 0 {
+		log.segments = []*logSegment{{baseOffset: 1}}
+
+		return log, nil
+	}
+
+	for i, segment := range log.segments {
+		// The last segment may have been cut short by a crash, so it is always
+		// checked against its log. Sealed segments trust their index.
+		if i < len(log.segments)-1 {
+			err = log.loadIndex(segment)
+			if err == nil {
+				continue
+			}
+		}
+
+		err = log.recoverSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return log, nil
+}
+
+func (l *channelLog) activeSegment() *logSegment {
+	return l.segments[len(l.segments)-1]
+}
+
+func (l *channelLog) segmentPath(segment *logSegment, extension string) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", segment.baseOffset, extension))
+}
+
+func (l *channelLog) write(data []byte, createTime time.Time) error {
+	err := l.openFiles()
+	if err != nil {
+		return err
+	}
+
+	active := l.activeSegment()
+
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	entry := indexEntry{
+		position:   active.size,
+		createTime: createTime.UnixNano(),
+	}
+
+	_, err = l.logFile.Write(record)
+	if err == nil {
+		_, err = l.indexFile.Write(entry.encode())
+	}
+
+	if err != nil {
+		// Drop a partially written record so the files stay in sync.
+		_ = l.logFile.Truncate(active.size)
+		_ = l.indexFile.Truncate(int64(len(active.entries)) * indexEntrySize)
+
+		return err
+	}
+
+	active.entries = append(active.entries, entry)
+	active.size += int64(len(record))
+	l.lastWriteAt = time.Now()
+	l.unsynced = true
+
+	return nil
+}
+
+// sync flushes the writes of the open files to disk.
+func (l *channelLog) sync() error {
+	if !l.unsynced || l.logFile == nil {
+		return nil
+	}
+
+	err := errors.Join(l.logFile.Sync(), l.indexFile.Sync())
+	if err != nil {
+		return err
+	}
+
+	l.unsynced = false
+
+	return nil
+}
+
+func (l *channelLog) openFiles() error {
+	if l.logFile != nil {
+		return nil
+	}
+
+	active := l.activeSegment()
+
+	logFile, err := os.OpenFile(l.segmentPath(active, segmentLogExtension), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	indexFile, err := os.OpenFile(l.segmentPath(active, segmentIndexExtension), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		logFile.Close()
+
+		return err
+	}
+
+	l.logFile = logFile
+	l.indexFile = indexFile
+
+	return nil
+}
+
+func (l *channelLog) closeFiles() error {
+	if l.logFile == nil {
+		return nil
+	}
+
+	err := errors.Join(
+		l.logFile.Sync(),
+		l.indexFile.Sync(),
+		l.logFile.Close(),
+		l.indexFile.Close(),
+	)
+
+	l.logFile = nil
+	l.indexFile = nil
+	l.unsynced = false
+
+	return err
+}
+
+// remove closes the files of the log and deletes its directory.
+func (l *channelLog) remove() error {
+	err := l.closeFiles()
+	if err != nil {
+		return err
+	}
+
+	l.removed = true
+
+	return os.RemoveAll(l.dir)
+}
+
+// roll seals the active segment and starts a new empty one.
+func (l *channelLog) roll() error {
+	err := l.closeFiles()
+	if err != nil {
+		return err
+	}
+
+	l.segments = append(l.segments, &logSegment{
+		baseOffset: l.activeSegment().nextOffset(),
+	})
+
+	return l.openFiles()
+}
+
+// restart replaces every segment with an empty one starting at the offset.
+func (l *channelLog) restart(baseOffset uint64) error {
+	err := l.closeFiles()
+	if err != nil {
+		return err
+	}
+
+	l.segments = append(l.segments, &logSegment{
+		baseOffset: baseOffset,
+	})
+
+	for len(l.segments) > 1 {
+		l.deleteOldestSegment()
+	}
+
+	return l.openFiles()
+}
+
+func (l *channelLog) enforceAge(before time.Time) error {
+	active := l.activeSegment()
+	if len(active.entries) > 0 && active.entries[len(active.entries)-1].createTime < before.UnixNano() {
+		// Everything is expired, including the active segment. A new empty
+		// segment keeps track of the next offset once the others are gone.
+		err := l.roll()
+		if err != nil {
+			return err
+		}
+	}
+
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		if len(oldest.entries) > 0 && oldest.entries[len(oldest.entries)-1].createTime >= before.UnixNano() {
+			break
+		}
+
+		l.deleteOldestSegment()
+	}
+
+	return nil
+}
+
+func (l *channelLog) enforceSize(maxBytes int64) {
+	if maxBytes <= 0 {
+		return
+	}
+
+	var size int64
+	for _, segment := range l.segments {
+		size += segment.size
+	}
+
+	for len(l.segments) > 1 && size > maxBytes {
+		size -= l.segments[0].size
+		l.deleteOldestSegment()
+	}
+}
+
+func (l *channelLog) deleteOldestSegment() {
+	oldest := l.segments[0]
+	l.segments = l.segments[1:]
+
+	_ = os.Remove(l.segmentPath(oldest, segmentLogExtension))
+	_ = os.Remove(l.segmentPath(oldest, segmentIndexExtension))
+}
+
+func (l *channelLog) loadIndex(segment *logSegment) error {
+	data, err := os.ReadFile(l.segmentPath(segment, segmentIndexExtension))
+	if err != nil {
+		return err
+	}
+
+	if len(data)%indexEntrySize != 0 {
+		return errors.New("corrupted index")
+	}
+
+	info, err := os.Stat(l.segmentPath(segment, segmentLogExtension))
+	if err != nil {
+		return err
+	}
+
+	segment.entries = make([]indexEntry, 0, len(data)/indexEntrySize)
+	for i := 0; i < len(data); i += indexEntrySize {
+		segment.entries = append(segment.entries, decodeIndexEntry(data[i:i+indexEntrySize]))
+	}
+
+	segment.size = info.Size()
+
+	return nil
+}
+
+// recoverSegment rebuilds the index of a segment from its log, truncating any
+// trailing record that was not completely written.
+func (l *channelLog) recoverSegment(segment *logSegment) error {
+	logPath := l.segmentPath(segment, segmentLogExtension)
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return err
+	}
+
+	segment.entries = nil
+	segment.size = 0
+
+	var index []byte
+	for int(segment.size)+recordHeaderSize <= len(data) {
+		header := data[segment.size : segment.size+recordHeaderSize]
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+
+		end := segment.size + recordHeaderSize + length
+		if end > int64(len(data)) {
+			break
+		}
+
+		record := data[segment.size+recordHeaderSize : end]
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		var message Message
+		err := json.Unmarshal(record, &message)
+		if err != nil {
+			break
+		}
+
+		entry := indexEntry{
+			position:   segment.size,
+			createTime: message.CreateTime.UnixNano(),
+		}
+
+		segment.entries = append(segment.entries, entry)
+		index = append(index, entry.encode()...)
+		segment.size = end
+	}
+
+	if segment.size < int64(len(data)) {
+		err := os.Truncate(logPath, segment.size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(l.segmentPath(segment, segmentIndexExtension), index, 0o644)
+}
+
+func (s *logSegment) nextOffset() uint64 {
+	return s.baseOffset + uint64(len(s.entries))
+}
+
+func readRecord(file io.ReaderAt, segment *logSegment, index int) (Message, error) {
+	position := segment.entries[index].position
+
+	header := make([]byte, recordHeaderSize)
+	_, err := file.ReadAt(header, position)
+	if err != nil {
+		return Message{}, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	_, err = file.ReadAt(data, position+recordHeaderSize)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return Message{}, errors.New("corrupted record")
+	}
+
+	var message Message
+	err = json.Unmarshal(data, &message)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return message, nil
+}
+
+func (e indexEntry) encode() []byte {
+	data := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(data[0:8], uint64(e.position))
+	binary.BigEndian.PutUint64(data[8:16], uint64(e.createTime))
+
+	return data
+}
+
+func decodeIndexEntry(data []byte) indexEntry {
+	return indexEntry{
+		position:   int64(binary.BigEndian.Uint64(data[0:8])),
+		createTime: int64(binary.BigEndian.Uint64(data[8:16])),
+	}
+}
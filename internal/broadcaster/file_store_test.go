@@ -0,0 +1,234 @@
+package broadcaster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFileMessageStore(t *testing.T) {
+	logger := zap.NewNop()
+
+	t.Run("survives reopening", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			_, err := store.Append(Message{Id: id, Channel: "room:42", CreateTime: time.Now(), Payload: map[string]any{"foo": "bar"}})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, store.Close())
+
+		store, err = OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		messages, err := store.Query("room:42", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+		assert.Equal(t, "msg-1", messages[0].Id)
+		assert.Equal(t, uint64(1), messages[0].Offset)
+		assert.Equal(t, map[string]any{"foo": "bar"}, messages[0].Payload)
+
+		message, err := store.Append(Message{Id: "msg-4", Channel: "room:42", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), message.Offset)
+	})
+
+	t.Run("rolls segments and applies limit and offsets", func(t *testing.T) {
+		store, err := OpenFileMessageStore(logger, t.TempDir(), 1, 0, 0, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3", "msg-4"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		messages, err := store.Query("test-channel", HistoryQuery{Limit: 2})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "msg-3", messages[0].Id)
+		assert.Equal(t, "msg-4", messages[1].Id)
+
+		offset := uint64(1)
+		messages, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+		assert.Equal(t, "msg-2", messages[0].Id)
+
+		messages, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset, Limit: 2})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "msg-3", messages[0].Id)
+		assert.Equal(t, "msg-4", messages[1].Id)
+
+		offset = 5
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+	})
+
+	t.Run("replicates offsets", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+
+		for _, offset := range []uint64{1, 2} {
+			_, err := store.Replicate(Message{Channel: "test-channel", Offset: offset, CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		// The offset was taken concurrently, so the history is dropped.
+		_, err = store.Replicate(Message{Channel: "test-channel", Offset: 1, CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		offset := uint64(0)
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		// Messages were missed, so the history starts after the gap.
+		_, err = store.Replicate(Message{Id: "msg-6", Channel: "test-channel", Offset: 6, CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Close())
+
+		store, err = OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		offset = 4
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		offset = 5
+		messages, err := store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 1) {
+			assert.Equal(t, "msg-6", messages[0].Id)
+			assert.Equal(t, uint64(6), messages[0].Offset)
+		}
+
+		message, err := store.Append(Message{Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(7), message.Offset)
+	})
+
+	t.Run("deletes segments over retention size", func(t *testing.T) {
+		store, err := OpenFileMessageStore(logger, t.TempDir(), 1, 1, 0, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, "msg-3", messages[0].Id)
+
+		offset := uint64(0)
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+	})
+
+	t.Run("deletes expired segments", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := OpenFileMessageStore(logger, dir, 1, 0, time.Minute, 0, 0)
+		require.NoError(t, err)
+
+		_, err = store.Append(Message{Id: "msg-1", Channel: "test-channel", CreateTime: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		_, err = store.Append(Message{Id: "msg-2", Channel: "test-channel", CreateTime: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+
+		store.compact(time.Now(), time.Minute)
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+
+		require.NoError(t, store.Close())
+
+		store, err = OpenFileMessageStore(logger, dir, 1, 0, time.Minute, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		message, err := store.Append(Message{Id: "msg-3", Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), message.Offset)
+	})
+
+	t.Run("forgets idle channels without messages", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := OpenFileMessageStore(logger, dir, 1, 0, time.Minute, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		_, err = store.Append(Message{Id: "msg-1", Channel: "test-channel", CreateTime: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+
+		store.compact(time.Now().Add(2*time.Minute), time.Minute)
+
+		_, err = os.Stat(filepath.Join(dir, "test-channel"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		offset := uint64(1)
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		message, err := store.Append(Message{Id: "msg-2", Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), message.Offset)
+	})
+
+	t.Run("recovers from a partially written record", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+
+		for _, id := range []string{"msg-1", "msg-2"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, store.Close())
+
+		logPath := filepath.Join(dir, "test-channel", "00000000000000000001.log")
+		file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{0, 0, 1, 0, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		store, err = OpenFileMessageStore(logger, dir, 1024, 0, 0, 0, 0)
+		require.NoError(t, err)
+		defer store.Close()
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+
+		message, err := store.Append(Message{Id: "msg-3", Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), message.Offset)
+
+		messages, err = store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+		assert.Equal(t, "msg-3", messages[2].Id)
+	})
+}
@@ -5,8 +5,12 @@ import "time"
 type Message struct {
 	Id         string    `json:"id"`
 	Seq        uint64    `json:"seq"`
+	Offset     uint64    `json:"offset"`
 	CreateTime time.Time `json:"createTime"`
 	Channel    string    `json:"channel"`
 	Event      string    `json:"event"`
 	Payload    any       `json:"payload"`
+	// ExcludedConnectionId is a connection the message is not delivered to,
+	// such as the sender of a Pusher client event. It is not stored.
+	ExcludedConnectionId string `json:"-"`
 }
@@ -8,6 +8,281 @@ import (
 	mock "github.com/stretchr/testify/mock"
 )
 
+// NewMockBus creates a new instance of MockBus. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockBus(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockBus {
+	mock := &MockBus{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
+
+// MockBus is an autogenerated mock type for the Bus type
+type MockBus struct {
+	mock.Mock
+}
+
+type MockBus_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockBus) EXPECT() *MockBus_Expecter {
+	return &MockBus_Expecter{mock: &_m.Mock}
+}
+
+// Close provides a mock function for the type MockBus
+func (_mock *MockBus) Close() error {
+	ret := _mock.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Close")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func() error); ok {
+		r0 = returnFunc()
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockBus_Close_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Close'
+type MockBus_Close_Call struct {
+	*mock.Call
+}
+
+// Close is a helper method to define mock.On call
+func (_e *MockBus_Expecter) Close() *MockBus_Close_Call {
+	return &MockBus_Close_Call{Call: _e.mock.On("Close")}
+}
+
+func (_c *MockBus_Close_Call) Run(run func()) *MockBus_Close_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockBus_Close_Call) Return(err error) *MockBus_Close_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockBus_Close_Call) RunAndReturn(run func() error) *MockBus_Close_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Publish provides a mock function for the type MockBus
+func (_mock *MockBus) Publish(message Message) error {
+	ret := _mock.Called(message)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Publish")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(Message) error); ok {
+		r0 = returnFunc(message)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockBus_Publish_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Publish'
+type MockBus_Publish_Call struct {
+	*mock.Call
+}
+
+// Publish is a helper method to define mock.On call
+//   - message Message
+func (_e *MockBus_Expecter) Publish(message interface{}) *MockBus_Publish_Call {
+	return &MockBus_Publish_Call{Call: _e.mock.On("Publish", message)}
+}
+
+func (_c *MockBus_Publish_Call) Run(run func(message Message)) *MockBus_Publish_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 Message
+		if args[0] != nil {
+			arg0 = args[0].(Message)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockBus_Publish_Call) Return(err error) *MockBus_Publish_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockBus_Publish_Call) RunAndReturn(run func(message Message) error) *MockBus_Publish_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Start provides a mock function for the type MockBus
+func (_mock *MockBus) Start(handler func(message Message)) error {
+	ret := _mock.Called(handler)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Start")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(func(message Message)) error); ok {
+		r0 = returnFunc(handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockBus_Start_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Start'
+type MockBus_Start_Call struct {
+	*mock.Call
+}
+
+// Start is a helper method to define mock.On call
+//   - handler func(message Message)
+func (_e *MockBus_Expecter) Start(handler interface{}) *MockBus_Start_Call {
+	return &MockBus_Start_Call{Call: _e.mock.On("Start", handler)}
+}
+
+func (_c *MockBus_Start_Call) Run(run func(handler func(message Message))) *MockBus_Start_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 func(message Message)
+		if args[0] != nil {
+			arg0 = args[0].(func(message Message))
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockBus_Start_Call) Return(err error) *MockBus_Start_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockBus_Start_Call) RunAndReturn(run func(handler func(message Message)) error) *MockBus_Start_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Subscribe provides a mock function for the type MockBus
+func (_mock *MockBus) Subscribe(channelId string) error {
+	ret := _mock.Called(channelId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Subscribe")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string) error); ok {
+		r0 = returnFunc(channelId)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockBus_Subscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Subscribe'
+type MockBus_Subscribe_Call struct {
+	*mock.Call
+}
+
+// Subscribe is a helper method to define mock.On call
+//   - channelId string
+func (_e *MockBus_Expecter) Subscribe(channelId interface{}) *MockBus_Subscribe_Call {
+	return &MockBus_Subscribe_Call{Call: _e.mock.On("Subscribe", channelId)}
+}
+
+func (_c *MockBus_Subscribe_Call) Run(run func(channelId string)) *MockBus_Subscribe_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockBus_Subscribe_Call) Return(err error) *MockBus_Subscribe_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockBus_Subscribe_Call) RunAndReturn(run func(channelId string) error) *MockBus_Subscribe_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Unsubscribe provides a mock function for the type MockBus
+func (_mock *MockBus) Unsubscribe(channelId string) error {
+	ret := _mock.Called(channelId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Unsubscribe")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string) error); ok {
+		r0 = returnFunc(channelId)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockBus_Unsubscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Unsubscribe'
+type MockBus_Unsubscribe_Call struct {
+	*mock.Call
+}
+
+// Unsubscribe is a helper method to define mock.On call
+//   - channelId string
+func (_e *MockBus_Expecter) Unsubscribe(channelId interface{}) *MockBus_Unsubscribe_Call {
+	return &MockBus_Unsubscribe_Call{Call: _e.mock.On("Unsubscribe", channelId)}
+}
+
+func (_c *MockBus_Unsubscribe_Call) Run(run func(channelId string)) *MockBus_Unsubscribe_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockBus_Unsubscribe_Call) Return(err error) *MockBus_Unsubscribe_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockBus_Unsubscribe_Call) RunAndReturn(run func(channelId string) error) *MockBus_Unsubscribe_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockRegistry creates a new instance of MockRegistry. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockRegistry(t interface {
@@ -36,9 +311,29 @@ func (_m *MockRegistry) EXPECT() *MockRegistry_Expecter {
 }
 
 // Broadcast provides a mock function for the type MockRegistry
-func (_mock *MockRegistry) Broadcast(message Message) {
-	_mock.Called(message)
-	return
+func (_mock *MockRegistry) Broadcast(message Message) (Message, error) {
+	ret := _mock.Called(message)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Broadcast")
+	}
+
+	var r0 Message
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(Message) (Message, error)); ok {
+		return returnFunc(message)
+	}
+	if returnFunc, ok := ret.Get(0).(func(Message) Message); ok {
+		r0 = returnFunc(message)
+	} else {
+		r0 = ret.Get(0).(Message)
+	}
+	if returnFunc, ok := ret.Get(1).(func(Message) error); ok {
+		r1 = returnFunc(message)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
 }
 
 // MockRegistry_Broadcast_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Broadcast'
@@ -65,13 +360,13 @@ func (_c *MockRegistry_Broadcast_Call) Run(run func(message Message)) *MockRegis
 	return _c
 }
 
-func (_c *MockRegistry_Broadcast_Call) Return() *MockRegistry_Broadcast_Call {
-	_c.Call.Return()
+func (_c *MockRegistry_Broadcast_Call) Return(message1 Message, err error) *MockRegistry_Broadcast_Call {
+	_c.Call.Return(message1, err)
 	return _c
 }
 
-func (_c *MockRegistry_Broadcast_Call) RunAndReturn(run func(message Message)) *MockRegistry_Broadcast_Call {
-	_c.Run(run)
+func (_c *MockRegistry_Broadcast_Call) RunAndReturn(run func(message Message) (Message, error)) *MockRegistry_Broadcast_Call {
+	_c.Call.Return(run)
 	return _c
 }
 
@@ -166,44 +461,44 @@ func (_c *MockRegistry_Disconnect_Call) RunAndReturn(run func(connectionId strin
 	return _c
 }
 
-// Subscribe provides a mock function for the type MockRegistry
-func (_mock *MockRegistry) Subscribe(channelId string, connectionId string) error {
-	ret := _mock.Called(channelId, connectionId)
+// DisconnectMatching provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) DisconnectMatching(match func(connection *Connection) bool, reason CloseReason) int {
+	ret := _mock.Called(match, reason)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Subscribe")
+		panic("no return value specified for DisconnectMatching")
 	}
 
-	var r0 error
-	if returnFunc, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = returnFunc(channelId, connectionId)
+	var r0 int
+	if returnFunc, ok := ret.Get(0).(func(func(connection *Connection) bool, CloseReason) int); ok {
+		r0 = returnFunc(match, reason)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(int)
 	}
 	return r0
 }
 
-// MockRegistry_Subscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Subscribe'
-type MockRegistry_Subscribe_Call struct {
+// MockRegistry_DisconnectMatching_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DisconnectMatching'
+type MockRegistry_DisconnectMatching_Call struct {
 	*mock.Call
 }
 
-// Subscribe is a helper method to define mock.On call
-//   - channelId string
-//   - connectionId string
-func (_e *MockRegistry_Expecter) Subscribe(channelId interface{}, connectionId interface{}) *MockRegistry_Subscribe_Call {
-	return &MockRegistry_Subscribe_Call{Call: _e.mock.On("Subscribe", channelId, connectionId)}
+// DisconnectMatching is a helper method to define mock.On call
+//   - match func(connection *Connection) bool
+//   - reason CloseReason
+func (_e *MockRegistry_Expecter) DisconnectMatching(match interface{}, reason interface{}) *MockRegistry_DisconnectMatching_Call {
+	return &MockRegistry_DisconnectMatching_Call{Call: _e.mock.On("DisconnectMatching", match, reason)}
 }
 
-func (_c *MockRegistry_Subscribe_Call) Run(run func(channelId string, connectionId string)) *MockRegistry_Subscribe_Call {
+func (_c *MockRegistry_DisconnectMatching_Call) Run(run func(match func(connection *Connection) bool, reason CloseReason)) *MockRegistry_DisconnectMatching_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		var arg0 string
+		var arg0 func(connection *Connection) bool
 		if args[0] != nil {
-			arg0 = args[0].(string)
+			arg0 = args[0].(func(connection *Connection) bool)
 		}
-		var arg1 string
+		var arg1 CloseReason
 		if args[1] != nil {
-			arg1 = args[1].(string)
+			arg1 = args[1].(CloseReason)
 		}
 		run(
 			arg0,
@@ -213,43 +508,65 @@ func (_c *MockRegistry_Subscribe_Call) Run(run func(channelId string, connection
 	return _c
 }
 
-func (_c *MockRegistry_Subscribe_Call) Return(err error) *MockRegistry_Subscribe_Call {
-	_c.Call.Return(err)
+func (_c *MockRegistry_DisconnectMatching_Call) Return(n int) *MockRegistry_DisconnectMatching_Call {
+	_c.Call.Return(n)
 	return _c
 }
 
-func (_c *MockRegistry_Subscribe_Call) RunAndReturn(run func(channelId string, connectionId string) error) *MockRegistry_Subscribe_Call {
+func (_c *MockRegistry_DisconnectMatching_Call) RunAndReturn(run func(match func(connection *Connection) bool, reason CloseReason) int) *MockRegistry_DisconnectMatching_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Unsubscribe provides a mock function for the type MockRegistry
-func (_mock *MockRegistry) Unsubscribe(channelId string, connectionId string) {
-	_mock.Called(channelId, connectionId)
-	return
+// History provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) History(channelId string, query HistoryQuery) ([]Message, error) {
+	ret := _mock.Called(channelId, query)
+
+	if len(ret) == 0 {
+		panic("no return value specified for History")
+	}
+
+	var r0 []Message
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string, HistoryQuery) ([]Message, error)); ok {
+		return returnFunc(channelId, query)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string, HistoryQuery) []Message); ok {
+		r0 = returnFunc(channelId, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Message)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(string, HistoryQuery) error); ok {
+		r1 = returnFunc(channelId, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
 }
 
-// MockRegistry_Unsubscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Unsubscribe'
-type MockRegistry_Unsubscribe_Call struct {
+// MockRegistry_History_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'History'
+type MockRegistry_History_Call struct {
 	*mock.Call
 }
 
-// Unsubscribe is a helper method to define mock.On call
+// History is a helper method to define mock.On call
 //   - channelId string
-//   - connectionId string
-func (_e *MockRegistry_Expecter) Unsubscribe(channelId interface{}, connectionId interface{}) *MockRegistry_Unsubscribe_Call {
-	return &MockRegistry_Unsubscribe_Call{Call: _e.mock.On("Unsubscribe", channelId, connectionId)}
+//   - query HistoryQuery
+func (_e *MockRegistry_Expecter) History(channelId interface{}, query interface{}) *MockRegistry_History_Call {
+	return &MockRegistry_History_Call{Call: _e.mock.On("History", channelId, query)}
 }
 
-func (_c *MockRegistry_Unsubscribe_Call) Run(run func(channelId string, connectionId string)) *MockRegistry_Unsubscribe_Call {
+func (_c *MockRegistry_History_Call) Run(run func(channelId string, query HistoryQuery)) *MockRegistry_History_Call {
 	_c.Call.Run(func(args mock.Arguments) {
 		var arg0 string
 		if args[0] != nil {
 			arg0 = args[0].(string)
 		}
-		var arg1 string
+		var arg1 HistoryQuery
 		if args[1] != nil {
-			arg1 = args[1].(string)
+			arg1 = args[1].(HistoryQuery)
 		}
 		run(
 			arg0,
@@ -259,12 +576,512 @@ func (_c *MockRegistry_Unsubscribe_Call) Run(run func(channelId string, connecti
 	return _c
 }
 
-func (_c *MockRegistry_Unsubscribe_Call) Return() *MockRegistry_Unsubscribe_Call {
-	_c.Call.Return()
+func (_c *MockRegistry_History_Call) Return(messages []Message, err error) *MockRegistry_History_Call {
+	_c.Call.Return(messages, err)
 	return _c
 }
 
-func (_c *MockRegistry_Unsubscribe_Call) RunAndReturn(run func(channelId string, connectionId string)) *MockRegistry_Unsubscribe_Call {
-	_c.Run(run)
+func (_c *MockRegistry_History_Call) RunAndReturn(run func(channelId string, query HistoryQuery) ([]Message, error)) *MockRegistry_History_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Presence provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) Presence(channelId string) []PresenceMember {
+	ret := _mock.Called(channelId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Presence")
+	}
+
+	var r0 []PresenceMember
+	if returnFunc, ok := ret.Get(0).(func(string) []PresenceMember); ok {
+		r0 = returnFunc(channelId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]PresenceMember)
+		}
+	}
+	return r0
+}
+
+// MockRegistry_Presence_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Presence'
+type MockRegistry_Presence_Call struct {
+	*mock.Call
+}
+
+// Presence is a helper method to define mock.On call
+//   - channelId string
+func (_e *MockRegistry_Expecter) Presence(channelId interface{}) *MockRegistry_Presence_Call {
+	return &MockRegistry_Presence_Call{Call: _e.mock.On("Presence", channelId)}
+}
+
+func (_c *MockRegistry_Presence_Call) Run(run func(channelId string)) *MockRegistry_Presence_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockRegistry_Presence_Call) Return(presenceMembers []PresenceMember) *MockRegistry_Presence_Call {
+	_c.Call.Return(presenceMembers)
+	return _c
+}
+
+func (_c *MockRegistry_Presence_Call) RunAndReturn(run func(channelId string) []PresenceMember) *MockRegistry_Presence_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Resume provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) Resume(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions) error {
+	ret := _mock.Called(sessionId, connectionId, subscriptions)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Resume")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string, string, map[string]SubscribeOptions) error); ok {
+		r0 = returnFunc(sessionId, connectionId, subscriptions)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockRegistry_Resume_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Resume'
+type MockRegistry_Resume_Call struct {
+	*mock.Call
+}
+
+// Resume is a helper method to define mock.On call
+//   - sessionId string
+//   - connectionId string
+//   - subscriptions map[string]SubscribeOptions
+func (_e *MockRegistry_Expecter) Resume(sessionId interface{}, connectionId interface{}, subscriptions interface{}) *MockRegistry_Resume_Call {
+	return &MockRegistry_Resume_Call{Call: _e.mock.On("Resume", sessionId, connectionId, subscriptions)}
+}
+
+func (_c *MockRegistry_Resume_Call) Run(run func(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions)) *MockRegistry_Resume_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		var arg2 map[string]SubscribeOptions
+		if args[2] != nil {
+			arg2 = args[2].(map[string]SubscribeOptions)
+		}
+		run(
+			arg0,
+			arg1,
+			arg2,
+		)
+	})
+	return _c
+}
+
+func (_c *MockRegistry_Resume_Call) Return(err error) *MockRegistry_Resume_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockRegistry_Resume_Call) RunAndReturn(run func(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions) error) *MockRegistry_Resume_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Session provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) Session(sessionId string) (Session, bool) {
+	ret := _mock.Called(sessionId)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Session")
+	}
+
+	var r0 Session
+	var r1 bool
+	if returnFunc, ok := ret.Get(0).(func(string) (Session, bool)); ok {
+		return returnFunc(sessionId)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string) Session); ok {
+		r0 = returnFunc(sessionId)
+	} else {
+		r0 = ret.Get(0).(Session)
+	}
+	if returnFunc, ok := ret.Get(1).(func(string) bool); ok {
+		r1 = returnFunc(sessionId)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+	return r0, r1
+}
+
+// MockRegistry_Session_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Session'
+type MockRegistry_Session_Call struct {
+	*mock.Call
+}
+
+// Session is a helper method to define mock.On call
+//   - sessionId string
+func (_e *MockRegistry_Expecter) Session(sessionId interface{}) *MockRegistry_Session_Call {
+	return &MockRegistry_Session_Call{Call: _e.mock.On("Session", sessionId)}
+}
+
+func (_c *MockRegistry_Session_Call) Run(run func(sessionId string)) *MockRegistry_Session_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockRegistry_Session_Call) Return(session Session, b bool) *MockRegistry_Session_Call {
+	_c.Call.Return(session, b)
+	return _c
+}
+
+func (_c *MockRegistry_Session_Call) RunAndReturn(run func(sessionId string) (Session, bool)) *MockRegistry_Session_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Subscribe provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) Subscribe(channelId string, connectionId string, options SubscribeOptions) error {
+	ret := _mock.Called(channelId, connectionId, options)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Subscribe")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func(string, string, SubscribeOptions) error); ok {
+		r0 = returnFunc(channelId, connectionId, options)
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// MockRegistry_Subscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Subscribe'
+type MockRegistry_Subscribe_Call struct {
+	*mock.Call
+}
+
+// Subscribe is a helper method to define mock.On call
+//   - channelId string
+//   - connectionId string
+//   - options SubscribeOptions
+func (_e *MockRegistry_Expecter) Subscribe(channelId interface{}, connectionId interface{}, options interface{}) *MockRegistry_Subscribe_Call {
+	return &MockRegistry_Subscribe_Call{Call: _e.mock.On("Subscribe", channelId, connectionId, options)}
+}
+
+func (_c *MockRegistry_Subscribe_Call) Run(run func(channelId string, connectionId string, options SubscribeOptions)) *MockRegistry_Subscribe_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		var arg2 SubscribeOptions
+		if args[2] != nil {
+			arg2 = args[2].(SubscribeOptions)
+		}
+		run(
+			arg0,
+			arg1,
+			arg2,
+		)
+	})
+	return _c
+}
+
+func (_c *MockRegistry_Subscribe_Call) Return(err error) *MockRegistry_Subscribe_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *MockRegistry_Subscribe_Call) RunAndReturn(run func(channelId string, connectionId string, options SubscribeOptions) error) *MockRegistry_Subscribe_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Unsubscribe provides a mock function for the type MockRegistry
+func (_mock *MockRegistry) Unsubscribe(channelId string, connectionId string) {
+	_mock.Called(channelId, connectionId)
+	return
+}
+
+// MockRegistry_Unsubscribe_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Unsubscribe'
+type MockRegistry_Unsubscribe_Call struct {
+	*mock.Call
+}
+
+// Unsubscribe is a helper method to define mock.On call
+//   - channelId string
+//   - connectionId string
+func (_e *MockRegistry_Expecter) Unsubscribe(channelId interface{}, connectionId interface{}) *MockRegistry_Unsubscribe_Call {
+	return &MockRegistry_Unsubscribe_Call{Call: _e.mock.On("Unsubscribe", channelId, connectionId)}
+}
+
+func (_c *MockRegistry_Unsubscribe_Call) Run(run func(channelId string, connectionId string)) *MockRegistry_Unsubscribe_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		run(
+			arg0,
+			arg1,
+		)
+	})
+	return _c
+}
+
+func (_c *MockRegistry_Unsubscribe_Call) Return() *MockRegistry_Unsubscribe_Call {
+	_c.Call.Return()
+	return _c
+}
+
+func (_c *MockRegistry_Unsubscribe_Call) RunAndReturn(run func(channelId string, connectionId string)) *MockRegistry_Unsubscribe_Call {
+	_c.Run(run)
+	return _c
+}
+
+// NewMockMessageStore creates a new instance of MockMessageStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockMessageStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockMessageStore {
+	mock := &MockMessageStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
+
+// MockMessageStore is an autogenerated mock type for the MessageStore type
+type MockMessageStore struct {
+	mock.Mock
+}
+
+type MockMessageStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockMessageStore) EXPECT() *MockMessageStore_Expecter {
+	return &MockMessageStore_Expecter{mock: &_m.Mock}
+}
+
+// Append provides a mock function for the type MockMessageStore
+func (_mock *MockMessageStore) Append(message Message) (Message, error) {
+	ret := _mock.Called(message)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Append")
+	}
+
+	var r0 Message
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(Message) (Message, error)); ok {
+		return returnFunc(message)
+	}
+	if returnFunc, ok := ret.Get(0).(func(Message) Message); ok {
+		r0 = returnFunc(message)
+	} else {
+		r0 = ret.Get(0).(Message)
+	}
+	if returnFunc, ok := ret.Get(1).(func(Message) error); ok {
+		r1 = returnFunc(message)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockMessageStore_Append_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Append'
+type MockMessageStore_Append_Call struct {
+	*mock.Call
+}
+
+// Append is a helper method to define mock.On call
+//   - message Message
+func (_e *MockMessageStore_Expecter) Append(message interface{}) *MockMessageStore_Append_Call {
+	return &MockMessageStore_Append_Call{Call: _e.mock.On("Append", message)}
+}
+
+func (_c *MockMessageStore_Append_Call) Run(run func(message Message)) *MockMessageStore_Append_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 Message
+		if args[0] != nil {
+			arg0 = args[0].(Message)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockMessageStore_Append_Call) Return(message1 Message, err error) *MockMessageStore_Append_Call {
+	_c.Call.Return(message1, err)
+	return _c
+}
+
+func (_c *MockMessageStore_Append_Call) RunAndReturn(run func(message Message) (Message, error)) *MockMessageStore_Append_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Query provides a mock function for the type MockMessageStore
+func (_mock *MockMessageStore) Query(channelId string, query HistoryQuery) ([]Message, error) {
+	ret := _mock.Called(channelId, query)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Query")
+	}
+
+	var r0 []Message
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(string, HistoryQuery) ([]Message, error)); ok {
+		return returnFunc(channelId, query)
+	}
+	if returnFunc, ok := ret.Get(0).(func(string, HistoryQuery) []Message); ok {
+		r0 = returnFunc(channelId, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Message)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(string, HistoryQuery) error); ok {
+		r1 = returnFunc(channelId, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockMessageStore_Query_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Query'
+type MockMessageStore_Query_Call struct {
+	*mock.Call
+}
+
+// Query is a helper method to define mock.On call
+//   - channelId string
+//   - query HistoryQuery
+func (_e *MockMessageStore_Expecter) Query(channelId interface{}, query interface{}) *MockMessageStore_Query_Call {
+	return &MockMessageStore_Query_Call{Call: _e.mock.On("Query", channelId, query)}
+}
+
+func (_c *MockMessageStore_Query_Call) Run(run func(channelId string, query HistoryQuery)) *MockMessageStore_Query_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 string
+		if args[0] != nil {
+			arg0 = args[0].(string)
+		}
+		var arg1 HistoryQuery
+		if args[1] != nil {
+			arg1 = args[1].(HistoryQuery)
+		}
+		run(
+			arg0,
+			arg1,
+		)
+	})
+	return _c
+}
+
+func (_c *MockMessageStore_Query_Call) Return(messages []Message, err error) *MockMessageStore_Query_Call {
+	_c.Call.Return(messages, err)
+	return _c
+}
+
+func (_c *MockMessageStore_Query_Call) RunAndReturn(run func(channelId string, query HistoryQuery) ([]Message, error)) *MockMessageStore_Query_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Replicate provides a mock function for the type MockMessageStore
+func (_mock *MockMessageStore) Replicate(message Message) (Message, error) {
+	ret := _mock.Called(message)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Replicate")
+	}
+
+	var r0 Message
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(Message) (Message, error)); ok {
+		return returnFunc(message)
+	}
+	if returnFunc, ok := ret.Get(0).(func(Message) Message); ok {
+		r0 = returnFunc(message)
+	} else {
+		r0 = ret.Get(0).(Message)
+	}
+	if returnFunc, ok := ret.Get(1).(func(Message) error); ok {
+		r1 = returnFunc(message)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// MockMessageStore_Replicate_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Replicate'
+type MockMessageStore_Replicate_Call struct {
+	*mock.Call
+}
+
+// Replicate is a helper method to define mock.On call
+//   - message Message
+func (_e *MockMessageStore_Expecter) Replicate(message interface{}) *MockMessageStore_Replicate_Call {
+	return &MockMessageStore_Replicate_Call{Call: _e.mock.On("Replicate", message)}
+}
+
+func (_c *MockMessageStore_Replicate_Call) Run(run func(message Message)) *MockMessageStore_Replicate_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 Message
+		if args[0] != nil {
+			arg0 = args[0].(Message)
+		}
+		run(
+			arg0,
+		)
+	})
+	return _c
+}
+
+func (_c *MockMessageStore_Replicate_Call) Return(message1 Message, err error) *MockMessageStore_Replicate_Call {
+	_c.Call.Return(message1, err)
+	return _c
+}
+
+func (_c *MockMessageStore_Replicate_Call) RunAndReturn(run func(message Message) (Message, error)) *MockMessageStore_Replicate_Call {
+	_c.Call.Return(run)
 	return _c
 }
@@ -0,0 +1,85 @@
+package broadcaster
+
+import (
+	"strings"
+
+	"github.com/goevery/broadcaster/internal/pattern"
+)
+
+// patternTrie indexes pattern subscriptions by segment, so that the patterns
+// matching a channel are found by walking the segments of the channel rather
+// than testing every pattern.
+type patternTrie struct {
+	children    map[string]*patternTrie
+	connections map[string]struct{}
+}
+
+func newPatternTrie() *patternTrie {
+	return &patternTrie{
+		children:    make(map[string]*patternTrie),
+		connections: make(map[string]struct{}),
+	}
+}
+
+func (t *patternTrie) add(channelPattern string, connectionId string) {
+	node := t
+	for _, segment := range strings.Split(channelPattern, pattern.Separator) {
+		child, ok := node.children[segment]
+		if !ok {
+			child = newPatternTrie()
+			node.children[segment] = child
+		}
+
+		node = child
+	}
+
+	node.connections[connectionId] = struct{}{}
+}
+
+func (t *patternTrie) remove(channelPattern string, connectionId string) {
+	t.removeSegments(strings.Split(channelPattern, pattern.Separator), connectionId)
+}
+
+// removeSegments reports whether the node became empty and can be pruned.
+func (t *patternTrie) removeSegments(segments []string, connectionId string) bool {
+	if len(segments) == 0 {
+		delete(t.connections, connectionId)
+	} else if child, ok := t.children[segments[0]]; ok {
+		if child.removeSegments(segments[1:], connectionId) {
+			delete(t.children, segments[0])
+		}
+	}
+
+	return len(t.children) == 0 && len(t.connections) == 0
+}
+
+// match calls visit for every connection subscribed to a pattern matching the
+// channel. A connection subscribed to several matching patterns is visited
+// once per pattern.
+func (t *patternTrie) match(channelId string, visit func(connectionId string)) {
+	t.matchSegments(strings.Split(channelId, pattern.Separator), visit)
+}
+
+func (t *patternTrie) matchSegments(segments []string, visit func(connectionId string)) {
+	if len(segments) == 0 {
+		for connectionId := range t.connections {
+			visit(connectionId)
+		}
+
+		return
+	}
+
+	if child, ok := t.children[pattern.MultiWildcard]; ok {
+		for connectionId := range child.connections {
+			visit(connectionId)
+		}
+	}
+
+	if child, ok := t.children[pattern.SingleWildcard]; ok {
+		child.matchSegments(segments[1:], visit)
+	}
+
+	if child, ok := t.children[segments[0]]; ok {
+		child.matchSegments(segments[1:], visit)
+	}
+}
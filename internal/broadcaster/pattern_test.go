@@ -0,0 +1,63 @@
+package broadcaster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInMemoryRegistry_Patterns(t *testing.T) {
+	registry := NewInMemoryRegistry(zap.NewNop(), NewInMemoryMessageStore(10, 0), 0)
+
+	connect := func(connectionId string, channels ...string) *Connection {
+		connection := &Connection{Id: connectionId, Send: make(chan Message, 10)}
+		require.NoError(t, registry.Connect(connection))
+
+		for _, channelId := range channels {
+			require.NoError(t, registry.Subscribe(channelId, connectionId, SubscribeOptions{}))
+		}
+
+		return connection
+	}
+
+	received := func(connection *Connection) []string {
+		var channels []string
+		for {
+			select {
+			case message := <-connection.Send:
+				channels = append(channels, message.Channel)
+			default:
+				return channels
+			}
+		}
+	}
+
+	single := connect("single", "org:42:*")
+	multi := connect("multi", "org:42:**")
+	overlapping := connect("overlapping", "org:42:room", "org:*:room", "org:42:**")
+	other := connect("other", "org:7:*")
+
+	for _, channelId := range []string{"org:42:room", "org:42:room:7", "org:42", "org:7:room"} {
+		_, err := registry.Broadcast(Message{Channel: channelId, CreateTime: time.Now()})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"org:42:room"}, received(single))
+	assert.Equal(t, []string{"org:42:room", "org:42:room:7"}, received(multi))
+	assert.Equal(t, []string{"org:42:room", "org:42:room:7", "org:7:room"}, received(overlapping))
+	assert.Equal(t, []string{"org:7:room"}, received(other))
+
+	registry.Unsubscribe("org:42:*", single.Id)
+	registry.Disconnect(multi.Id)
+
+	_, err := registry.Broadcast(Message{Channel: "org:42:room", CreateTime: time.Now()})
+	require.NoError(t, err)
+
+	assert.Empty(t, received(single))
+	assert.Equal(t, []string{"org:42:room"}, received(overlapping))
+	assert.True(t, registry.HasSubscribers("org:42:**"))
+	assert.False(t, registry.HasSubscribers("org:42:*"))
+}
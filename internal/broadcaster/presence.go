@@ -0,0 +1,134 @@
+package broadcaster
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const (
+	// PresenceChannelPrefix marks the channels whose subscribers are tracked.
+	PresenceChannelPrefix = "presence:"
+
+	PresenceJoinEvent  = "presence.join"
+	PresenceLeaveEvent = "presence.leave"
+)
+
+func IsPresenceChannel(channelId string) bool {
+	return strings.HasPrefix(channelId, PresenceChannelPrefix)
+}
+
+// PresenceMember is a user subscribed to a presence channel. A user is a member
+// once, however many of its connections are subscribed. The metadata is the
+// one supplied by the connection that joined first.
+type PresenceMember struct {
+	UserId   string    `json:"userId"`
+	Metadata any       `json:"metadata,omitempty"`
+	JoinTime time.Time `json:"joinTime"`
+}
+
+type presenceEntry struct {
+	member      PresenceMember
+	connections map[string]struct{}
+}
+
+type presenceChannel struct {
+	entries map[string]*presenceEntry
+	users   map[string]string
+}
+
+// presenceSet tracks the members of the presence channels.
+type presenceSet map[string]*presenceChannel
+
+// join records the connection and returns the member when it is the first
+// connection of its user.
+func (s presenceSet) join(channelId string, userId string, connectionId string, metadata any) (PresenceMember, bool) {
+	channel, ok := s[channelId]
+	if !ok {
+		channel = &presenceChannel{
+			entries: make(map[string]*presenceEntry),
+			users:   make(map[string]string),
+		}
+		s[channelId] = channel
+	}
+
+	channel.users[connectionId] = userId
+
+	entry, ok := channel.entries[userId]
+	if ok {
+		entry.connections[connectionId] = struct{}{}
+
+		return PresenceMember{}, false
+	}
+
+	entry = &presenceEntry{
+		member: PresenceMember{
+			UserId:   userId,
+			Metadata: metadata,
+			JoinTime: time.Now(),
+		},
+		connections: map[string]struct{}{connectionId: {}},
+	}
+	channel.entries[userId] = entry
+
+	return entry.member, true
+}
+
+// leave forgets the connection and returns the member when it was the last
+// connection of its user.
+func (s presenceSet) leave(channelId string, connectionId string) (PresenceMember, bool) {
+	channel, ok := s[channelId]
+	if !ok {
+		return PresenceMember{}, false
+	}
+
+	userId, ok := channel.users[connectionId]
+	if !ok {
+		return PresenceMember{}, false
+	}
+
+	delete(channel.users, connectionId)
+
+	entry := channel.entries[userId]
+	delete(entry.connections, connectionId)
+	if len(entry.connections) > 0 {
+		return PresenceMember{}, false
+	}
+
+	delete(channel.entries, userId)
+	if len(channel.entries) == 0 {
+		delete(s, channelId)
+	}
+
+	return entry.member, true
+}
+
+func (s presenceSet) members(channelId string) []PresenceMember {
+	channel, ok := s[channelId]
+	if !ok {
+		return []PresenceMember{}
+	}
+
+	members := make([]PresenceMember, 0, len(channel.entries))
+	for _, entry := range channel.entries {
+		members = append(members, entry.member)
+	}
+
+	slices.SortFunc(members, func(a, b PresenceMember) int {
+		return strings.Compare(a.UserId, b.UserId)
+	})
+
+	return members
+}
+
+func newPresenceMessage(channelId string, event string, member PresenceMember) Message {
+	return Message{
+		Id:         gonanoid.Must(),
+		CreateTime: time.Now(),
+		Channel:    channelId,
+		Event:      event,
+		Payload:    member,
+	}
+}
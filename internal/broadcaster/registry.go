@@ -2,42 +2,104 @@ package broadcaster
 
 import (
 	"errors"
+	"hash/fnv"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
 	"go.uber.org/zap"
 )
 
+// ErrReplayTooLarge is returned when the messages to replay do not fit in the
+// free space of the send channel of a connection.
+var ErrReplayTooLarge = errors.New("not enough room in connection send channel to replay history")
+
+type SubscribeOptions struct {
+	// History, when set, replays the matching stored messages to the
+	// connection before any live message is delivered.
+	History *HistoryQuery
+	// Metadata is attached to the user in the members of a presence channel.
+	Metadata any
+}
+
 type Registry interface {
 	Connect(connection *Connection) error
-	Broadcast(message Message)
-	Subscribe(channelId string, connectionId string) error
+	Broadcast(message Message) (Message, error)
+	Subscribe(channelId string, connectionId string, options SubscribeOptions) error
 	Unsubscribe(channelId string, connectionId string)
 	Disconnect(connectionId string)
+	// DisconnectMatching closes the connections the match function selects with
+	// the reason and returns how many were closed.
+	DisconnectMatching(match func(connection *Connection) bool, reason CloseReason) int
+	History(channelId string, query HistoryQuery) ([]Message, error)
+	Session(sessionId string) (Session, bool)
+	Resume(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions) error
+	Presence(channelId string) []PresenceMember
 }
 
+// broadcastLockCount is the number of locks channels are spread over to keep
+// the delivery order of a channel in line with its offsets.
+const broadcastLockCount = 64
+
 type InMemoryRegistry struct {
 	logger *zap.Logger
+	store  MessageStore
 	mu     sync.RWMutex
 
+	broadcastLocks [broadcastLockCount]sync.Mutex
+
 	connections          map[string]*Connection
 	connectionsByChannel map[string]map[string]struct{}
 	channelsByConnection map[string]map[string]struct{}
+	detachedSessions     *sessionQueue
+	presence             presenceSet
+	patterns             *patternTrie
+
+	channelListener func(channelId string)
+	changedChannels []string
 }
 
 func NewInMemoryRegistry(
 	logger *zap.Logger,
+	store MessageStore,
+	sessionTTL time.Duration,
 ) *InMemoryRegistry {
 	return &InMemoryRegistry{
 		logger:               logger,
+		store:                store,
 		connections:          make(map[string]*Connection),
 		connectionsByChannel: make(map[string]map[string]struct{}),
 		channelsByConnection: make(map[string]map[string]struct{}),
+		detachedSessions:     newSessionQueue(sessionTTL),
+		presence:             make(presenceSet),
+		patterns:             newPatternTrie(),
 	}
 }
 
+// OnChannelChange registers a listener called whenever a channel gets its
+// first subscriber or loses its last one. It must be called before the
+// registry is used. The listener runs outside of the registry lock, so it
+// should query HasSubscribers rather than assume the direction of the change.
+func (r *InMemoryRegistry) OnChannelChange(listener func(channelId string)) {
+	r.channelListener = listener
+}
+
+// HasSubscribers reports whether at least one connection is subscribed to the
+// channel.
+func (r *InMemoryRegistry) HasSubscribers(channelId string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.connectionsByChannel[channelId]
+
+	return ok
+}
+
 func (r *InMemoryRegistry) Connect(connection *Connection) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.unlock()
 
 	if _, ok := r.connections[connection.Id]; ok {
 		return errors.New("connection already connected")
@@ -49,26 +111,45 @@ func (r *InMemoryRegistry) Connect(connection *Connection) error {
 	return nil
 }
 
-func (r *InMemoryRegistry) Broadcast(message Message) {
+func (r *InMemoryRegistry) Broadcast(message Message) (Message, error) {
+	return r.broadcast(message, r.store.Append)
+}
+
+// replicate delivers a message published by another node, keeping its offset.
+func (r *InMemoryRegistry) replicate(message Message) (Message, error) {
+	return r.broadcast(message, r.store.Replicate)
+}
+
+func (r *InMemoryRegistry) broadcast(message Message, store func(message Message) (Message, error)) (Message, error) {
 	r.mu.RLock()
 
-	connectionIds, ok := r.connectionsByChannel[message.Channel]
-	if !ok {
+	// Offsets are assigned and messages delivered under the channel lock, so
+	// that every subscriber receives the messages of a channel in offset
+	// order. The registry lock makes a concurrent subscription either replay
+	// the message from history or receive it live.
+	broadcastLock := &r.broadcastLocks[channelHash(message.Channel)%broadcastLockCount]
+	broadcastLock.Lock()
+
+	excludedConnectionId := message.ExcludedConnectionId
+	message.ExcludedConnectionId = ""
+
+	message, err := store(message)
+	if err != nil {
+		broadcastLock.Unlock()
 		r.mu.RUnlock()
 
-		return
+		return Message{}, err
 	}
 
-	connections := make([]*Connection, 0, len(connectionIds))
-	for connectionId := range connectionIds {
-		if connection, ok := r.connections[connectionId]; ok {
-			connections = append(connections, connection)
-		}
-	}
+	connections := r.subscribersLocked(message.Channel)
 
 	var staleConnectionIds []string
 
 	for _, connection := range connections {
+		if connection.Id == excludedConnectionId {
+			continue
+		}
+
 		msg := message
 		msg.Seq = connection.NextSeq()
 
@@ -82,10 +163,11 @@ func (r *InMemoryRegistry) Broadcast(message Message) {
 		}
 	}
 
+	broadcastLock.Unlock()
 	r.mu.RUnlock()
 
 	if len(staleConnectionIds) == 0 {
-		return
+		return message, nil
 	}
 
 	r.mu.Lock()
@@ -94,47 +176,413 @@ func (r *InMemoryRegistry) Broadcast(message Message) {
 		r.disconnectLocked(connectionId)
 	}
 
-	r.mu.Unlock()
+	r.unlock()
+
+	return message, nil
 }
 
-func (r *InMemoryRegistry) Subscribe(channelId string, connectionId string) error {
+func (r *InMemoryRegistry) Subscribe(channelId string, connectionId string, options SubscribeOptions) error {
+	var prefetched []Message
+	if options.History != nil {
+		prefetched = r.prefetchHistory(connectionId, channelId, *options.History)
+	}
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.unlock()
 
-	if _, ok := r.connections[connectionId]; !ok {
+	connection, ok := r.connections[connectionId]
+	if !ok {
 		return errors.New("connection not connected")
 	}
 
+	// Check if connection is already subscribed to the channel
+	if _, ok := r.channelsByConnection[connectionId][channelId]; ok {
+		return errors.New("connection already subscribed to channel")
+	}
+
+	if options.History != nil {
+		err := r.replayLocked(connection, channelId, *options.History, prefetched)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.subscribeLocked(channelId, connectionId, options.Metadata)
+
+	return nil
+}
+
+// subscribersLocked returns the connections subscribed to the channel, either
+// directly or through a pattern, once each.
+//
+// IMPORTANT: It must be called only when a lock is already held.
+func (r *InMemoryRegistry) subscribersLocked(channelId string) []*Connection {
+	connectionIds := r.connectionsByChannel[channelId]
+
+	var patternConnectionIds map[string]struct{}
+	r.patterns.match(channelId, func(connectionId string) {
+		if _, ok := connectionIds[connectionId]; ok {
+			return
+		}
+
+		if patternConnectionIds == nil {
+			patternConnectionIds = make(map[string]struct{})
+		}
+
+		patternConnectionIds[connectionId] = struct{}{}
+	})
+
+	connections := make([]*Connection, 0, len(connectionIds)+len(patternConnectionIds))
+	for _, ids := range []map[string]struct{}{connectionIds, patternConnectionIds} {
+		for connectionId := range ids {
+			if connection, ok := r.connections[connectionId]; ok {
+				connections = append(connections, connection)
+			}
+		}
+	}
+
+	return connections
+}
+
+// IMPORTANT: It must be called only when a write lock is already held.
+func (r *InMemoryRegistry) subscribeLocked(channelId string, connectionId string, metadata any) {
 	// Ensure map for the channel exists
 	if _, ok := r.connectionsByChannel[channelId]; !ok {
 		r.connectionsByChannel[channelId] = make(map[string]struct{})
-	}
-
-	// Check if connection is already subscribed to the channel
-	if _, ok := r.connectionsByChannel[channelId][connectionId]; ok {
-		return errors.New("connection already subscribed to channel")
+		r.changedChannels = append(r.changedChannels, channelId)
 	}
 
 	r.connectionsByChannel[channelId][connectionId] = struct{}{}
 	r.channelsByConnection[connectionId][channelId] = struct{}{}
 
+	if pattern.IsPattern(channelId) {
+		r.patterns.add(channelId, connectionId)
+
+		return
+	}
+
+	if !IsPresenceChannel(channelId) {
+		return
+	}
+
+	userId := r.connections[connectionId].GetUserId()
+	if userId == "" {
+		return
+	}
+
+	member, joined := r.presence.join(channelId, userId, connectionId, metadata)
+	if joined {
+		r.notifyLocked(newPresenceMessage(channelId, PresenceJoinEvent, member), connectionId)
+	}
+}
+
+// leaveLocked undoes what subscribeLocked did besides the subscription itself:
+// it removes patterns from the index and leaves presence channels.
+//
+// IMPORTANT: It must be called only when a write lock is already held, after
+// the connection was removed from the channel.
+func (r *InMemoryRegistry) leaveLocked(channelId string, connectionId string) {
+	if pattern.IsPattern(channelId) {
+		r.patterns.remove(channelId, connectionId)
+
+		return
+	}
+
+	if !IsPresenceChannel(channelId) {
+		return
+	}
+
+	member, left := r.presence.leave(channelId, connectionId)
+	if left {
+		r.notifyLocked(newPresenceMessage(channelId, PresenceLeaveEvent, member), "")
+	}
+}
+
+// notifyLocked delivers a message that is not stored, such as a presence
+// event, to the subscribers of its channel but the excluded connection.
+//
+// IMPORTANT: It must be called only when a write lock is already held.
+func (r *InMemoryRegistry) notifyLocked(message Message, excludedConnectionId string) {
+	var staleConnectionIds []string
+
+	for _, connection := range r.subscribersLocked(message.Channel) {
+		if connection.Id == excludedConnectionId {
+			continue
+		}
+
+		msg := message
+		msg.Seq = connection.NextSeq()
+
+		select {
+		case connection.Send <- msg:
+		default:
+			r.logger.Warn("connection send channel is full, closing connection",
+				zap.String("connectionId", connection.Id))
+
+			staleConnectionIds = append(staleConnectionIds, connection.Id)
+		}
+	}
+
+	for _, connectionId := range staleConnectionIds {
+		r.disconnectLocked(connectionId)
+	}
+}
+
+func (r *InMemoryRegistry) Presence(channelId string) []PresenceMember {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.presence.members(channelId)
+}
+
+// prefetchHistory reads the history to replay to a connection before the
+// registry lock is taken, so that a slow store does not block the other
+// subscriptions and the broadcasts. The messages stored in the meantime are
+// read under the lock by queryHistoryLocked, which also handles the failures.
+func (r *InMemoryRegistry) prefetchHistory(connectionId string, channelId string, query HistoryQuery) []Message {
+	r.mu.RLock()
+	connection, ok := r.connections[connectionId]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	messages, err := r.store.Query(channelId, replayQuery(query, connection))
+	if err != nil {
+		return nil
+	}
+
+	return messages
+}
+
+// queryHistoryLocked returns the prefetched messages followed by the matching
+// messages stored since.
+//
+// IMPORTANT: It must be called only when a write lock is already held, so that
+// no message can be broadcast between the history query and the subscription.
+func (r *InMemoryRegistry) queryHistoryLocked(
+	connection *Connection,
+	channelId string,
+	query HistoryQuery,
+	prefetched []Message,
+) ([]Message, error) {
+	catchUp := query
+	if len(prefetched) > 0 {
+		lastOffset := prefetched[len(prefetched)-1].Offset
+		catchUp.AfterOffset = &lastOffset
+	}
+
+	messages, err := r.store.Query(channelId, replayQuery(catchUp, connection))
+	if errors.Is(err, ErrHistoryUnavailable) && len(prefetched) > 0 {
+		// The prefetched messages were dropped in the meantime.
+		prefetched = nil
+		messages, err = r.store.Query(channelId, replayQuery(query, connection))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	messages = append(prefetched, messages...)
+	if query.Limit > 0 && len(messages) > query.Limit {
+		messages = messages[len(messages)-query.Limit:]
+	}
+
+	return messages, nil
+}
+
+// replayQuery limits a query to one message more than the room left in the
+// send channel of the connection, which tells that a replay does not fit
+// without reading the whole history.
+func replayQuery(query HistoryQuery, connection *Connection) HistoryQuery {
+	limit := cap(connection.Send) - len(connection.Send) + 1
+	if query.Limit == 0 || query.Limit > limit {
+		query.Limit = limit
+	}
+
+	return query
+}
+
+// IMPORTANT: It must be called only when a write lock is already held, so that
+// no message can be broadcast between the history query and the subscription.
+func (r *InMemoryRegistry) replayLocked(
+	connection *Connection,
+	channelId string,
+	query HistoryQuery,
+	prefetched []Message,
+) error {
+	messages, err := r.queryHistoryLocked(connection, channelId, query, prefetched)
+	if err != nil {
+		return err
+	}
+
+	return r.deliverLocked(connection, messages)
+}
+
+// IMPORTANT: It must be called only when a write lock is already held.
+func (r *InMemoryRegistry) deliverLocked(connection *Connection, messages []Message) error {
+	if len(messages) > cap(connection.Send)-len(connection.Send) {
+		return ierr.New(ierr.ErrorCodeFailedPrecondition, ErrReplayTooLarge)
+	}
+
+	for _, message := range messages {
+		message.Seq = connection.NextSeq()
+		connection.Send <- message
+	}
+
+	return nil
+}
+
+func (r *InMemoryRegistry) History(channelId string, query HistoryQuery) ([]Message, error) {
+	return r.store.Query(channelId, query)
+}
+
+// Session returns the session of a live connection or of a connection that
+// disconnected recently enough to be resumed.
+func (r *InMemoryRegistry) Session(sessionId string) (Session, bool) {
+	r.mu.Lock()
+	defer r.unlock()
+
+	if connection, ok := r.connections[sessionId]; ok {
+		return r.sessionLocked(connection), true
+	}
+
+	return r.detachedSessions.get(sessionId)
+}
+
+// Resume subscribes the connection to every channel in subscriptions, with
+// the same history replay semantics as Subscribe, and discards the session. A
+// live connection of the same user owning the session is disconnected, unless
+// the resume fails. Either all the channels are resumed or none is.
+func (r *InMemoryRegistry) Resume(sessionId string, connectionId string, subscriptions map[string]SubscribeOptions) error {
+	prefetched := make(map[string][]Message, len(subscriptions))
+	for channelId, options := range subscriptions {
+		if options.History != nil {
+			prefetched[channelId] = r.prefetchHistory(connectionId, channelId, *options.History)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.unlock()
+
+	connection, ok := r.connections[connectionId]
+	if !ok {
+		return errors.New("connection not connected")
+	}
+
+	if sessionId == connectionId {
+		return errors.New("connection cannot resume its own session")
+	}
+
+	sessionConnection, sessionConnected := r.connections[sessionId]
+	if sessionConnected && sessionConnection.GetUserId() != connection.GetUserId() {
+		return ErrSessionNotFound
+	}
+
+	var unavailableChannels []string
+	replays := make(map[string][]Message, len(subscriptions))
+	replayCount := 0
+
+	for channelId, options := range subscriptions {
+		if _, ok := r.channelsByConnection[connectionId][channelId]; ok {
+			continue
+		}
+
+		if options.History == nil {
+			continue
+		}
+
+		messages, err := r.queryHistoryLocked(connection, channelId, *options.History, prefetched[channelId])
+		if errors.Is(err, ErrHistoryUnavailable) {
+			unavailableChannels = append(unavailableChannels, channelId)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		replays[channelId] = messages
+		replayCount += len(messages)
+	}
+
+	if len(unavailableChannels) > 0 {
+		slices.Sort(unavailableChannels)
+
+		return &ResumeNotPossibleError{Channels: unavailableChannels}
+	}
+
+	// Missed messages that do not fit in the send channel cannot be replayed,
+	// so the client has to subscribe again as if they were no longer stored.
+	if replayCount > cap(connection.Send)-len(connection.Send) {
+		channels := make([]string, 0, len(replays))
+		for channelId, messages := range replays {
+			if len(messages) > 0 {
+				channels = append(channels, channelId)
+			}
+		}
+
+		slices.Sort(channels)
+
+		return &ResumeNotPossibleError{Channels: channels}
+	}
+
+	// The live connection is only disconnected once the resume is known to
+	// succeed, so that a failed resume leaves it untouched.
+	if sessionConnected {
+		r.disconnectLocked(sessionId)
+	}
+
+	r.detachedSessions.remove(sessionId)
+
+	for channelId := range subscriptions {
+		if _, ok := r.channelsByConnection[connectionId][channelId]; ok {
+			continue
+		}
+
+		err := r.deliverLocked(connection, replays[channelId])
+		if err != nil {
+			return err
+		}
+
+		r.subscribeLocked(channelId, connectionId, subscriptions[channelId].Metadata)
+	}
+
 	return nil
 }
 
+// IMPORTANT: It must be called only when a lock is already held.
+func (r *InMemoryRegistry) sessionLocked(connection *Connection) Session {
+	channels := make([]string, 0, len(r.channelsByConnection[connection.Id]))
+	for channelId := range r.channelsByConnection[connection.Id] {
+		channels = append(channels, channelId)
+	}
+
+	slices.Sort(channels)
+
+	return Session{
+		Id:       connection.Id,
+		UserId:   connection.GetUserId(),
+		Channels: channels,
+	}
+}
+
 func (r *InMemoryRegistry) Unsubscribe(channelId string, connectionId string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.unlock()
 
 	connectionChannels, ok := r.channelsByConnection[connectionId]
 	if !ok {
 		return
 	}
 
-	delete(connectionChannels, channelId)
-	if len(connectionChannels) == 0 {
-		delete(r.channelsByConnection, connectionId)
+	if _, ok := connectionChannels[channelId]; !ok {
+		return
 	}
 
+	delete(connectionChannels, channelId)
+
 	channelConnections, ok := r.connectionsByChannel[channelId]
 	if !ok {
 		panic("inconsistent state: channel not found in connectionsByChannel")
@@ -143,16 +591,55 @@ func (r *InMemoryRegistry) Unsubscribe(channelId string, connectionId string) {
 	delete(channelConnections, connectionId)
 	if len(channelConnections) == 0 {
 		delete(r.connectionsByChannel, channelId)
+		r.changedChannels = append(r.changedChannels, channelId)
 	}
+
+	r.leaveLocked(channelId, connectionId)
 }
 
 func (r *InMemoryRegistry) Disconnect(connectionId string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.unlock()
 
 	r.disconnectLocked(connectionId)
 }
 
+func (r *InMemoryRegistry) DisconnectMatching(match func(connection *Connection) bool, reason CloseReason) int {
+	r.mu.Lock()
+	defer r.unlock()
+
+	var connectionIds []string
+	for connectionId, connection := range r.connections {
+		if match(connection) {
+			connectionIds = append(connectionIds, connectionId)
+		}
+	}
+
+	for _, connectionId := range connectionIds {
+		r.connections[connectionId].SetCloseReason(reason)
+		r.disconnectLocked(connectionId)
+	}
+
+	return len(connectionIds)
+}
+
+// unlock releases the write lock and notifies the channel listener of the
+// channels whose subscribers changed while it was held.
+func (r *InMemoryRegistry) unlock() {
+	changedChannels := r.changedChannels
+	r.changedChannels = nil
+
+	r.mu.Unlock()
+
+	if r.channelListener == nil {
+		return
+	}
+
+	for _, channelId := range changedChannels {
+		r.channelListener(channelId)
+	}
+}
+
 // IMPORTANT: It must be called only when a write lock is already held.
 func (r *InMemoryRegistry) disconnectLocked(connectionId string) {
 	connection, ok := r.connections[connectionId]
@@ -165,6 +652,10 @@ func (r *InMemoryRegistry) disconnectLocked(connectionId string) {
 		panic("inconsistent state: connection not found in channelsByConnection")
 	}
 
+	if connection.GetUserId() != "" {
+		r.detachedSessions.push(r.sessionLocked(connection))
+	}
+
 	for channelId := range connectionChannels {
 		channelConnections, ok := r.connectionsByChannel[channelId]
 		if !ok {
@@ -174,10 +665,24 @@ func (r *InMemoryRegistry) disconnectLocked(connectionId string) {
 		delete(channelConnections, connectionId)
 		if len(channelConnections) == 0 {
 			delete(r.connectionsByChannel, channelId)
+			r.changedChannels = append(r.changedChannels, channelId)
 		}
 	}
 
 	delete(r.channelsByConnection, connectionId)
 	delete(r.connections, connectionId)
 	close(connection.Send)
+
+	// The connection is gone from every channel before the presence events
+	// are sent, so it is never notified of its own leave.
+	for channelId := range connectionChannels {
+		r.leaveLocked(channelId, connectionId)
+	}
+}
+
+func channelHash(channelId string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(channelId))
+
+	return hash.Sum32()
 }
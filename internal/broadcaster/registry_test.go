@@ -0,0 +1,85 @@
+package broadcaster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInMemoryRegistry(t *testing.T) {
+	logger := zap.NewNop()
+
+	t.Run("replays that do not fit fail", func(t *testing.T) {
+		registry := NewInMemoryRegistry(logger, NewInMemoryMessageStore(10, 0), time.Minute)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			_, err := registry.Broadcast(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, registry.Connect(&Connection{Id: "conn-1", Send: make(chan Message, 2)}))
+
+		err := registry.Subscribe("test-channel", "conn-1", SubscribeOptions{History: &HistoryQuery{}})
+		var handlerErr ierr.Error
+		if assert.ErrorAs(t, err, &handlerErr) {
+			assert.Equal(t, ierr.ErrorCodeFailedPrecondition, handlerErr.Code)
+		}
+		assert.ErrorIs(t, err, ErrReplayTooLarge)
+
+		err = registry.Subscribe("test-channel", "conn-1", SubscribeOptions{History: &HistoryQuery{Limit: 2}})
+		assert.NoError(t, err)
+		assert.Len(t, registry.connections["conn-1"].Send, 2)
+	})
+
+	t.Run("resumes that do not fit are not possible", func(t *testing.T) {
+		registry := NewInMemoryRegistry(logger, NewInMemoryMessageStore(10, 0), time.Minute)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			_, err := registry.Broadcast(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, registry.Connect(&Connection{Id: "conn-1", Send: make(chan Message, 2)}))
+
+		offset := uint64(0)
+		err := registry.Resume("session-1", "conn-1", map[string]SubscribeOptions{
+			"test-channel": {History: &HistoryQuery{AfterOffset: &offset}},
+		})
+		var resumeErr *ResumeNotPossibleError
+		if assert.ErrorAs(t, err, &resumeErr) {
+			assert.Equal(t, []string{"test-channel"}, resumeErr.Channels)
+		}
+
+		offset = 1
+		err = registry.Resume("session-1", "conn-1", map[string]SubscribeOptions{
+			"test-channel": {History: &HistoryQuery{AfterOffset: &offset}},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, registry.connections["conn-1"].Send, 2)
+	})
+
+	t.Run("presence is rejected in a cluster", func(t *testing.T) {
+		bus := NewMockBus(t)
+		bus.EXPECT().Start(mock.Anything).Return(nil)
+		bus.EXPECT().Subscribe(mock.Anything).Return(nil)
+
+		registry, err := NewClusterRegistry(logger, NewInMemoryRegistry(logger, NewInMemoryMessageStore(10, 0), time.Minute), bus)
+		require.NoError(t, err)
+		require.NoError(t, registry.Connect(&Connection{Id: "conn-1", Send: make(chan Message, 2)}))
+
+		err = registry.Subscribe("presence:room", "conn-1", SubscribeOptions{})
+		assert.ErrorIs(t, err, ErrPresenceUnavailable)
+
+		err = registry.Resume("session-1", "conn-1", map[string]SubscribeOptions{"presence:room": {}})
+		assert.ErrorIs(t, err, ErrPresenceUnavailable)
+
+		err = registry.Subscribe("room", "conn-1", SubscribeOptions{})
+		assert.NoError(t, err)
+		assert.False(t, registry.TracksPresence())
+	})
+}
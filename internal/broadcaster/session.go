@@ -0,0 +1,84 @@
+package broadcaster
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is what remains of a connection after it disconnected: the user it
+// belonged to and the channels it was subscribed to. It can be resumed by a new
+// connection of the same user until it expires.
+type Session struct {
+	Id         string
+	UserId     string
+	Channels   []string
+	ExpireTime time.Time
+}
+
+type ResumeNotPossibleError struct {
+	Channels []string
+}
+
+func (e *ResumeNotPossibleError) Error() string {
+	return "missed messages are no longer available for channels: " + strings.Join(e.Channels, ", ")
+}
+
+// sessionQueue keeps detached sessions in expiration order. All sessions share
+// the same time to live, so expired sessions are always at the head.
+type sessionQueue struct {
+	ttl      time.Duration
+	sessions map[string]Session
+	order    []string
+}
+
+func newSessionQueue(ttl time.Duration) *sessionQueue {
+	return &sessionQueue{
+		ttl:      ttl,
+		sessions: make(map[string]Session),
+	}
+}
+
+func (q *sessionQueue) push(session Session) {
+	if q.ttl <= 0 {
+		return
+	}
+
+	q.prune()
+
+	session.ExpireTime = time.Now().Add(q.ttl)
+	q.sessions[session.Id] = session
+	q.order = append(q.order, session.Id)
+}
+
+func (q *sessionQueue) get(sessionId string) (Session, bool) {
+	q.prune()
+
+	session, ok := q.sessions[sessionId]
+
+	return session, ok
+}
+
+func (q *sessionQueue) remove(sessionId string) {
+	delete(q.sessions, sessionId)
+}
+
+func (q *sessionQueue) prune() {
+	now := time.Now()
+
+	for len(q.order) > 0 {
+		session, ok := q.sessions[q.order[0]]
+		if ok && session.ExpireTime.After(now) {
+			return
+		}
+
+		if ok {
+			delete(q.sessions, session.Id)
+		}
+
+		q.order[0] = ""
+		q.order = q.order[1:]
+	}
+}
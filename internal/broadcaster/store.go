@@ -0,0 +1,260 @@
+package broadcaster
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrHistoryUnavailable is returned when a query starts after an offset whose
+// following messages are no longer stored, meaning that some may be lost.
+var ErrHistoryUnavailable = errors.New("history is no longer available from the requested offset")
+
+type HistoryQuery struct {
+	Limit int       `json:"limit,omitempty"`
+	Since time.Time `json:"since"`
+	// AfterOffset, when set, only returns the messages with a greater offset.
+	// The query fails with ErrHistoryUnavailable if any of them was dropped.
+	AfterOffset *uint64 `json:"afterOffset,omitempty"`
+}
+
+type MessageStore interface {
+	// Append assigns the next offset of the channel to the message and stores
+	// it. Offsets start at 1 and increase by one for every message.
+	Append(message Message) (Message, error)
+	// Replicate stores a message published by another node of a cluster with
+	// the offset it was given there. When messages were missed before it, or
+	// when its offset was already taken by a message published concurrently on
+	// this node, the history before it is dropped instead of being returned
+	// with gaps. A message with a taken offset is not stored.
+	Replicate(message Message) (Message, error)
+	Query(channelId string, query HistoryQuery) ([]Message, error)
+}
+
+// defaultChannelIdleTimeout is how long the offsets of a channel without
+// stored messages are kept when messages do not expire.
+const defaultChannelIdleTimeout = time.Hour
+
+// InMemoryMessageStore keeps the last size messages of every channel in a
+// ring buffer. Messages older than ttl are dropped lazily; a ttl of zero keeps
+// messages until they are evicted by newer ones.
+//
+// A channel that holds no message and was not appended to for ttl, or for
+// defaultChannelIdleTimeout when ttl is zero, is forgotten along with its
+// offsets, which then start again at 1.
+type InMemoryMessageStore struct {
+	size int
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	channels  map[string]*messageRing
+	lastEvict time.Time
+}
+
+func NewInMemoryMessageStore(size int, ttl time.Duration) *InMemoryMessageStore {
+	return &InMemoryMessageStore{
+		size:      size,
+		ttl:       ttl,
+		channels:  make(map[string]*messageRing),
+		lastEvict: time.Now(),
+	}
+}
+
+func (s *InMemoryMessageStore) Append(message Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring := s.ringLocked(message.Channel)
+
+	ring.lastOffset++
+	message.Offset = ring.lastOffset
+
+	if s.size > 0 {
+		ring.push(message, s.size)
+	}
+
+	return message, nil
+}
+
+func (s *InMemoryMessageStore) Replicate(message Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring := s.ringLocked(message.Channel)
+
+	if message.Offset != ring.lastOffset+1 {
+		ring.clear()
+	}
+
+	if message.Offset <= ring.lastOffset {
+		return message, nil
+	}
+
+	ring.lastOffset = message.Offset
+
+	if s.size > 0 {
+		ring.push(message, s.size)
+	}
+
+	return message, nil
+}
+
+func (s *InMemoryMessageStore) Query(channelId string, query HistoryQuery) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictIdleLocked(time.Now())
+
+	ring, ok := s.channels[channelId]
+	if !ok {
+		if query.AfterOffset != nil && *query.AfterOffset > 0 {
+			return nil, ErrHistoryUnavailable
+		}
+
+		return nil, nil
+	}
+
+	if s.ttl > 0 {
+		ring.expire(time.Now().Add(-s.ttl))
+	}
+
+	if query.AfterOffset != nil {
+		afterOffset := *query.AfterOffset
+
+		if afterOffset > ring.lastOffset || afterOffset+1 < ring.firstOffset() {
+			return nil, ErrHistoryUnavailable
+		}
+	}
+
+	messages := make([]Message, 0, ring.count)
+	ring.each(func(message Message) {
+		if query.AfterOffset != nil && message.Offset <= *query.AfterOffset {
+			return
+		}
+
+		if !query.Since.IsZero() && !message.CreateTime.After(query.Since) {
+			return
+		}
+
+		messages = append(messages, message)
+	})
+
+	if query.Limit > 0 && len(messages) > query.Limit {
+		messages = messages[len(messages)-query.Limit:]
+	}
+
+	return messages, nil
+}
+
+// ringLocked returns the ring of the channel, created if needed, and marks it
+// as appended to.
+//
+// IMPORTANT: It must be called only when the lock is already held.
+func (s *InMemoryMessageStore) ringLocked(channelId string) *messageRing {
+	now := time.Now()
+	s.evictIdleLocked(now)
+
+	ring, ok := s.channels[channelId]
+	if !ok {
+		ring = &messageRing{}
+		s.channels[channelId] = ring
+	}
+
+	ring.lastAppendTime = now
+
+	return ring
+}
+
+// evictIdleLocked forgets the idle channels without messages. Every channel
+// is checked, so it runs at most once per idle timeout, and per minute.
+//
+// IMPORTANT: It must be called only when the lock is already held.
+func (s *InMemoryMessageStore) evictIdleLocked(now time.Time) {
+	idleTimeout := s.ttl
+	if idleTimeout <= 0 {
+		idleTimeout = defaultChannelIdleTimeout
+	}
+
+	if now.Sub(s.lastEvict) < min(idleTimeout, time.Minute) {
+		return
+	}
+
+	s.lastEvict = now
+
+	for channelId, ring := range s.channels {
+		if now.Sub(ring.lastAppendTime) < idleTimeout {
+			continue
+		}
+
+		if s.ttl > 0 {
+			ring.expire(now.Add(-s.ttl))
+		}
+
+		if ring.count == 0 {
+			delete(s.channels, channelId)
+		}
+	}
+}
+
+type messageRing struct {
+	messages       []Message
+	start          int
+	count          int
+	lastOffset     uint64
+	lastAppendTime time.Time
+}
+
+func (r *messageRing) push(message Message, size int) {
+	if r.messages == nil {
+		r.messages = make([]Message, size)
+	}
+
+	end := (r.start + r.count) % len(r.messages)
+	r.messages[end] = message
+
+	if r.count < len(r.messages) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.messages)
+	}
+}
+
+// firstOffset returns the offset of the oldest stored message, or the offset
+// the next message will get when the ring is empty.
+func (r *messageRing) firstOffset() uint64 {
+	if r.count == 0 {
+		return r.lastOffset + 1
+	}
+
+	return r.messages[r.start].Offset
+}
+
+// expire drops messages created before the given time from the head of the
+// ring. Messages are appended in publish order so the scan stops at the first
+// message that is still fresh.
+func (r *messageRing) expire(before time.Time) {
+	for r.count > 0 && r.messages[r.start].CreateTime.Before(before) {
+		r.messages[r.start] = Message{}
+		r.start = (r.start + 1) % len(r.messages)
+		r.count--
+	}
+
+	if r.count == 0 {
+		r.messages = nil
+		r.start = 0
+	}
+}
+
+// clear drops every message, so that the history starts after the last
+// offset.
+func (r *messageRing) clear() {
+	r.messages = nil
+	r.start = 0
+	r.count = 0
+}
+
+func (r *messageRing) each(fn func(message Message)) {
+	for i := 0; i < r.count; i++ {
+		fn(r.messages[(r.start+i)%len(r.messages)])
+	}
+}
@@ -0,0 +1,199 @@
+package broadcaster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryMessageStore(t *testing.T) {
+	t.Run("keeps the last messages", func(t *testing.T) {
+		store := NewInMemoryMessageStore(2, 0)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			assert.NoError(t, err)
+		}
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "msg-2", messages[0].Id)
+		assert.Equal(t, "msg-3", messages[1].Id)
+	})
+
+	t.Run("applies limit and since", func(t *testing.T) {
+		store := NewInMemoryMessageStore(10, 0)
+		now := time.Now()
+
+		for i, id := range []string{"msg-1", "msg-2", "msg-3", "msg-4"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: now.Add(time.Duration(i) * time.Second)})
+			assert.NoError(t, err)
+		}
+
+		messages, err := store.Query("test-channel", HistoryQuery{Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, "msg-4", messages[0].Id)
+
+		messages, err = store.Query("test-channel", HistoryQuery{Since: now.Add(time.Second)})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "msg-3", messages[0].Id)
+	})
+
+	t.Run("drops expired messages", func(t *testing.T) {
+		store := NewInMemoryMessageStore(10, time.Minute)
+
+		_, err := store.Append(Message{Id: "msg-1", Channel: "test-channel", CreateTime: time.Now().Add(-time.Hour)})
+		assert.NoError(t, err)
+		_, err = store.Append(Message{Id: "msg-2", Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, "msg-2", messages[0].Id)
+	})
+
+	t.Run("disabled when size is zero", func(t *testing.T) {
+		store := NewInMemoryMessageStore(0, 0)
+
+		_, err := store.Append(Message{Id: "msg-1", Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("assigns channel offsets", func(t *testing.T) {
+		store := NewInMemoryMessageStore(0, 0)
+
+		message, err := store.Append(Message{Channel: "test-channel"})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), message.Offset)
+
+		message, err = store.Append(Message{Channel: "test-channel"})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), message.Offset)
+
+		message, err = store.Append(Message{Channel: "another-channel"})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), message.Offset)
+	})
+
+	t.Run("queries after offset", func(t *testing.T) {
+		store := NewInMemoryMessageStore(2, 0)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3", "msg-4"} {
+			_, err := store.Append(Message{Id: id, Channel: "test-channel", CreateTime: time.Now()})
+			assert.NoError(t, err)
+		}
+
+		offset := uint64(2)
+		messages, err := store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "msg-3", messages[0].Id)
+		assert.Equal(t, uint64(3), messages[0].Offset)
+
+		offset = 4
+		messages, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+
+		offset = 1
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		offset = 5
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &offset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+	})
+
+	t.Run("replicates offsets", func(t *testing.T) {
+		store := NewInMemoryMessageStore(10, 0)
+
+		for _, offset := range []uint64{1, 2} {
+			message, err := store.Replicate(Message{Channel: "test-channel", Offset: offset, CreateTime: time.Now()})
+			assert.NoError(t, err)
+			assert.Equal(t, offset, message.Offset)
+		}
+
+		message, err := store.Append(Message{Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), message.Offset)
+
+		afterOffset := uint64(0)
+		messages, err := store.Query("test-channel", HistoryQuery{AfterOffset: &afterOffset})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+
+		// The offset was taken concurrently, so the history is dropped.
+		_, err = store.Replicate(Message{Channel: "test-channel", Offset: 3, CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &afterOffset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		// Messages were missed, so the history starts after the gap.
+		_, err = store.Replicate(Message{Channel: "test-channel", Offset: 6, CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		afterOffset = 4
+		_, err = store.Query("test-channel", HistoryQuery{AfterOffset: &afterOffset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		afterOffset = 5
+		messages, err = store.Query("test-channel", HistoryQuery{AfterOffset: &afterOffset})
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 1) {
+			assert.Equal(t, uint64(6), messages[0].Offset)
+		}
+	})
+
+	t.Run("forgets idle channels", func(t *testing.T) {
+		store := NewInMemoryMessageStore(0, 10*time.Millisecond)
+
+		_, err := store.Append(Message{Channel: "idle-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		time.Sleep(20 * time.Millisecond)
+
+		message, err := store.Append(Message{Channel: "active-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), message.Offset)
+
+		store.mu.Lock()
+		assert.NotContains(t, store.channels, "idle-channel")
+		assert.Contains(t, store.channels, "active-channel")
+		store.mu.Unlock()
+
+		// Positions of the forgotten channel cannot be replayed anymore.
+		afterOffset := uint64(1)
+		_, err = store.Query("idle-channel", HistoryQuery{AfterOffset: &afterOffset})
+		assert.ErrorIs(t, err, ErrHistoryUnavailable)
+
+		message, err = store.Append(Message{Channel: "idle-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), message.Offset)
+	})
+
+	t.Run("keeps idle channels with messages", func(t *testing.T) {
+		store := NewInMemoryMessageStore(10, 0)
+
+		_, err := store.Append(Message{Channel: "test-channel", CreateTime: time.Now()})
+		assert.NoError(t, err)
+
+		store.mu.Lock()
+		store.channels["test-channel"].lastAppendTime = time.Now().Add(-2 * defaultChannelIdleTimeout)
+		store.lastEvict = time.Now().Add(-2 * defaultChannelIdleTimeout)
+		store.mu.Unlock()
+
+		messages, err := store.Query("test-channel", HistoryQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+	})
+}
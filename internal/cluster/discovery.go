@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PeerDiscovery lists the addresses of the nodes of a peer-to-peer cluster.
+// The list may include the address of the node itself.
+type PeerDiscovery interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// StaticPeers is a fixed list of `host:port` peer addresses.
+type StaticPeers []string
+
+func (p StaticPeers) Peers(ctx context.Context) ([]string, error) {
+	return p, nil
+}
+
+// SRVPeers resolves the peer addresses from the DNS SRV records of a name, such
+// as the ones of a Kubernetes headless service.
+type SRVPeers struct {
+	name     string
+	resolver *net.Resolver
+}
+
+func NewSRVPeers(name string) *SRVPeers {
+	return &SRVPeers{
+		name:     name,
+		resolver: net.DefaultResolver,
+	}
+}
+
+func (p *SRVPeers) Peers(ctx context.Context) ([]string, error) {
+	_, records, err := p.resolver.LookupSRV(ctx, "", "", p.name)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,28 @@
+package cluster
+
+import (
+	"encoding/json"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+)
+
+// envelope wraps a message on the wire with the id of the node that published
+// it, so that nodes can ignore their own messages.
+type envelope struct {
+	NodeId  string              `json:"nodeId"`
+	Message broadcaster.Message `json:"message"`
+}
+
+func encodeEnvelope(nodeId string, message broadcaster.Message) ([]byte, error) {
+	return json.Marshal(envelope{
+		NodeId:  nodeId,
+		Message: message,
+	})
+}
+
+func decodeEnvelope(data []byte) (envelope, error) {
+	var e envelope
+	err := json.Unmarshal(data, &e)
+
+	return e, err
+}
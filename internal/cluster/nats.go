@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/pattern"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// natsDispatchBufferSize bounds the messages waiting for the dispatch
+// goroutine. nats.go drops messages and reports a slow consumer once it is full.
+const natsDispatchBufferSize = 64 * 1024
+
+// NATSBus forwards messages through NATS core subjects. Channel segments become
+// subject tokens, so `room:42` is published on `<prefix>.room.42`, and a node
+// only subscribes to the subjects of the channels it has local subscribers
+// for. Pattern wildcards map to the NATS ones: `*` to `*` and `**` to `>`.
+//
+// Every subscription feeds the same Go channel, which a single goroutine
+// drains, so a message that matches both an exact subscription and a pattern
+// is still handled in publish order.
+type NATSBus struct {
+	logger *zap.Logger
+	nodeId string
+	prefix string
+	conn   *nats.Conn
+
+	messages chan *nats.Msg
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu            sync.Mutex
+	started       bool
+	subscriptions map[string]*nats.Subscription
+}
+
+func NewNATSBus(
+	logger *zap.Logger,
+	nodeId string,
+	prefix string,
+	conn *nats.Conn,
+) *NATSBus {
+	return &NATSBus{
+		logger:        logger,
+		nodeId:        nodeId,
+		prefix:        prefix,
+		conn:          conn,
+		messages:      make(chan *nats.Msg, natsDispatchBufferSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]*nats.Subscription),
+	}
+}
+
+func (b *NATSBus) Start(handler func(message broadcaster.Message)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return nil
+	}
+
+	b.started = true
+
+	go b.dispatch(handler)
+
+	return nil
+}
+
+func (b *NATSBus) Publish(message broadcaster.Message) error {
+	data, err := encodeEnvelope(b.nodeId, message)
+	if err != nil {
+		return err
+	}
+
+	return b.conn.Publish(b.subject(message.Channel), data)
+}
+
+// Subscribe registers interest in the subject of the channel. nats.go restores
+// the subscriptions on its own after reconnecting.
+func (b *NATSBus) Subscribe(channelId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscriptions[channelId]; ok {
+		return nil
+	}
+
+	subscription, err := b.conn.ChanSubscribe(b.subject(channelId), b.messages)
+	if err != nil {
+		return err
+	}
+
+	b.subscriptions[channelId] = subscription
+
+	return nil
+}
+
+func (b *NATSBus) Unsubscribe(channelId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscription, ok := b.subscriptions[channelId]
+	if !ok {
+		return nil
+	}
+
+	delete(b.subscriptions, channelId)
+
+	return subscription.Unsubscribe()
+}
+
+// Close drops the subscriptions and closes the NATS connection, which the bus
+// owns.
+func (b *NATSBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for channelId, subscription := range b.subscriptions {
+		err := subscription.Unsubscribe()
+		if err != nil {
+			b.logger.Warn("failed to unsubscribe from nats subject",
+				zap.String("channelId", channelId),
+				zap.Error(err))
+		}
+	}
+
+	b.subscriptions = make(map[string]*nats.Subscription)
+
+	err := b.conn.Flush()
+	b.conn.Close()
+
+	if b.started {
+		close(b.stop)
+		<-b.done
+		b.started = false
+	}
+
+	return err
+}
+
+func (b *NATSBus) subject(channelId string) string {
+	tokens := strings.Split(channelId, pattern.Separator)
+	for i, token := range tokens {
+		if token == pattern.MultiWildcard {
+			tokens[i] = ">"
+		}
+	}
+
+	subject := strings.Join(tokens, ".")
+	if b.prefix == "" {
+		return subject
+	}
+
+	return b.prefix + "." + subject
+}
+
+func (b *NATSBus) dispatch(handler func(message broadcaster.Message)) {
+	defer close(b.done)
+
+	for {
+		select {
+		case natsMessage := <-b.messages:
+			b.receive(natsMessage, handler)
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *NATSBus) receive(natsMessage *nats.Msg, handler func(message broadcaster.Message)) {
+	envelope, err := decodeEnvelope(natsMessage.Data)
+	if err != nil {
+		b.logger.Warn("failed to decode message from nats",
+			zap.String("subject", natsMessage.Subject),
+			zap.Error(err))
+
+		return
+	}
+
+	if envelope.NodeId == b.nodeId {
+		return
+	}
+
+	handler(envelope.Message)
+}
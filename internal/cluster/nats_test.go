@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNATSBus(t *testing.T) {
+	logger := zap.NewNop()
+
+	natsServer, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	require.True(t, natsServer.ReadyForConnections(5*time.Second))
+
+	interest := func() int {
+		return natsServer.GlobalAccount().Interest("broadcaster.room.42")
+	}
+
+	newNode := func(nodeId string) *broadcaster.ClusterRegistry {
+		conn, err := nats.Connect(natsServer.ClientURL())
+		require.NoError(t, err)
+
+		bus := NewNATSBus(logger, nodeId, "broadcaster", conn)
+		local := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(10, 0), 0)
+
+		registry, err := broadcaster.NewClusterRegistry(logger, local, bus)
+		require.NoError(t, err)
+		t.Cleanup(func() { registry.Close() })
+
+		return registry
+	}
+
+	nodeA := newNode("node-a")
+	nodeB := newNode("node-b")
+
+	connectionA := &broadcaster.Connection{Id: "connection-a", Send: make(chan broadcaster.Message, 10)}
+	require.NoError(t, nodeA.Connect(connectionA))
+	require.NoError(t, nodeA.Subscribe("room:42", connectionA.Id, broadcaster.SubscribeOptions{}))
+
+	connectionB := &broadcaster.Connection{Id: "connection-b", Send: make(chan broadcaster.Message, 10)}
+	require.NoError(t, nodeB.Connect(connectionB))
+	require.NoError(t, nodeB.Subscribe("room:42", connectionB.Id, broadcaster.SubscribeOptions{}))
+
+	assert.Eventually(t, func() bool { return interest() == 2 }, time.Second, 10*time.Millisecond)
+
+	t.Run("delivers to every node once", func(t *testing.T) {
+		_, err := nodeA.Broadcast(broadcaster.Message{Id: "msg-1", Channel: "room:42", CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		select {
+		case message := <-connectionB.Send:
+			assert.Equal(t, "msg-1", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the other node")
+		}
+
+		select {
+		case message := <-connectionA.Send:
+			assert.Equal(t, "msg-1", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the local node")
+		}
+
+		select {
+		case message := <-connectionA.Send:
+			t.Fatalf("message %s delivered twice to the local node", message.Id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("follows local subscribers", func(t *testing.T) {
+		nodeB.Unsubscribe("room:42", connectionB.Id)
+
+		assert.Eventually(t, func() bool { return interest() == 1 }, time.Second, 10*time.Millisecond)
+
+		nodeA.Disconnect(connectionA.Id)
+
+		assert.Eventually(t, func() bool { return interest() == 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("delivers pattern subscriptions once", func(t *testing.T) {
+		connection := &broadcaster.Connection{Id: "connection-pattern", Send: make(chan broadcaster.Message, 10)}
+		require.NoError(t, nodeB.Connect(connection))
+		require.NoError(t, nodeB.Subscribe("org:42:**", connection.Id, broadcaster.SubscribeOptions{}))
+		require.NoError(t, nodeB.Subscribe("org:42:room", connection.Id, broadcaster.SubscribeOptions{}))
+
+		assert.Eventually(t, func() bool {
+			return natsServer.GlobalAccount().Interest("broadcaster.org.42.room") == 1 && natsServer.GlobalAccount().SubscriptionInterest("broadcaster.org.42.room.7")
+		}, time.Second, 10*time.Millisecond)
+
+		_, err := nodeA.Broadcast(broadcaster.Message{Id: "msg-2", Channel: "org:42:room", CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		select {
+		case message := <-connection.Send:
+			assert.Equal(t, "msg-2", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the pattern subscriber")
+		}
+
+		select {
+		case message := <-connection.Send:
+			t.Fatalf("message %s delivered twice", message.Id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("keeps publish order across exact and pattern subscriptions", func(t *testing.T) {
+		connection := &broadcaster.Connection{Id: "connection-order", Send: make(chan broadcaster.Message, 100)}
+		require.NoError(t, nodeB.Connect(connection))
+		require.NoError(t, nodeB.Subscribe("org:43:**", connection.Id, broadcaster.SubscribeOptions{}))
+		require.NoError(t, nodeB.Subscribe("org:43:room", connection.Id, broadcaster.SubscribeOptions{}))
+
+		assert.Eventually(t, func() bool {
+			return natsServer.GlobalAccount().Interest("broadcaster.org.43.room") == 1 && natsServer.GlobalAccount().SubscriptionInterest("broadcaster.org.43.room.7")
+		}, time.Second, 10*time.Millisecond)
+
+		for i := range 50 {
+			_, err := nodeA.Broadcast(broadcaster.Message{Id: fmt.Sprintf("msg-order-%d", i), Channel: "org:43:room", CreateTime: time.Now()})
+			require.NoError(t, err)
+		}
+
+		for i := range 50 {
+			select {
+			case message := <-connection.Send:
+				require.Equal(t, fmt.Sprintf("msg-order-%d", i), message.Id)
+			case <-time.After(time.Second):
+				t.Fatalf("message %d not delivered", i)
+			}
+		}
+	})
+}
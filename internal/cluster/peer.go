@@ -0,0 +1,696 @@
+package cluster
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/pattern"
+	"go.uber.org/zap"
+)
+
+const (
+	peerHandshakeTimeout = 10 * time.Second
+	peerWriteTimeout     = 10 * time.Second
+	peerQueueSize        = 1024
+	peerMinRetryInterval = time.Second
+	peerMaxRetryInterval = 30 * time.Second
+)
+
+const (
+	peerFrameChallenge   = "challenge"
+	peerFrameHello       = "hello"
+	peerFrameWelcome     = "welcome"
+	peerFrameSubscribe   = "subscribe"
+	peerFrameUnsubscribe = "unsubscribe"
+	peerFrameMessage     = "message"
+)
+
+var (
+	errSelfPeer      = errors.New("peer is this node")
+	errDuplicatePeer = errors.New("already connected to peer")
+)
+
+// peerFrame is the unit exchanged between peers, encoded as one JSON value per
+// frame.
+//
+// Every node dials every peer. The accepting side sends a challenge, the
+// dialing side answers with a hello signed with the shared secret and its own
+// challenge, and the accepting side proves the secret in turn with a welcome.
+// From then on, the accepting side reports the channels it has subscribers for
+// and the dialing side forwards the messages of those channels.
+type peerFrame struct {
+	Type     string               `json:"type"`
+	NodeId   string               `json:"nodeId,omitempty"`
+	Nonce    []byte               `json:"nonce,omitempty"`
+	MAC      []byte               `json:"mac,omitempty"`
+	Channels []string             `json:"channels,omitempty"`
+	Message  *broadcaster.Message `json:"message,omitempty"`
+}
+
+// PeerBus connects the nodes of a cluster directly to each other, without an
+// external broker. Messages are only forwarded to the peers that reported
+// subscribers for their channel.
+type PeerBus struct {
+	logger          *zap.Logger
+	nodeId          string
+	secret          []byte
+	listener        net.Listener
+	discovery       PeerDiscovery
+	refreshInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	handler  func(message broadcaster.Message)
+	channels map[string]struct{}
+	inbound  map[*peerConn]struct{}
+	outbound map[string]*outboundPeer
+}
+
+func NewPeerBus(
+	logger *zap.Logger,
+	nodeId string,
+	secret string,
+	listener net.Listener,
+	discovery PeerDiscovery,
+	refreshInterval time.Duration,
+) *PeerBus {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &PeerBus{
+		logger:          logger,
+		nodeId:          nodeId,
+		secret:          []byte(secret),
+		listener:        listener,
+		discovery:       discovery,
+		refreshInterval: refreshInterval,
+		ctx:             ctx,
+		cancel:          cancel,
+		channels:        make(map[string]struct{}),
+		inbound:         make(map[*peerConn]struct{}),
+		outbound:        make(map[string]*outboundPeer),
+	}
+}
+
+func (b *PeerBus) Start(handler func(message broadcaster.Message)) error {
+	b.mu.Lock()
+	b.handler = handler
+	b.mu.Unlock()
+
+	b.wg.Add(2)
+	go b.accept()
+	go b.discover()
+
+	return nil
+}
+
+func (b *PeerBus) Publish(message broadcaster.Message) error {
+	b.mu.Lock()
+	peers := make([]*outboundPeer, 0, len(b.outbound))
+	for _, peer := range b.outbound {
+		peers = append(peers, peer)
+	}
+	b.mu.Unlock()
+
+	frame := peerFrame{Type: peerFrameMessage, Message: &message}
+
+	for _, peer := range peers {
+		if !peer.forward(message.Channel, frame) {
+			b.logger.Warn("dropping message for slow cluster peer",
+				zap.String("address", peer.address),
+				zap.String("channelId", message.Channel))
+		}
+	}
+
+	return nil
+}
+
+func (b *PeerBus) Subscribe(channelId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.channels[channelId]; ok {
+		return nil
+	}
+
+	b.channels[channelId] = struct{}{}
+	b.notifyLocked(peerFrame{Type: peerFrameSubscribe, Channels: []string{channelId}})
+
+	return nil
+}
+
+func (b *PeerBus) Unsubscribe(channelId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.channels[channelId]; !ok {
+		return nil
+	}
+
+	delete(b.channels, channelId)
+	b.notifyLocked(peerFrame{Type: peerFrameUnsubscribe, Channels: []string{channelId}})
+
+	return nil
+}
+
+func (b *PeerBus) Close() error {
+	b.cancel()
+	err := b.listener.Close()
+
+	b.mu.Lock()
+	for conn := range b.inbound {
+		conn.close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+
+	return err
+}
+
+// notifyLocked sends an interest change to the peers that dialed this node. A
+// peer that cannot keep up is disconnected rather than left with a stale view,
+// it receives the full list of channels again when it reconnects.
+func (b *PeerBus) notifyLocked(frame peerFrame) {
+	for conn := range b.inbound {
+		if !conn.write(frame) {
+			conn.close()
+		}
+	}
+}
+
+func (b *PeerBus) sign(nonce []byte, nodeId string) []byte {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write(nonce)
+	mac.Write([]byte(nodeId))
+
+	return mac.Sum(nil)
+}
+
+func (b *PeerBus) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if b.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			b.logger.Warn("failed to accept cluster peer", zap.Error(err))
+
+			continue
+		}
+
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+// serve handles a connection dialed by a peer: it receives the messages the
+// peer forwards and sends it the interest of this node.
+func (b *PeerBus) serve(conn net.Conn) {
+	defer b.wg.Done()
+
+	logger := b.logger.With(zap.String("remoteAddress", conn.RemoteAddr().String()))
+
+	nodeId, decoder, err := b.acceptHandshake(conn)
+	if err != nil {
+		logger.Warn("rejected cluster peer", zap.Error(err))
+		conn.Close()
+
+		return
+	}
+
+	logger = logger.With(zap.String("peerNodeId", nodeId))
+	logger.Info("cluster peer connected")
+
+	peer := newPeerConn(conn)
+
+	b.mu.Lock()
+	if b.ctx.Err() != nil {
+		b.mu.Unlock()
+		conn.Close()
+
+		return
+	}
+
+	channels := make([]string, 0, len(b.channels))
+	for channelId := range b.channels {
+		channels = append(channels, channelId)
+	}
+
+	b.inbound[peer] = struct{}{}
+	peer.write(peerFrame{Type: peerFrameSubscribe, Channels: channels})
+	handler := b.handler
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		peer.writeLoop()
+	}()
+
+	for {
+		var frame peerFrame
+		err := decoder.Decode(&frame)
+		if err != nil {
+			break
+		}
+
+		if frame.Type == peerFrameMessage && frame.Message != nil {
+			handler(*frame.Message)
+		}
+	}
+
+	b.mu.Lock()
+	delete(b.inbound, peer)
+	b.mu.Unlock()
+
+	peer.close()
+
+	logger.Info("cluster peer disconnected")
+}
+
+func (b *PeerBus) acceptHandshake(conn net.Conn) (string, *json.Decoder, error) {
+	conn.SetDeadline(time.Now().Add(peerHandshakeTimeout))
+
+	nonce := make([]byte, 32)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	err = encoder.Encode(peerFrame{Type: peerFrameChallenge, NodeId: b.nodeId, Nonce: nonce})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var hello peerFrame
+	err = decoder.Decode(&hello)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if hello.Type != peerFrameHello || !hmac.Equal(hello.MAC, b.sign(nonce, hello.NodeId)) {
+		return "", nil, errors.New("invalid cluster secret")
+	}
+
+	if hello.NodeId == b.nodeId {
+		return "", nil, errSelfPeer
+	}
+
+	err = encoder.Encode(peerFrame{Type: peerFrameWelcome, MAC: b.sign(hello.Nonce, b.nodeId)})
+	if err != nil {
+		return "", nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return hello.NodeId, decoder, nil
+}
+
+// discover keeps one outbound peer per discovered address.
+func (b *PeerBus) discover() {
+	defer b.wg.Done()
+
+	var ticks <-chan time.Time
+	if b.refreshInterval > 0 {
+		ticker := time.NewTicker(b.refreshInterval)
+		defer ticker.Stop()
+
+		ticks = ticker.C
+	}
+
+	for {
+		b.refreshPeers()
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticks:
+		}
+	}
+}
+
+func (b *PeerBus) refreshPeers() {
+	ctx, cancel := context.WithTimeout(b.ctx, peerHandshakeTimeout)
+	defer cancel()
+
+	addresses, err := b.discovery.Peers(ctx)
+	if err != nil {
+		b.logger.Warn("failed to discover cluster peers", zap.Error(err))
+
+		return
+	}
+
+	wanted := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		wanted[address] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return
+	}
+
+	for address, peer := range b.outbound {
+		if _, ok := wanted[address]; !ok {
+			peer.cancel()
+			delete(b.outbound, address)
+		}
+	}
+
+	for address := range wanted {
+		if _, ok := b.outbound[address]; ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(b.ctx)
+		peer := &outboundPeer{address: address, cancel: cancel}
+		b.outbound[address] = peer
+
+		b.wg.Add(1)
+		go b.dial(ctx, peer)
+	}
+}
+
+// dial keeps a connection to a peer open until the peer is no longer
+// discovered, retrying with an increasing interval.
+func (b *PeerBus) dial(ctx context.Context, peer *outboundPeer) {
+	defer b.wg.Done()
+
+	logger := b.logger.With(zap.String("address", peer.address))
+	retryInterval := peerMinRetryInterval
+
+	for {
+		connected, err := b.connect(ctx, peer, logger)
+		if errors.Is(err, errSelfPeer) {
+			return
+		}
+
+		if connected {
+			retryInterval = peerMinRetryInterval
+		} else if ctx.Err() == nil {
+			logger.Warn("failed to connect to cluster peer", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+
+		retryInterval = min(2*retryInterval, peerMaxRetryInterval)
+	}
+}
+
+func (b *PeerBus) connect(ctx context.Context, peer *outboundPeer, logger *zap.Logger) (bool, error) {
+	dialer := net.Dialer{Timeout: peerHandshakeTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", peer.address)
+	if err != nil {
+		return false, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	nodeId, decoder, err := b.dialHandshake(conn)
+	if err != nil {
+		conn.Close()
+
+		return false, err
+	}
+
+	connection := newPeerConn(conn)
+
+	b.mu.Lock()
+	for _, other := range b.outbound {
+		if other != peer && other.connectedTo(nodeId) {
+			err = errDuplicatePeer
+		}
+	}
+
+	if err == nil {
+		peer.connect(nodeId, connection)
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		conn.Close()
+
+		return false, fmt.Errorf("%w %s", err, nodeId)
+	}
+
+	logger = logger.With(zap.String("peerNodeId", nodeId))
+	logger.Info("connected to cluster peer")
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		connection.writeLoop()
+	}()
+
+	for {
+		var frame peerFrame
+		err = decoder.Decode(&frame)
+		if err != nil {
+			break
+		}
+
+		switch frame.Type {
+		case peerFrameSubscribe:
+			peer.setInterest(frame.Channels, true)
+		case peerFrameUnsubscribe:
+			peer.setInterest(frame.Channels, false)
+		}
+	}
+
+	peer.disconnect()
+	connection.close()
+
+	if errors.Is(err, io.EOF) || ctx.Err() != nil {
+		err = nil
+	}
+
+	logger.Info("disconnected from cluster peer", zap.Error(err))
+
+	return true, err
+}
+
+func (b *PeerBus) dialHandshake(conn net.Conn) (string, *json.Decoder, error) {
+	conn.SetDeadline(time.Now().Add(peerHandshakeTimeout))
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	var challenge peerFrame
+	err := decoder.Decode(&challenge)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if challenge.Type != peerFrameChallenge {
+		return "", nil, errors.New("unexpected cluster handshake")
+	}
+
+	if challenge.NodeId == b.nodeId {
+		return "", nil, errSelfPeer
+	}
+
+	nonce := make([]byte, 32)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = encoder.Encode(peerFrame{
+		Type:   peerFrameHello,
+		NodeId: b.nodeId,
+		Nonce:  nonce,
+		MAC:    b.sign(challenge.Nonce, b.nodeId),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var welcome peerFrame
+	err = decoder.Decode(&welcome)
+	if err != nil {
+		return "", nil, fmt.Errorf("cluster peer rejected the handshake: %w", err)
+	}
+
+	if welcome.Type != peerFrameWelcome || !hmac.Equal(welcome.MAC, b.sign(nonce, challenge.NodeId)) {
+		return "", nil, errors.New("invalid cluster secret")
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return challenge.NodeId, decoder, nil
+}
+
+// outboundPeer is a discovered peer this node dials and forwards messages to.
+type outboundPeer struct {
+	address string
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	nodeId   string
+	conn     *peerConn
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (p *outboundPeer) connect(nodeId string, conn *peerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nodeId = nodeId
+	p.conn = conn
+	p.channels = make(map[string]struct{})
+	p.patterns = make(map[string]struct{})
+}
+
+func (p *outboundPeer) disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nodeId = ""
+	p.conn = nil
+	p.channels = nil
+	p.patterns = nil
+}
+
+func (p *outboundPeer) connectedTo(nodeId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.conn != nil && p.nodeId == nodeId
+}
+
+func (p *outboundPeer) setInterest(channels []string, interested bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, channelId := range channels {
+		interest := p.channels
+		if pattern.IsPattern(channelId) {
+			interest = p.patterns
+		}
+
+		if interested {
+			interest[channelId] = struct{}{}
+		} else {
+			delete(interest, channelId)
+		}
+	}
+}
+
+// forward sends the frame if the peer is interested in the channel. It only
+// reports false when the frame had to be dropped.
+func (p *outboundPeer) forward(channelId string, frame peerFrame) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return true
+	}
+
+	if !p.interestedLocked(channelId) {
+		return true
+	}
+
+	return p.conn.write(frame)
+}
+
+// IMPORTANT: It must be called only when the peer lock is already held.
+func (p *outboundPeer) interestedLocked(channelId string) bool {
+	if _, ok := p.channels[channelId]; ok {
+		return true
+	}
+
+	for channelPattern := range p.patterns {
+		if pattern.Matches(channelPattern, channelId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peerConn serializes the frames written to a peer through a bounded queue so
+// that a slow peer never blocks the caller.
+type peerConn struct {
+	conn      net.Conn
+	send      chan peerFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeerConn(conn net.Conn) *peerConn {
+	return &peerConn{
+		conn:   conn,
+		send:   make(chan peerFrame, peerQueueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *peerConn) write(frame peerFrame) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *peerConn) writeLoop() {
+	encoder := json.NewEncoder(c.conn)
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+
+			err := encoder.Encode(frame)
+			if err != nil {
+				c.close()
+
+				return
+			}
+		}
+	}
+}
+
+func (c *peerConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type receivedMessages struct {
+	mu       sync.Mutex
+	messages []broadcaster.Message
+}
+
+func (r *receivedMessages) add(message broadcaster.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, message)
+}
+
+func (r *receivedMessages) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := []string{}
+	for _, message := range r.messages {
+		ids = append(ids, message.Id)
+	}
+
+	return ids
+}
+
+func interestedPeers(bus *PeerBus, channelId string) int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	count := 0
+	for _, peer := range bus.outbound {
+		peer.mu.Lock()
+		if _, ok := peer.channels[channelId]; ok {
+			count++
+		}
+		peer.mu.Unlock()
+	}
+
+	return count
+}
+
+func connectedPeers(bus *PeerBus) int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	count := 0
+	for _, peer := range bus.outbound {
+		peer.mu.Lock()
+		if peer.conn != nil {
+			count++
+		}
+		peer.mu.Unlock()
+	}
+
+	return count
+}
+
+func TestPeerBus(t *testing.T) {
+	logger := zap.NewNop()
+
+	listeners := map[string]net.Listener{}
+	addresses := StaticPeers{}
+	for _, nodeId := range []string{"node-a", "node-b", "node-c", "node-d"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		listeners[nodeId] = listener
+		addresses = append(addresses, listener.Addr().String())
+	}
+
+	newBus := func(nodeId, secret string) (*PeerBus, *receivedMessages) {
+		received := &receivedMessages{}
+
+		bus := NewPeerBus(logger, nodeId, secret, listeners[nodeId], addresses, time.Minute)
+		require.NoError(t, bus.Start(received.add))
+		t.Cleanup(func() { bus.Close() })
+
+		return bus, received
+	}
+
+	busA, receivedA := newBus("node-a", "secret")
+	busB, receivedB := newBus("node-b", "secret")
+	_, receivedC := newBus("node-c", "secret")
+	busD, receivedD := newBus("node-d", "another-secret")
+
+	assert.Eventually(t, func() bool { return connectedPeers(busA) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("forwards only to interested peers", func(t *testing.T) {
+		require.NoError(t, busB.Subscribe("room:42"))
+
+		assert.Eventually(t, func() bool { return interestedPeers(busA, "room:42") == 1 }, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, busA.Publish(broadcaster.Message{Id: "msg-1", Channel: "room:42"}))
+
+		assert.Eventually(t, func() bool { return len(receivedB.ids()) == 1 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"msg-1"}, receivedB.ids())
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, receivedA.ids())
+		assert.Empty(t, receivedC.ids())
+	})
+
+	t.Run("stops forwarding after unsubscribe", func(t *testing.T) {
+		require.NoError(t, busB.Unsubscribe("room:42"))
+
+		assert.Eventually(t, func() bool { return interestedPeers(busA, "room:42") == 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("rejects peers with another secret", func(t *testing.T) {
+		assert.Equal(t, 0, connectedPeers(busD))
+
+		require.NoError(t, busD.Subscribe("room:42"))
+		require.NoError(t, busA.Subscribe("room:42"))
+		require.NoError(t, busD.Publish(broadcaster.Message{Id: "msg-2", Channel: "room:42"}))
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Empty(t, receivedA.ids())
+		assert.Empty(t, receivedD.ids())
+		assert.Equal(t, 0, interestedPeers(busA, "room:42"))
+	})
+}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/pglisten"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const postgresOperationTimeout = 5 * time.Second
+
+// PostgresMaxPayloadBytes is the largest NOTIFY payload accepted by Postgres
+// in its default configuration.
+const PostgresMaxPayloadBytes = 7999
+
+// PostgresBus forwards messages through Postgres NOTIFY on a single channel.
+// Postgres channel names are limited to 63 bytes, which is shorter than some
+// broadcaster channels, so every node receives every message and drops the
+// ones without local subscribers.
+type PostgresBus struct {
+	logger   *zap.Logger
+	nodeId   string
+	channel  string
+	pool     *pgxpool.Pool
+	listener *pglisten.Listener
+
+	handler func(message broadcaster.Message)
+}
+
+func NewPostgresBus(
+	logger *zap.Logger,
+	nodeId string,
+	channel string,
+	pool *pgxpool.Pool,
+	listener *pglisten.Listener,
+) *PostgresBus {
+	return &PostgresBus{
+		logger:   logger,
+		nodeId:   nodeId,
+		channel:  channel,
+		pool:     pool,
+		listener: listener,
+	}
+}
+
+func (b *PostgresBus) Start(handler func(message broadcaster.Message)) error {
+	b.handler = handler
+	b.listener.Start(b.receive)
+
+	return nil
+}
+
+// Publish sends the message to the other nodes. Messages over the NOTIFY
+// payload limit are not forwarded.
+func (b *PostgresBus) Publish(message broadcaster.Message) error {
+	data, err := encodeEnvelope(b.nodeId, message)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > PostgresMaxPayloadBytes {
+		return fmt.Errorf("message of %d bytes exceeds the postgres notification limit of %d bytes",
+			len(data), PostgresMaxPayloadBytes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresOperationTimeout)
+	defer cancel()
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(data))
+
+	return err
+}
+
+func (b *PostgresBus) Subscribe(channelId string) error {
+	return nil
+}
+
+func (b *PostgresBus) Unsubscribe(channelId string) error {
+	return nil
+}
+
+// Close stops the listener and closes the pool, which the bus owns.
+func (b *PostgresBus) Close() error {
+	b.listener.Close()
+	b.pool.Close()
+
+	return nil
+}
+
+func (b *PostgresBus) receive(notification *pgconn.Notification) {
+	envelope, err := decodeEnvelope([]byte(notification.Payload))
+	if err != nil {
+		b.logger.Warn("failed to decode message from postgres",
+			zap.String("postgresChannel", notification.Channel),
+			zap.Error(err))
+
+		return
+	}
+
+	if envelope.NodeId == b.nodeId {
+		return
+	}
+
+	b.handler(envelope.Message)
+}
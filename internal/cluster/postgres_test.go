@@ -0,0 +1,35 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPostgresBus(t *testing.T) {
+	bus := NewPostgresBus(zap.NewNop(), "node-a", "broadcaster", nil, nil)
+
+	received := &receivedMessages{}
+	bus.handler = received.add
+
+	t.Run("rejects messages over the notification limit", func(t *testing.T) {
+		err := bus.Publish(broadcaster.Message{Channel: "room:42", Payload: strings.Repeat("a", PostgresMaxPayloadBytes)})
+		assert.ErrorContains(t, err, "exceeds the postgres notification limit")
+	})
+
+	t.Run("ignores its own messages", func(t *testing.T) {
+		for _, nodeId := range []string{"node-a", "node-b"} {
+			data, err := encodeEnvelope(nodeId, broadcaster.Message{Id: "from-" + nodeId, Channel: "room:42"})
+			require.NoError(t, err)
+
+			bus.receive(&pgconn.Notification{Channel: "broadcaster", Payload: string(data)})
+		}
+
+		assert.Equal(t, []string{"from-node-b"}, received.ids())
+	})
+}
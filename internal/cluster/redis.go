@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/pattern"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const redisOperationTimeout = 5 * time.Second
+
+// RedisBus forwards messages through Redis pub/sub. Every broadcaster channel
+// maps to a Redis channel named after it, with a prefix, and a node only
+// subscribes to the channels it has local subscribers for.
+type RedisBus struct {
+	logger *zap.Logger
+	nodeId string
+	prefix string
+	client *redis.Client
+
+	mu     sync.Mutex
+	pubSub *redis.PubSub
+	done   chan struct{}
+}
+
+func NewRedisBus(
+	logger *zap.Logger,
+	nodeId string,
+	prefix string,
+	client *redis.Client,
+) *RedisBus {
+	return &RedisBus{
+		logger: logger,
+		nodeId: nodeId,
+		prefix: prefix,
+		client: client,
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *RedisBus) Start(handler func(message broadcaster.Message)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The subscription starts without channels, they are added as local
+	// subscribers come and go. go-redis reconnects and resubscribes on its own.
+	b.pubSub = b.client.Subscribe(context.Background())
+
+	go b.receive(b.pubSub.Channel(), handler)
+
+	return nil
+}
+
+func (b *RedisBus) Publish(message broadcaster.Message) error {
+	data, err := encodeEnvelope(b.nodeId, message)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOperationTimeout)
+	defer cancel()
+
+	return b.client.Publish(ctx, b.prefix+message.Channel, data).Err()
+}
+
+func (b *RedisBus) Subscribe(channelId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOperationTimeout)
+	defer cancel()
+
+	if pattern.IsPattern(channelId) {
+		return b.pubSub.PSubscribe(ctx, b.prefix+redisPattern(channelId))
+	}
+
+	return b.pubSub.Subscribe(ctx, b.prefix+channelId)
+}
+
+func (b *RedisBus) Unsubscribe(channelId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOperationTimeout)
+	defer cancel()
+
+	if pattern.IsPattern(channelId) {
+		return b.pubSub.PUnsubscribe(ctx, b.prefix+redisPattern(channelId))
+	}
+
+	return b.pubSub.Unsubscribe(ctx, b.prefix+channelId)
+}
+
+// redisPattern converts a channel pattern to a Redis glob. Redis `*` also
+// matches colons, so the glob may match more channels than the pattern; the
+// registry only delivers messages to the subscriptions they match.
+func redisPattern(channelPattern string) string {
+	segments := strings.Split(channelPattern, pattern.Separator)
+	for i, segment := range segments {
+		if segment == pattern.MultiWildcard {
+			segments[i] = "*"
+		}
+	}
+
+	return strings.Join(segments, pattern.Separator)
+}
+
+// Close stops the subscription and closes the Redis client, which the bus owns.
+func (b *RedisBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	if b.pubSub != nil {
+		err = b.pubSub.Close()
+		<-b.done
+	}
+
+	return errors.Join(err, b.client.Close())
+}
+
+func (b *RedisBus) receive(messages <-chan *redis.Message, handler func(message broadcaster.Message)) {
+	defer close(b.done)
+
+	for redisMessage := range messages {
+		envelope, err := decodeEnvelope([]byte(redisMessage.Payload))
+		if err != nil {
+			b.logger.Warn("failed to decode message from redis",
+				zap.String("redisChannel", redisMessage.Channel),
+				zap.Error(err))
+
+			continue
+		}
+
+		if envelope.NodeId == b.nodeId {
+			continue
+		}
+
+		handler(envelope.Message)
+	}
+}
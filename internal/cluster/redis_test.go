@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRedisBus(t *testing.T) {
+	logger := zap.NewNop()
+	server := miniredis.RunT(t)
+
+	newNode := func(nodeId string) *broadcaster.ClusterRegistry {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		bus := NewRedisBus(logger, nodeId, "broadcaster:", client)
+		local := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(10, 0), 0)
+
+		registry, err := broadcaster.NewClusterRegistry(logger, local, bus)
+		require.NoError(t, err)
+		t.Cleanup(func() { registry.Close() })
+
+		return registry
+	}
+
+	nodeA := newNode("node-a")
+	nodeB := newNode("node-b")
+
+	connectionA := &broadcaster.Connection{Id: "connection-a", Send: make(chan broadcaster.Message, 10)}
+	require.NoError(t, nodeA.Connect(connectionA))
+	require.NoError(t, nodeA.Subscribe("room:42", connectionA.Id, broadcaster.SubscribeOptions{}))
+
+	connectionB := &broadcaster.Connection{Id: "connection-b", Send: make(chan broadcaster.Message, 10)}
+	require.NoError(t, nodeB.Connect(connectionB))
+	require.NoError(t, nodeB.Subscribe("room:42", connectionB.Id, broadcaster.SubscribeOptions{}))
+
+	assert.Eventually(t, func() bool {
+		return server.PubSubNumSub("broadcaster:room:42")["broadcaster:room:42"] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("delivers to every node once", func(t *testing.T) {
+		_, err := nodeA.Broadcast(broadcaster.Message{Id: "msg-1", Channel: "room:42", CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		select {
+		case message := <-connectionB.Send:
+			assert.Equal(t, "msg-1", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the other node")
+		}
+
+		select {
+		case message := <-connectionA.Send:
+			assert.Equal(t, "msg-1", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the local node")
+		}
+
+		select {
+		case message := <-connectionA.Send:
+			t.Fatalf("message %s delivered twice to the local node", message.Id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("keeps the offsets of the origin node", func(t *testing.T) {
+		// Published on node A only, so that the offsets of the nodes differ.
+		_, err := nodeA.InMemoryRegistry.Broadcast(broadcaster.Message{Id: "msg-local", Channel: "room:42", CreateTime: time.Now()})
+		require.NoError(t, err)
+		<-connectionA.Send
+
+		_, err = nodeA.Broadcast(broadcaster.Message{Id: "msg-offset", Channel: "room:42", CreateTime: time.Now()})
+		require.NoError(t, err)
+		<-connectionA.Send
+
+		select {
+		case message := <-connectionB.Send:
+			assert.Equal(t, "msg-offset", message.Id)
+			assert.Equal(t, uint64(3), message.Offset)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the other node")
+		}
+
+		afterOffset := uint64(2)
+		messages, err := nodeB.History("room:42", broadcaster.HistoryQuery{AfterOffset: &afterOffset})
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 1) {
+			assert.Equal(t, "msg-offset", messages[0].Id)
+		}
+
+		// Node B missed the local message, so it cannot replay from before.
+		afterOffset = 1
+		_, err = nodeB.History("room:42", broadcaster.HistoryQuery{AfterOffset: &afterOffset})
+		assert.ErrorIs(t, err, broadcaster.ErrHistoryUnavailable)
+	})
+
+	t.Run("forwards revocations", func(t *testing.T) {
+		revocations := make(chan broadcaster.Revocation, 1)
+		nodeB.OnRevocation(func(revocation broadcaster.Revocation) {
+			revocations <- revocation
+		})
+
+		revocation := broadcaster.Revocation{
+			Subject:    "user-1",
+			RevokeTime: time.Now().Truncate(time.Second),
+			ExpireTime: time.Now().Add(time.Hour).Truncate(time.Second),
+		}
+		require.NoError(t, nodeA.PublishRevocation(revocation))
+
+		select {
+		case received := <-revocations:
+			assert.Equal(t, revocation.Subject, received.Subject)
+			assert.True(t, revocation.RevokeTime.Equal(received.RevokeTime))
+			assert.True(t, revocation.ExpireTime.Equal(received.ExpireTime))
+		case <-time.After(time.Second):
+			t.Fatal("revocation not forwarded to the other node")
+		}
+
+		// Revocations are not delivered to the subscribers of any channel.
+		select {
+		case message := <-connectionB.Send:
+			t.Fatalf("revocation delivered as message %s", message.Id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("follows local subscribers", func(t *testing.T) {
+		nodeB.Unsubscribe("room:42", connectionB.Id)
+
+		assert.Eventually(t, func() bool {
+			return server.PubSubNumSub("broadcaster:room:42")["broadcaster:room:42"] == 1
+		}, time.Second, 10*time.Millisecond)
+
+		nodeA.Disconnect(connectionA.Id)
+
+		assert.Eventually(t, func() bool {
+			return server.PubSubNumSub("broadcaster:room:42")["broadcaster:room:42"] == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("delivers pattern subscriptions once", func(t *testing.T) {
+		connection := &broadcaster.Connection{Id: "connection-pattern", Send: make(chan broadcaster.Message, 10)}
+		require.NoError(t, nodeB.Connect(connection))
+		require.NoError(t, nodeB.Subscribe("org:42:**", connection.Id, broadcaster.SubscribeOptions{}))
+		require.NoError(t, nodeB.Subscribe("org:42:room", connection.Id, broadcaster.SubscribeOptions{}))
+
+		assert.Eventually(t, func() bool {
+			return server.PubSubNumPat() == 1 && server.PubSubNumSub("broadcaster:org:42:room")["broadcaster:org:42:room"] == 1
+		}, time.Second, 10*time.Millisecond)
+
+		_, err := nodeA.Broadcast(broadcaster.Message{Id: "msg-2", Channel: "org:42:room", CreateTime: time.Now()})
+		require.NoError(t, err)
+
+		select {
+		case message := <-connection.Send:
+			assert.Equal(t, "msg-2", message.Id)
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered to the pattern subscriber")
+		}
+
+		select {
+		case message := <-connection.Send:
+			t.Fatalf("message %s delivered twice", message.Id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
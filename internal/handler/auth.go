@@ -15,6 +15,9 @@ type AuthRequest struct {
 
 type AuthResponse struct {
 	Success bool `json:"success"`
+	// SessionId identifies the connection's session. It can be passed to the
+	// resume method of a later connection to recover the subscriptions.
+	SessionId string `json:"sessionId,omitempty"`
 }
 
 type AuthHandlerInterface interface {
@@ -43,12 +46,13 @@ func (h *AuthHandler) Handle(ctx context.Context, req AuthRequest) (AuthResponse
 	}
 
 	if connection.GetUserId() != "" {
-		return AuthResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, errors.New("connection is already authenticated"))
+		return AuthResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, errors.New("connection is already authenticated, use refresh to replace the token"))
 	}
 
 	connection.SetAuthentication(authentication)
 
 	return AuthResponse{
-		Success: true,
+		Success:   true,
+		SessionId: connection.Id,
 	}, nil
 }
@@ -3,6 +3,9 @@ package handler
 import (
 	"errors"
 	"regexp"
+	"strings"
+
+	"github.com/goevery/broadcaster/internal/pattern"
 
 	"github.com/goevery/broadcaster/internal/ierr"
 )
@@ -25,3 +28,24 @@ func (v *ChannelValidator) Validate(channel string) error {
 
 	return nil
 }
+
+// ValidatePattern accepts channel ids and subscription patterns, whose `*`
+// segments stand for one segment and whose last segment may be `**`.
+func (v *ChannelValidator) ValidatePattern(channelPattern string) error {
+	segments := strings.Split(channelPattern, pattern.Separator)
+
+	for i, segment := range segments {
+		switch segment {
+		case pattern.MultiWildcard:
+			if i != len(segments)-1 {
+				return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("** can only be the last segment of a pattern"))
+			}
+
+			segments[i] = "x"
+		case pattern.SingleWildcard:
+			segments[i] = "x"
+		}
+	}
+
+	return v.Validate(strings.Join(segments, pattern.Separator))
+}
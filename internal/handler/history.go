@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/ierr"
+)
+
+type HistoryRequest struct {
+	Channel     string    `json:"channel"`
+	Limit       int       `json:"limit,omitempty"`
+	Since       time.Time `json:"since"`
+	AfterOffset *uint64   `json:"afterOffset,omitempty"`
+}
+
+type HistoryResponse struct {
+	Messages []broadcaster.Message `json:"messages"`
+}
+
+type HistoryHandlerInterface interface {
+	Handle(ctx context.Context, req HistoryRequest) (HistoryResponse, error)
+}
+
+type HistoryHandler struct {
+	channelValidator     *ChannelValidator
+	subscriptionRegistry broadcaster.Registry
+}
+
+func NewHistoryHandler(
+	channelValidator *ChannelValidator,
+	subscriptionRegistry broadcaster.Registry,
+) *HistoryHandler {
+	return &HistoryHandler{
+		channelValidator,
+		subscriptionRegistry,
+	}
+}
+
+func (h *HistoryHandler) Handle(ctx context.Context, req HistoryRequest) (HistoryResponse, error) {
+	err := h.channelValidator.Validate(req.Channel)
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+
+	if req.Limit < 0 {
+		return HistoryResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("limit cannot be negative"))
+	}
+
+	authentication, ok := auth.AuthenticationFromContext(ctx)
+	if !ok {
+		return HistoryResponse{}, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("user not authenticated"))
+	}
+
+	if !authentication.Can(auth.ScopeHistory, req.Channel) {
+		return HistoryResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to access this channel"))
+	}
+
+	messages, err := h.subscriptionRegistry.History(req.Channel, broadcaster.HistoryQuery{
+		Limit:       req.Limit,
+		Since:       req.Since,
+		AfterOffset: req.AfterOffset,
+	})
+	if errors.Is(err, broadcaster.ErrHistoryUnavailable) {
+		return HistoryResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, err)
+	}
+
+	if err != nil {
+		return HistoryResponse{}, err
+	}
+
+	if messages == nil {
+		messages = []broadcaster.Message{}
+	}
+
+	return HistoryResponse{
+		Messages: messages,
+	}, nil
+}
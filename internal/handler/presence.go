@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/ierr"
+)
+
+type PresenceRequest struct {
+	Channel string `json:"channel"`
+}
+
+type PresenceResponse struct {
+	Members []broadcaster.PresenceMember `json:"members"`
+}
+
+type PresenceHandlerInterface interface {
+	Handle(ctx context.Context, req PresenceRequest) (PresenceResponse, error)
+}
+
+// presenceTracker is implemented by the registries that may not track the
+// members of presence channels, such as the cluster registry.
+type presenceTracker interface {
+	TracksPresence() bool
+}
+
+type PresenceHandler struct {
+	channelValidator     *ChannelValidator
+	subscriptionRegistry broadcaster.Registry
+}
+
+func NewPresenceHandler(
+	channelValidator *ChannelValidator,
+	subscriptionRegistry broadcaster.Registry,
+) *PresenceHandler {
+	return &PresenceHandler{
+		channelValidator,
+		subscriptionRegistry,
+	}
+}
+
+func (h *PresenceHandler) Handle(ctx context.Context, req PresenceRequest) (PresenceResponse, error) {
+	err := h.channelValidator.Validate(req.Channel)
+	if err != nil {
+		return PresenceResponse{}, err
+	}
+
+	if !broadcaster.IsPresenceChannel(req.Channel) {
+		return PresenceResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("not a presence channel"))
+	}
+
+	if tracker, ok := h.subscriptionRegistry.(presenceTracker); ok && !tracker.TracksPresence() {
+		return PresenceResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, broadcaster.ErrPresenceUnavailable)
+	}
+
+	var authentication *auth.Authentication
+
+	connection, ok := broadcaster.ConnectionFromContext(ctx)
+	if ok {
+		authentication = connection.GetAuthentication()
+	}
+
+	if authentication == nil {
+		authentication, ok = auth.AuthenticationFromContext(ctx)
+		if !ok {
+			return PresenceResponse{}, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("user not authenticated"))
+		}
+	}
+
+	if !authentication.Can(auth.ScopePresence, req.Channel) {
+		return PresenceResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to access this channel"))
+	}
+
+	return PresenceResponse{
+		Members: h.subscriptionRegistry.Presence(req.Channel),
+	}, nil
+}
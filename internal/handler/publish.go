@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/goevery/broadcaster/internal/auth"
@@ -15,6 +16,9 @@ type PublishRequest struct {
 	Channel string `json:"channel"`
 	Event   string `json:"event"`
 	Payload any    `json:"payload"`
+	// ExcludedConnectionId is a connection the message is not delivered to.
+	// It is set by transports, never by clients.
+	ExcludedConnectionId string `json:"-"`
 }
 
 type PublishHandlerInterface interface {
@@ -51,12 +55,12 @@ func (h *PublishHandler) Handle(ctx context.Context, req PublishRequest) (broadc
 		}
 	}
 
-	if !authentication.IsPublisher() {
+	if authentication.Permissions == nil && !authentication.HasScope(auth.ScopePublish) {
 		return broadcaster.Message{},
 			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to publish messages"))
 	}
 
-	if !authentication.IsAuthorized(req.Channel) {
+	if !authentication.Can(auth.ScopePublish, req.Channel) {
 		return broadcaster.Message{},
 			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to publish to this channel"))
 	}
@@ -66,15 +70,20 @@ func (h *PublishHandler) Handle(ctx context.Context, req PublishRequest) (broadc
 		return broadcaster.Message{}, err
 	}
 
+	if strings.HasPrefix(req.Event, "presence.") {
+		return broadcaster.Message{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("presence events are reserved"))
+	}
+
 	message := broadcaster.Message{
 		Id:         gonanoid.Must(),
 		CreateTime: time.Now(),
 		Channel:    req.Channel,
 		Event:      req.Event,
 		Payload:    req.Payload,
-	}
 
-	h.subscriptionRegistry.Broadcast(message)
+		ExcludedConnectionId: req.ExcludedConnectionId,
+	}
 
-	return message, nil
+	return h.subscriptionRegistry.Broadcast(message)
 }
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/ierr"
+)
+
+type RefreshRequest struct {
+	Token string `json:"token"`
+}
+
+type RefreshResponse struct {
+	Success bool `json:"success"`
+	// Unsubscribed lists the channels the new token no longer grants. The
+	// connection has been unsubscribed from them.
+	Unsubscribed []string `json:"unsubscribed"`
+}
+
+type RefreshHandlerInterface interface {
+	Handle(ctx context.Context, req RefreshRequest) (RefreshResponse, error)
+}
+
+type RefreshHandler struct {
+	authenticator        *auth.Authenticator
+	subscriptionRegistry broadcaster.Registry
+}
+
+func NewRefreshHandler(
+	authenticator *auth.Authenticator,
+	subscriptionRegistry broadcaster.Registry,
+) *RefreshHandler {
+	return &RefreshHandler{
+		authenticator,
+		subscriptionRegistry,
+	}
+}
+
+func (h *RefreshHandler) Handle(ctx context.Context, req RefreshRequest) (RefreshResponse, error) {
+	connection, ok := broadcaster.ConnectionFromContext(ctx)
+	if !ok {
+		return RefreshResponse{}, errors.New("connection not found in context")
+	}
+
+	userId := connection.GetUserId()
+	if userId == "" {
+		return RefreshResponse{},
+			ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("authentication required"))
+	}
+
+	authentication, err := h.authenticator.AuthenticateJWT(req.Token)
+	if err != nil {
+		return RefreshResponse{}, err
+	}
+
+	if authentication.Subject != userId {
+		return RefreshResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("token belongs to another subject"))
+	}
+
+	connection.SetAuthentication(authentication)
+
+	// The new token may grant fewer channels than the previous one, so the
+	// existing subscriptions are authorized again.
+	unsubscribed := []string{}
+
+	session, ok := h.subscriptionRegistry.Session(connection.Id)
+	if !ok {
+		return RefreshResponse{}, errors.New("connection not found in registry")
+	}
+
+	for _, channelId := range session.Channels {
+		if authentication.Can(auth.ScopeSubscribe, channelId) {
+			continue
+		}
+
+		h.subscriptionRegistry.Unsubscribe(channelId, connection.Id)
+		unsubscribed = append(unsubscribed, channelId)
+	}
+
+	return RefreshResponse{
+		Success:      true,
+		Unsubscribed: unsubscribed,
+	}, nil
+}
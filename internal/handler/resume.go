@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
+)
+
+// maxResumePositions is the most channels a resume request may give positions
+// for.
+const maxResumePositions = 256
+
+type ResumeRequest struct {
+	SessionId string `json:"sessionId"`
+	// Positions maps a channel of the previous session to the offset of the
+	// last message received on it. Channels without a position are re-attached
+	// without replaying anything.
+	Positions map[string]uint64 `json:"positions,omitempty"`
+}
+
+type ResumeResponse struct {
+	Channels []string `json:"channels"`
+}
+
+type ResumeHandlerInterface interface {
+	Handle(ctx context.Context, req ResumeRequest) (ResumeResponse, error)
+}
+
+type ResumeHandler struct {
+	channelValidator     *ChannelValidator
+	subscriptionRegistry broadcaster.Registry
+}
+
+func NewResumeHandler(
+	channelValidator *ChannelValidator,
+	subscriptionRegistry broadcaster.Registry,
+) *ResumeHandler {
+	return &ResumeHandler{
+		channelValidator,
+		subscriptionRegistry,
+	}
+}
+
+func (h *ResumeHandler) Handle(ctx context.Context, req ResumeRequest) (ResumeResponse, error) {
+	if req.SessionId == "" {
+		return ResumeResponse{}, ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("session id is required"))
+	}
+
+	if len(req.Positions) > maxResumePositions {
+		return ResumeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, fmt.Errorf("positions cannot hold more than %d channels", maxResumePositions))
+	}
+
+	// Positions stand for the channels of an unknown session, so they are
+	// checked as subscriptions are.
+	for channelId := range req.Positions {
+		err := h.channelValidator.ValidatePattern(channelId)
+		if err != nil {
+			return ResumeResponse{}, err
+		}
+	}
+
+	connection, ok := broadcaster.ConnectionFromContext(ctx)
+	if !ok {
+		return ResumeResponse{}, errors.New("connection not found in context")
+	}
+
+	userId := connection.GetUserId()
+	if userId == "" {
+		return ResumeResponse{},
+			ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("authentication required"))
+	}
+
+	session, ok := h.subscriptionRegistry.Session(req.SessionId)
+	if ok && session.UserId != userId {
+		return ResumeResponse{}, ierr.New(ierr.ErrorCodeNotFound, broadcaster.ErrSessionNotFound)
+	}
+
+	if !ok {
+		if len(req.Positions) == 0 {
+			return ResumeResponse{}, ierr.New(ierr.ErrorCodeNotFound, broadcaster.ErrSessionNotFound)
+		}
+
+		// The session is gone, for instance because the server restarted, but
+		// the positions still tell which channels to recover.
+		session = broadcaster.Session{
+			Id:       req.SessionId,
+			UserId:   userId,
+			Channels: slices.Sorted(maps.Keys(req.Positions)),
+		}
+	}
+
+	// The new token may grant fewer channels than the one the session was
+	// created with, so every channel is authorized again.
+	channels := make([]string, 0, len(session.Channels))
+	subscriptions := make(map[string]broadcaster.SubscribeOptions, len(session.Channels))
+	var deniedChannels []string
+	for _, channelId := range session.Channels {
+		if !connection.Can(auth.ScopeSubscribe, channelId) {
+			continue
+		}
+
+		channels = append(channels, channelId)
+
+		// Offsets belong to channels, so patterns are re-attached without
+		// replay.
+		var options broadcaster.SubscribeOptions
+		if offset, ok := req.Positions[channelId]; ok && !pattern.IsPattern(channelId) {
+			// Replaying reads the history, as subscribing with history does.
+			if !connection.Can(auth.ScopeHistory, channelId) {
+				deniedChannels = append(deniedChannels, channelId)
+			}
+
+			options.History = &broadcaster.HistoryQuery{
+				AfterOffset: &offset,
+			}
+		}
+
+		subscriptions[channelId] = options
+	}
+
+	if len(deniedChannels) > 0 {
+		handlerErr := ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to read the history of these channels"))
+		handlerErr.Data, _ = json.Marshal(map[string][]string{"channels": deniedChannels})
+
+		return ResumeResponse{}, handlerErr
+	}
+
+	err := h.subscriptionRegistry.Resume(req.SessionId, connection.Id, subscriptions)
+
+	var resumeErr *broadcaster.ResumeNotPossibleError
+	if errors.As(err, &resumeErr) {
+		handlerErr := ierr.New(ierr.ErrorCodeFailedPrecondition, errors.New("resume not possible"))
+		handlerErr.Data, _ = json.Marshal(map[string][]string{"channels": resumeErr.Channels})
+
+		return ResumeResponse{}, handlerErr
+	}
+
+	if errors.Is(err, broadcaster.ErrSessionNotFound) {
+		return ResumeResponse{}, ierr.New(ierr.ErrorCodeNotFound, err)
+	}
+
+	if errors.Is(err, broadcaster.ErrPresenceUnavailable) {
+		return ResumeResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, err)
+	}
+
+	if err != nil {
+		return ResumeResponse{}, err
+	}
+
+	return ResumeResponse{
+		Channels: channels,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/ierr"
+)
+
+type RevokeRequest struct {
+	// Subject revokes every token of the subject issued until now.
+	Subject string `json:"subject,omitempty"`
+	// TokenId revokes the token with this `jti` claim.
+	TokenId string `json:"tokenId,omitempty"`
+	// ExpireTime is when the revocation can be forgotten because the tokens it
+	// covers have expired. It defaults to the default time to live of the
+	// handler.
+	ExpireTime time.Time `json:"expireTime,omitempty"`
+}
+
+type RevokeResponse struct {
+	// Disconnected is the number of connections closed by the revocation.
+	Disconnected int `json:"disconnected"`
+}
+
+type RevokeHandlerInterface interface {
+	Handle(ctx context.Context, req RevokeRequest) (RevokeResponse, error)
+}
+
+// revocationPublisher forwards revocations to the other nodes of a cluster.
+type revocationPublisher interface {
+	PublishRevocation(revocation broadcaster.Revocation) error
+}
+
+type RevokeHandler struct {
+	revocations          auth.RevocationList
+	subscriptionRegistry broadcaster.Registry
+	defaultTTL           time.Duration
+}
+
+func NewRevokeHandler(
+	revocations auth.RevocationList,
+	subscriptionRegistry broadcaster.Registry,
+	defaultTTL time.Duration,
+) *RevokeHandler {
+	return &RevokeHandler{
+		revocations,
+		subscriptionRegistry,
+		defaultTTL,
+	}
+}
+
+func (h *RevokeHandler) Handle(ctx context.Context, req RevokeRequest) (RevokeResponse, error) {
+	authentication, ok := auth.AuthenticationFromContext(ctx)
+	if !ok {
+		return RevokeResponse{}, ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("user not authenticated"))
+	}
+
+	if !authentication.HasScope(auth.ScopeAdmin) {
+		return RevokeResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to revoke tokens"))
+	}
+
+	if req.Subject == "" && req.TokenId == "" {
+		return RevokeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("subject or token id is required"))
+	}
+
+	now := time.Now()
+
+	expireTime := req.ExpireTime
+	if expireTime.IsZero() {
+		expireTime = now.Add(h.defaultTTL)
+	}
+
+	if !expireTime.After(now) {
+		return RevokeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("expire time must be in the future"))
+	}
+
+	revocation := broadcaster.Revocation{
+		Subject:    req.Subject,
+		TokenId:    req.TokenId,
+		RevokeTime: now,
+		ExpireTime: expireTime,
+	}
+
+	disconnected := h.Apply(revocation)
+
+	if publisher, ok := h.subscriptionRegistry.(revocationPublisher); ok {
+		err := publisher.PublishRevocation(revocation)
+		if err != nil {
+			return RevokeResponse{}, ierr.New(ierr.ErrorCodeInternal,
+				fmt.Errorf("revocation not forwarded to the other nodes: %w", err))
+		}
+	}
+
+	return RevokeResponse{
+		Disconnected: disconnected,
+	}, nil
+}
+
+// Apply records the revocation and closes the local connections it covers. It
+// returns how many were closed.
+func (h *RevokeHandler) Apply(revocation broadcaster.Revocation) int {
+	if revocation.Subject != "" {
+		h.revocations.RevokeSubject(revocation.Subject, revocation.RevokeTime, revocation.ExpireTime)
+	}
+
+	if revocation.TokenId != "" {
+		h.revocations.RevokeToken(revocation.TokenId, revocation.ExpireTime)
+	}
+
+	return h.subscriptionRegistry.DisconnectMatching(func(connection *broadcaster.Connection) bool {
+		connectionAuthentication := connection.GetAuthentication()
+		if connectionAuthentication == nil {
+			return false
+		}
+
+		return (revocation.Subject != "" && connectionAuthentication.Subject == revocation.Subject) ||
+			(revocation.TokenId != "" && connectionAuthentication.TokenId == revocation.TokenId)
+	}, broadcaster.CloseReasonRevoked)
+}
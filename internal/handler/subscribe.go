@@ -3,14 +3,19 @@ package handler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/broadcaster"
 	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
 )
 
 type SubscribeRequest struct {
-	Channel string `json:"channel"`
+	Channel  string                    `json:"channel"`
+	History  *broadcaster.HistoryQuery `json:"history,omitempty"`
+	Metadata any                       `json:"metadata,omitempty"`
 }
 
 type SubscribeResponse struct {
@@ -25,21 +30,26 @@ type SubscribeHandlerInterface interface {
 type SubscribeHandler struct {
 	channelValidator     *ChannelValidator
 	subscriptionRegistry broadcaster.Registry
+	// maxHistoryLimit is the most messages a subscription may replay. It is
+	// the limit of the history queries that set none.
+	maxHistoryLimit int
 }
 
 func NewSubscribeHandler(
 	channelValidator *ChannelValidator,
 	subscriptionRegistry broadcaster.Registry,
+	maxHistoryLimit int,
 ) *SubscribeHandler {
 
 	return &SubscribeHandler{
 		channelValidator,
 		subscriptionRegistry,
+		maxHistoryLimit,
 	}
 }
 
 func (h *SubscribeHandler) Handle(ctx context.Context, req SubscribeRequest) (SubscribeResponse, error) {
-	err := h.channelValidator.Validate(req.Channel)
+	err := h.channelValidator.ValidatePattern(req.Channel)
 	if err != nil {
 		return SubscribeResponse{}, err
 	}
@@ -49,13 +59,13 @@ func (h *SubscribeHandler) Handle(ctx context.Context, req SubscribeRequest) (Su
 		return SubscribeResponse{}, errors.New("connection not found in context")
 	}
 
-	auth := connection.GetAuthentication()
-	if auth == nil {
+	authentication := connection.GetAuthentication()
+	if authentication == nil {
 		return SubscribeResponse{},
 			ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("authentication required"))
 	}
 
-	if !auth.IsSubscriber() {
+	if authentication.Permissions == nil && !authentication.HasScope(auth.ScopeSubscribe) {
 		return SubscribeResponse{},
 			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("subscribe scope required to subscribe to a channel"))
 	}
@@ -65,7 +75,48 @@ func (h *SubscribeHandler) Handle(ctx context.Context, req SubscribeRequest) (Su
 			ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("user not authorized to access this channel"))
 	}
 
-	err = h.subscriptionRegistry.Subscribe(req.Channel, connection.Id)
+	if !connection.Can(auth.ScopeSubscribe, req.Channel) {
+		return SubscribeResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to subscribe to this channel"))
+	}
+
+	if req.History != nil && pattern.IsPattern(req.Channel) {
+		return SubscribeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("history is not available for patterns"))
+	}
+
+	if req.History != nil && !connection.Can(auth.ScopeHistory, req.Channel) {
+		return SubscribeResponse{},
+			ierr.New(ierr.ErrorCodePermissionDenied, errors.New("user not authorized to read the history of this channel"))
+	}
+
+	if req.History != nil && req.History.Limit < 0 {
+		return SubscribeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("history limit cannot be negative"))
+	}
+
+	if req.History != nil && req.History.Limit > h.maxHistoryLimit {
+		return SubscribeResponse{},
+			ierr.New(ierr.ErrorCodeInvalidArgument, fmt.Errorf("history limit cannot exceed %d", h.maxHistoryLimit))
+	}
+
+	history := req.History
+	// Replays after an offset are left unlimited, since a limit would skip the
+	// oldest of the missed messages. They fail if the messages do not fit.
+	if history != nil && history.Limit == 0 && history.AfterOffset == nil {
+		limited := *history
+		limited.Limit = h.maxHistoryLimit
+		history = &limited
+	}
+
+	err = h.subscriptionRegistry.Subscribe(req.Channel, connection.Id, broadcaster.SubscribeOptions{
+		History:  history,
+		Metadata: req.Metadata,
+	})
+	if errors.Is(err, broadcaster.ErrHistoryUnavailable) || errors.Is(err, broadcaster.ErrPresenceUnavailable) {
+		return SubscribeResponse{}, ierr.New(ierr.ErrorCodeFailedPrecondition, err)
+	}
+
 	if err != nil {
 		return SubscribeResponse{}, err
 	}
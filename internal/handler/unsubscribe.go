@@ -35,7 +35,7 @@ func NewUnsubscribeHandler(
 }
 
 func (h *UnsubscribeHandler) Handle(ctx context.Context, req UnsubscribeRequest) (UnsubscribeResponse, error) {
-	err := h.channelValidator.Validate(req.Channel)
+	err := h.channelValidator.ValidatePattern(req.Channel)
 	if err != nil {
 		return UnsubscribeResponse{}, err
 	}
@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/pglisten"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// PostgresIngester publishes the payloads sent with NOTIFY on a set of
+// Postgres channels. Payloads are publish requests:
+//
+//	NOTIFY events, '{"channel":"room:42","event":"update","payload":{"foo":"bar"}}'
+//
+// When the channel is omitted, the name of the Postgres channel is used.
+//
+// Every node of a cluster receives the notifications, so the publish handler
+// must only deliver to the local connections.
+type PostgresIngester struct {
+	logger          *zap.Logger
+	listener        *pglisten.Listener
+	publishHandler  handler.PublishHandlerInterface
+	maxPayloadBytes int
+}
+
+func NewPostgresIngester(
+	logger *zap.Logger,
+	listener *pglisten.Listener,
+	publishHandler handler.PublishHandlerInterface,
+	maxPayloadBytes int,
+) *PostgresIngester {
+	return &PostgresIngester{
+		logger,
+		listener,
+		publishHandler,
+		maxPayloadBytes,
+	}
+}
+
+func (i *PostgresIngester) Start() {
+	i.listener.Start(i.handle)
+}
+
+func (i *PostgresIngester) Close() error {
+	i.listener.Close()
+
+	return nil
+}
+
+func (i *PostgresIngester) handle(notification *pgconn.Notification) {
+	logger := i.logger.With(zap.String("postgresChannel", notification.Channel))
+
+	if i.maxPayloadBytes > 0 && len(notification.Payload) > i.maxPayloadBytes {
+		logger.Warn("dropping postgres notification over the payload limit",
+			zap.Int("payloadBytes", len(notification.Payload)),
+			zap.Int("maxPayloadBytes", i.maxPayloadBytes))
+
+		return
+	}
+
+	var req handler.PublishRequest
+	err := json.Unmarshal([]byte(notification.Payload), &req)
+	if err != nil {
+		logger.Warn("dropping invalid postgres notification", zap.Error(err))
+
+		return
+	}
+
+	if req.Channel == "" {
+		req.Channel = notification.Channel
+	}
+
+	// Notifications can only be sent by database users, which are trusted like
+	// API keys.
+	ctx := auth.WithAuthentication(context.Background(), &auth.Authentication{
+		Subject: "postgres",
+		Scope:   []string{auth.ScopePublish},
+		IsAdmin: true,
+	})
+
+	message, err := i.publishHandler.Handle(ctx, req)
+	if err != nil {
+		logger.Warn("failed to publish postgres notification",
+			zap.String("channelId", req.Channel),
+			zap.Error(err))
+
+		return
+	}
+
+	logger.Debug("published postgres notification",
+		zap.String("channelId", message.Channel),
+		zap.String("messageId", message.Id))
+}
@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPostgresIngester(t *testing.T) {
+	logger := zap.NewNop()
+
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(10, 0), 0)
+	publishHandler := handler.NewPublishHandler(handler.NewChannelValidator(), registry)
+	ingester := NewPostgresIngester(logger, nil, publishHandler, 100)
+
+	connection := &broadcaster.Connection{Id: "connection-1", Send: make(chan broadcaster.Message, 10)}
+	require.NoError(t, registry.Connect(connection))
+	require.NoError(t, registry.Subscribe("room:42", connection.Id, broadcaster.SubscribeOptions{}))
+	require.NoError(t, registry.Subscribe("events", connection.Id, broadcaster.SubscribeOptions{}))
+
+	receive := func() (broadcaster.Message, bool) {
+		select {
+		case message := <-connection.Send:
+			return message, true
+		case <-time.After(50 * time.Millisecond):
+			return broadcaster.Message{}, false
+		}
+	}
+
+	t.Run("publishes the notification payload", func(t *testing.T) {
+		ingester.handle(&pgconn.Notification{
+			Channel: "events",
+			Payload: `{"channel":"room:42","event":"update","payload":{"foo":"bar"}}`,
+		})
+
+		message, ok := receive()
+		require.True(t, ok)
+		assert.Equal(t, "room:42", message.Channel)
+		assert.Equal(t, "update", message.Event)
+		assert.Equal(t, map[string]any{"foo": "bar"}, message.Payload)
+	})
+
+	t.Run("defaults to the postgres channel", func(t *testing.T) {
+		ingester.handle(&pgconn.Notification{Channel: "events", Payload: `{"event":"update"}`})
+
+		message, ok := receive()
+		require.True(t, ok)
+		assert.Equal(t, "events", message.Channel)
+	})
+
+	t.Run("drops invalid notifications", func(t *testing.T) {
+		for _, payload := range []string{
+			`not json`,
+			`{"channel":"room:"}`,
+			`{"channel":"room:42","payload":"` + strings.Repeat("a", 100) + `"}`,
+		} {
+			ingester.handle(&pgconn.Notification{Channel: "events", Payload: payload})
+
+			_, ok := receive()
+			assert.False(t, ok, payload)
+		}
+	})
+}
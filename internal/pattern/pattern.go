@@ -0,0 +1,107 @@
+// Package pattern matches channel ids against subscription patterns.
+//
+// Channel ids are made of segments separated by colons. In a pattern, a `*`
+// segment matches exactly one segment and a trailing `**` segment matches one
+// or more segments, so `org:42:*` matches `org:42:room` and `org:42:**` also
+// matches `org:42:room:7`. Wildcards never match empty segments.
+package pattern
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+const (
+	Separator      = ":"
+	SingleWildcard = "*"
+	MultiWildcard  = "**"
+)
+
+var (
+	ErrEmptySegment      = errors.New("pattern has an empty segment")
+	ErrMisplacedWildcard = errors.New("** can only be the last segment of a pattern")
+)
+
+// Pattern is a parsed channel id or pattern.
+type Pattern []string
+
+// Parse splits the value into segments and checks that it is a well-formed
+// pattern.
+func Parse(value string) (Pattern, error) {
+	segments := strings.Split(value, Separator)
+
+	for i, segment := range segments {
+		if segment == "" {
+			return nil, ErrEmptySegment
+		}
+
+		if segment == MultiWildcard && i != len(segments)-1 {
+			return nil, ErrMisplacedWildcard
+		}
+	}
+
+	return Pattern(segments), nil
+}
+
+// IsSegmentValue reports whether the value can be used as a single literal
+// segment.
+func IsSegmentValue(value string) bool {
+	return value != "" &&
+		value != SingleWildcard &&
+		value != MultiWildcard &&
+		!strings.Contains(value, Separator)
+}
+
+// IsPattern reports whether the value has at least one wildcard segment.
+func IsPattern(value string) bool {
+	for _, segment := range strings.Split(value, Separator) {
+		if segment == SingleWildcard || segment == MultiWildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches reports whether the channel is matched by the pattern.
+func Matches(pattern string, channelId string) bool {
+	return Covers(pattern, channelId)
+}
+
+// Covers reports whether every channel matched by inner is also matched by
+// outer. Both may be channel ids or patterns.
+func Covers(outer string, inner string) bool {
+	return Pattern(strings.Split(outer, Separator)).Covers(strings.Split(inner, Separator))
+}
+
+// Covers reports whether every channel matched by inner is also matched by
+// the pattern.
+func (p Pattern) Covers(inner Pattern) bool {
+	outer := p
+
+	for {
+		if len(outer) == 0 {
+			return len(inner) == 0
+		}
+
+		if len(inner) == 0 || inner[0] == "" {
+			return false
+		}
+
+		if outer[0] == MultiWildcard {
+			return !slices.Contains(inner, "")
+		}
+
+		if inner[0] == MultiWildcard {
+			return false
+		}
+
+		if outer[0] != SingleWildcard && outer[0] != inner[0] {
+			return false
+		}
+
+		outer = outer[1:]
+		inner = inner[1:]
+	}
+}
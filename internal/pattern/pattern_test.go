@@ -0,0 +1,43 @@
+package pattern
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		outer  string
+		inner  string
+		covers bool
+	}{
+		{"org:42:room", "org:42:room", true},
+		{"org:42:room", "org:42:hall", false},
+		{"org:42:*", "org:42:room", true},
+		{"org:42:*", "org:42", false},
+		{"org:42:*", "org:42:room:7", false},
+		{"org:*:room", "org:42:room", true},
+		{"org:42:**", "org:42:room", true},
+		{"org:42:**", "org:42:room:7", true},
+		{"org:42:**", "org:42", false},
+		{"org:42:**", "org:42:*", true},
+		{"org:42:**", "org:42:*:7", true},
+		{"org:42:*", "org:42:*", true},
+		{"org:42:*", "org:42:**", false},
+		{"org:42:room", "org:42:*", false},
+		{"org:*", "org:*:*", false},
+		{"**", "org:42", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.covers, Covers(test.outer, test.inner), "%s covers %s", test.outer, test.inner)
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	assert.True(t, IsPattern("org:42:*"))
+	assert.True(t, IsPattern("org:**"))
+	assert.False(t, IsPattern("org:42"))
+	assert.False(t, IsPattern("org:4*2"))
+}
@@ -0,0 +1,113 @@
+package pglisten
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+// Listener receives the notifications of a set of Postgres channels on a
+// dedicated connection, reconnecting with an increasing interval when it is
+// lost. Notifications sent while disconnected are lost.
+type Listener struct {
+	logger     *zap.Logger
+	connConfig *pgx.ConnConfig
+	channels   []string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewListener(
+	logger *zap.Logger,
+	connConfig *pgx.ConnConfig,
+	channels []string,
+) *Listener {
+	return &Listener{
+		logger:     logger,
+		connConfig: connConfig,
+		channels:   channels,
+	}
+}
+
+func (l *Listener) Start(handler func(notification *pgconn.Notification)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	l.wg.Add(1)
+	go l.run(ctx, handler)
+}
+
+func (l *Listener) Close() {
+	if l.cancel != nil {
+		l.cancel()
+	}
+
+	l.wg.Wait()
+}
+
+func (l *Listener) run(ctx context.Context, handler func(notification *pgconn.Notification)) {
+	defer l.wg.Done()
+
+	retryInterval := minRetryInterval
+
+	for {
+		connected, err := l.listen(ctx, handler)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			retryInterval = minRetryInterval
+		}
+
+		l.logger.Warn("postgres listener disconnected",
+			zap.Strings("channels", l.channels),
+			zap.Duration("retryInterval", retryInterval),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+
+		retryInterval = min(2*retryInterval, maxRetryInterval)
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, handler func(notification *pgconn.Notification)) (bool, error) {
+	conn, err := pgx.ConnectConfig(ctx, l.connConfig)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	for _, channel := range l.channels {
+		_, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	l.logger.Info("listening to postgres notifications",
+		zap.Strings("channels", l.channels))
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+
+		handler(notification)
+	}
+}
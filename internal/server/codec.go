@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the requests, responses and notifications of a connection.
+// Binary encodings keep binary payloads as bytes instead of base64 strings.
+type Codec interface {
+	// Subprotocol is the WebSocket subprotocol selecting the encoding.
+	Subprotocol() string
+	ContentType() string
+	// FrameType is the type of the WebSocket messages holding the frames.
+	FrameType() int
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	DecodeRequest(data []byte) (rpcRequest, error)
+}
+
+// rpcRequest is a request whose params are decoded by the codec it was read
+// with.
+type rpcRequest struct {
+	Id     int
+	Method string
+	decode func(v any) error
+}
+
+// rpcNotification and rpcResponse mirror handler.Request and handler.Response,
+// with the params and result encoded along with them rather than as JSON.
+type rpcNotification struct {
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	RequestId int       `json:"requestId,omitempty"`
+	Result    any       `json:"result,omitempty"`
+	Error     *rpcError `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    ierr.ErrorCode `json:"code"`
+	Message string         `json:"message"`
+	Data    any            `json:"data,omitempty"`
+}
+
+func newRPCError(err ierr.Error) *rpcError {
+	rpcErr := &rpcError{
+		Code:    err.Code,
+		Message: err.Message,
+	}
+
+	if len(err.Data) > 0 {
+		_ = json.Unmarshal(err.Data, &rpcErr.Data)
+	}
+
+	return rpcErr
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	CBORCodec    Codec = newCBORCodec()
+)
+
+// WebSocketSubprotocols are the subprotocols selected by the server, in the
+// order of preference of the client. The plain subprotocol selects JSON.
+var WebSocketSubprotocols = []string{
+	WebSocketSubprotocol,
+	JSONCodec.Subprotocol(),
+	MsgpackCodec.Subprotocol(),
+	CBORCodec.Subprotocol(),
+	JSONRPCSubprotocol,
+}
+
+var codecs = []Codec{JSONCodec, MsgpackCodec, CBORCodec}
+
+// codecForSubprotocol returns the codec of the subprotocol selected at
+// upgrade, JSON unless a binary encoding was selected. JSON-RPC 2.0
+// connections are JSON too.
+func codecForSubprotocol(subprotocol string) Codec {
+	for _, codec := range codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+
+	return JSONCodec
+}
+
+// codecForContentType returns the codec of a media type, such as the
+// Content-Type of a request. Requests without a media type are JSON.
+func codecForContentType(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSONCodec, true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	switch mediaType {
+	case "application/json":
+		return JSONCodec, true
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return MsgpackCodec, true
+	case "application/cbor":
+		return CBORCodec, true
+	default:
+		return nil, false
+	}
+}
+
+// responseCodec returns the codec of the first supported media type of the
+// Accept header, or the fallback.
+func responseCodec(r *http.Request, fallback Codec) Codec {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted = strings.TrimSpace(accepted)
+		if accepted == "" || strings.HasPrefix(accepted, "*/*") {
+			continue
+		}
+
+		if codec, ok := codecForContentType(accepted); ok {
+			return codec
+		}
+	}
+
+	return fallback
+}
+
+func missingParamsError() error {
+	return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("missing params"))
+}
+
+func invalidParamsError(err error) error {
+	return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid params: "+err.Error()))
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string {
+	return WebSocketSubprotocol + ".json"
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) DecodeRequest(data []byte) (rpcRequest, error) {
+	var request handler.Request
+	err := json.Unmarshal(data, &request)
+	if err != nil {
+		return rpcRequest{}, err
+	}
+
+	return rpcRequest{
+		Id:     request.Id,
+		Method: request.Method,
+		decode: func(v any) error {
+			return decodeParams(request.Params, v)
+		},
+	}, nil
+}
+
+// msgpackCodec encodes structs with their JSON field names.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string {
+	return WebSocketSubprotocol + ".msgpack"
+}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+
+	err := encoder.Encode(v)
+
+	return buffer.Bytes(), err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+
+	return decoder.Decode(v)
+}
+
+func (c msgpackCodec) DecodeRequest(data []byte) (rpcRequest, error) {
+	var request struct {
+		Id     int                `json:"id"`
+		Method string             `json:"method"`
+		Params msgpack.RawMessage `json:"params"`
+	}
+
+	err := c.Unmarshal(data, &request)
+	if err != nil {
+		return rpcRequest{}, err
+	}
+
+	return rpcRequest{
+		Id:     request.Id,
+		Method: request.Method,
+		decode: func(v any) error {
+			// A single byte of nil stands for missing params.
+			if len(request.Params) == 0 || (len(request.Params) == 1 && request.Params[0] == msgpackNil) {
+				return missingParamsError()
+			}
+
+			err := c.Unmarshal(request.Params, v)
+			if err != nil {
+				return invalidParamsError(err)
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+const msgpackNil = 0xc0
+
+// cborCodec encodes structs with their JSON field names, and times as RFC 3339
+// strings.
+type cborCodec struct {
+	encMode cbor.EncMode
+	decMode cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	encMode, err := cbor.EncOptions{
+		Time:    cbor.TimeRFC3339Nano,
+		TimeTag: cbor.EncTagRequired,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	// Maps are decoded with string keys, so that payloads can be encoded in
+	// JSON for the other connections.
+	decMode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return cborCodec{encMode, decMode}
+}
+
+func (cborCodec) Subprotocol() string {
+	return WebSocketSubprotocol + ".cbor"
+}
+
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (c cborCodec) Marshal(v any) ([]byte, error) {
+	return c.encMode.Marshal(v)
+}
+
+func (c cborCodec) Unmarshal(data []byte, v any) error {
+	return c.decMode.Unmarshal(data, v)
+}
+
+func (c cborCodec) DecodeRequest(data []byte) (rpcRequest, error) {
+	var request struct {
+		Id     int             `json:"id"`
+		Method string          `json:"method"`
+		Params cbor.RawMessage `json:"params"`
+	}
+
+	err := c.Unmarshal(data, &request)
+	if err != nil {
+		return rpcRequest{}, err
+	}
+
+	return rpcRequest{
+		Id:     request.Id,
+		Method: request.Method,
+		decode: func(v any) error {
+			// A single byte of null or undefined stands for missing params.
+			if len(request.Params) == 0 || (len(request.Params) == 1 && (request.Params[0] == cborNull || request.Params[0] == cborUndefined)) {
+				return missingParamsError()
+			}
+
+			err := c.Unmarshal(request.Params, v)
+			if err != nil {
+				return invalidParamsError(err)
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+const (
+	cborNull      = 0xf6
+	cborUndefined = 0xf7
+)
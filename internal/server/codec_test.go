@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec_DecodeRequest(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			data, err := codec.Marshal(map[string]any{
+				"id":     7,
+				"method": "publish",
+				"params": map[string]any{"channel": "test-channel", "payload": map[string]any{"count": 1}},
+			})
+			assert.NoError(t, err)
+
+			request, err := codec.DecodeRequest(data)
+			assert.NoError(t, err)
+			assert.Equal(t, 7, request.Id)
+			assert.Equal(t, "publish", request.Method)
+
+			var publishRequest handler.PublishRequest
+			err = request.decode(&publishRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, "test-channel", publishRequest.Channel)
+			assert.Len(t, publishRequest.Payload, 1)
+
+			data, err = codec.Marshal(map[string]any{"id": 8, "method": "subscribe"})
+			assert.NoError(t, err)
+
+			request, err = codec.DecodeRequest(data)
+			assert.NoError(t, err)
+
+			err = request.decode(&handler.SubscribeRequest{})
+			if assert.Error(t, err) {
+				assert.Equal(t, ierr.ErrorCodeInvalidArgument, err.(ierr.Error).Code)
+			}
+		})
+	}
+}
+
+func TestCodec_Message(t *testing.T) {
+	message := broadcaster.Message{
+		Id:         "msg-1",
+		Offset:     42,
+		CreateTime: time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.UTC),
+		Channel:    "test-channel",
+		Payload:    []byte{0x00, 0xff},
+	}
+
+	for _, codec := range []Codec{MsgpackCodec, CBORCodec} {
+		data, err := codec.Marshal(message)
+		assert.NoError(t, err)
+
+		var decoded broadcaster.Message
+		err = codec.Unmarshal(data, &decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, message.Offset, decoded.Offset)
+		assert.True(t, message.CreateTime.Equal(decoded.CreateTime), codec.ContentType())
+		assert.Equal(t, message.Payload, decoded.Payload)
+	}
+}
+
+func TestCodec_Negotiation(t *testing.T) {
+	assert.Equal(t, JSONCodec, codecForSubprotocol(WebSocketSubprotocol))
+	assert.Equal(t, JSONCodec, codecForSubprotocol(""))
+	assert.Equal(t, MsgpackCodec, codecForSubprotocol("broadcaster.msgpack"))
+	assert.Equal(t, CBORCodec, codecForSubprotocol("broadcaster.cbor"))
+
+	for contentType, expected := range map[string]Codec{
+		"":                                JSONCodec,
+		"application/json; charset=utf-8": JSONCodec,
+		"application/x-msgpack":           MsgpackCodec,
+		"application/cbor":                CBORCodec,
+	} {
+		codec, ok := codecForContentType(contentType)
+		assert.True(t, ok, contentType)
+		assert.Equal(t, expected, codec, contentType)
+	}
+
+	_, ok := codecForContentType("text/plain")
+	assert.False(t, ok)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	assert.Equal(t, CBORCodec, responseCodec(r, CBORCodec))
+
+	r.Header.Set("Accept", "*/*, application/msgpack")
+	assert.Equal(t, MsgpackCodec, responseCodec(r, JSONCodec))
+
+	r.Header.Set("Accept", "text/html")
+	assert.Equal(t, JSONCodec, responseCodec(r, JSONCodec))
+}
@@ -0,0 +1,62 @@
+package server
+
+import "time"
+
+type tokenExpiringParams struct {
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// tokenExpiry schedules the warning sent ahead of the expiry of a connection's
+// token and the expiry itself. Its channels are nil, and never ready, while no
+// token with an expiry is set.
+type tokenExpiry struct {
+	warning      time.Duration
+	warningTimer *time.Timer
+	expireTimer  *time.Timer
+}
+
+func newTokenExpiry(warning time.Duration) *tokenExpiry {
+	return &tokenExpiry{
+		warning: warning,
+	}
+}
+
+// reset replaces the schedule of the previous token.
+func (e *tokenExpiry) reset(expireTime time.Time) {
+	e.stop()
+
+	if expireTime.IsZero() {
+		return
+	}
+
+	e.warningTimer = time.NewTimer(time.Until(expireTime.Add(-e.warning)))
+	e.expireTimer = time.NewTimer(time.Until(expireTime))
+}
+
+func (e *tokenExpiry) stop() {
+	if e.warningTimer != nil {
+		e.warningTimer.Stop()
+		e.warningTimer = nil
+	}
+
+	if e.expireTimer != nil {
+		e.expireTimer.Stop()
+		e.expireTimer = nil
+	}
+}
+
+func (e *tokenExpiry) warningC() <-chan time.Time {
+	if e.warningTimer == nil {
+		return nil
+	}
+
+	return e.warningTimer.C
+}
+
+func (e *tokenExpiry) expireC() <-chan time.Time {
+	if e.expireTimer == nil {
+		return nil
+	}
+
+	return e.expireTimer.C
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	broadcasterv1 "github.com/goevery/broadcaster/api/broadcaster/v1"
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const maxPublishBatchSize = 100
+
+// GRPCServer serves the gRPC API of backend services, authenticated with API
+// keys like the REST API.
+type GRPCServer struct {
+	broadcasterv1.UnimplementedBroadcasterServer
+
+	logger           *zap.Logger
+	registry         broadcaster.Registry
+	publishHandler   handler.PublishHandlerInterface
+	subscribeHandler handler.SubscribeHandlerInterface
+	authenticator    *auth.Authenticator
+
+	server *grpc.Server
+	// closing ends the subscriptions, which would otherwise hold the server
+	// open when it is stopped.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewGRPCServer(
+	logger *zap.Logger,
+	registry broadcaster.Registry,
+	publishHandler handler.PublishHandlerInterface,
+	subscribeHandler handler.SubscribeHandlerInterface,
+	authenticator *auth.Authenticator,
+) *GRPCServer {
+	s := &GRPCServer{
+		logger:           logger,
+		registry:         registry,
+		publishHandler:   publishHandler,
+		subscribeHandler: subscribeHandler,
+		authenticator:    authenticator,
+		closing:          make(chan struct{}),
+	}
+
+	s.server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryAuthentication),
+		grpc.ChainStreamInterceptor(s.streamAuthentication),
+	)
+	broadcasterv1.RegisterBroadcasterServer(s.server, s)
+
+	return s
+}
+
+// Serve accepts connections on the listener until the server is stopped.
+func (s *GRPCServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown ends the subscriptions and waits for the other calls to complete,
+// until the context is done.
+func (s *GRPCServer) Shutdown(ctx context.Context) {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+// authenticate reads the API key of the `authorization` metadata.
+func (s *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	authentication, err := s.authenticator.AuthenticateAPIKey(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		return nil, grpcStatusFromError(err)
+	}
+
+	return auth.WithAuthentication(ctx, authentication), nil
+}
+
+func (s *GRPCServer) unaryAuthentication(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	next grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return next(ctx, req)
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *GRPCServer) streamAuthentication(
+	srv any,
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	next grpc.StreamHandler,
+) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	return next(srv, &authenticatedStream{stream, ctx})
+}
+
+// requireScope rejects the calls whose API key lacks the scope.
+func (s *GRPCServer) requireScope(ctx context.Context, scope string) error {
+	authentication, ok := auth.AuthenticationFromContext(ctx)
+	if !ok || !authentication.HasScope(scope) {
+		return status.Error(codes.PermissionDenied, "api key not allowed to "+scope)
+	}
+
+	return nil
+}
+
+// requestLogger identifies the API key behind a call in the logs.
+func (s *GRPCServer) requestLogger(ctx context.Context) *zap.Logger {
+	authentication, ok := auth.AuthenticationFromContext(ctx)
+	if !ok {
+		return s.logger
+	}
+
+	return s.logger.With(zap.String("apiKey", authentication.Subject))
+}
+
+func (s *GRPCServer) Publish(ctx context.Context, req *broadcasterv1.PublishRequest) (*broadcasterv1.Message, error) {
+	err := s.requireScope(ctx, auth.ScopePublish)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.publish(ctx, req)
+}
+
+func (s *GRPCServer) PublishBatch(ctx context.Context, req *broadcasterv1.PublishBatchRequest) (*broadcasterv1.PublishBatchResponse, error) {
+	err := s.requireScope(ctx, auth.ScopePublish)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Requests) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one message is required")
+	}
+
+	if len(req.Requests) > maxPublishBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d messages can be published at once", maxPublishBatchSize)
+	}
+
+	messages := make([]*broadcasterv1.Message, 0, len(req.Requests))
+	for _, publishRequest := range req.Requests {
+		message, err := s.publish(ctx, publishRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return &broadcasterv1.PublishBatchResponse{Messages: messages}, nil
+}
+
+func (s *GRPCServer) publish(ctx context.Context, req *broadcasterv1.PublishRequest) (*broadcasterv1.Message, error) {
+	message, err := s.publishHandler.Handle(ctx, handler.PublishRequest{
+		Channel: req.Channel,
+		Event:   req.Event,
+		Payload: payloadFromProto(req),
+	})
+	if err != nil {
+		s.requestLogger(ctx).Error("failed to handle publish request", zap.Error(err))
+		return nil, grpcStatusFromError(err)
+	}
+
+	return messageToProto(message)
+}
+
+func (s *GRPCServer) Subscribe(req *broadcasterv1.SubscribeRequest, stream grpc.ServerStreamingServer[broadcasterv1.Message]) error {
+	ctx := stream.Context()
+
+	err := s.requireScope(ctx, auth.ScopeSubscribe)
+	if err != nil {
+		return err
+	}
+
+	if len(req.Channels) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one channel is required")
+	}
+
+	authentication, _ := auth.AuthenticationFromContext(ctx)
+
+	connectionId := gonanoid.Must()
+	broadcasterChannel := make(chan broadcaster.Message, 1024)
+
+	broadcasterConn := &broadcaster.Connection{
+		Id:   connectionId,
+		Send: broadcasterChannel,
+		Seq:  0,
+	}
+	broadcasterConn.SetAuthentication(authentication)
+
+	s.registry.Connect(broadcasterConn)
+	defer s.registry.Disconnect(connectionId)
+
+	logger := s.requestLogger(ctx).With(zap.String("connectionId", connectionId))
+
+	for _, channel := range req.Channels {
+		subscribeRequest := handler.SubscribeRequest{
+			Channel: channel,
+		}
+
+		if offset, ok := req.AfterOffsets[channel]; ok {
+			subscribeRequest.History = &broadcaster.HistoryQuery{
+				AfterOffset: &offset,
+			}
+		}
+
+		_, err := s.subscribeHandler.Handle(broadcaster.WithConnection(ctx, broadcasterConn), subscribeRequest)
+		if err != nil {
+			logger.Info("failed to subscribe grpc stream",
+				zap.String("channel", channel), zap.Error(err))
+
+			return grpcStatusFromError(err)
+		}
+	}
+
+	// Sends the headers, so that clients know the subscriptions are made.
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case message, ok := <-broadcasterChannel:
+			if !ok {
+				if reason, ok := broadcasterConn.CloseReason(); ok {
+					return status.Error(codes.Unauthenticated, reason.Text)
+				}
+
+				return status.Error(codes.Unavailable, "subscription closed")
+			}
+
+			protoMessage, err := messageToProto(message)
+			if err != nil {
+				logger.Error("failed to convert message", zap.Error(err))
+
+				return err
+			}
+
+			err = stream.Send(protoMessage)
+			if err != nil {
+				return err
+			}
+		case <-s.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-ctx.Done():
+			logger.Info("grpc stream closed")
+
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func payloadFromProto(req *broadcasterv1.PublishRequest) any {
+	switch payload := req.Payload.(type) {
+	case *broadcasterv1.PublishRequest_Json:
+		return payload.Json.AsInterface()
+	case *broadcasterv1.PublishRequest_Binary:
+		return payload.Binary
+	default:
+		return nil
+	}
+}
+
+func messageToProto(message broadcaster.Message) (*broadcasterv1.Message, error) {
+	protoMessage := &broadcasterv1.Message{
+		Id:         message.Id,
+		Seq:        message.Seq,
+		Offset:     message.Offset,
+		CreateTime: timestamppb.New(message.CreateTime),
+		Channel:    message.Channel,
+		Event:      message.Event,
+	}
+
+	if binary, ok := message.Payload.([]byte); ok {
+		protoMessage.Payload = &broadcasterv1.Message_Binary{Binary: binary}
+
+		return protoMessage, nil
+	}
+
+	value, err := structpb.NewValue(message.Payload)
+	if err != nil {
+		// Payloads decoded from other encodings may hold types that values
+		// cannot, so they are converted through JSON.
+		value, err = jsonValue(message.Payload)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to convert message payload")
+		}
+	}
+
+	protoMessage.Payload = &broadcasterv1.Message_Json{Json: value}
+
+	return protoMessage, nil
+}
+
+func jsonValue(v any) (*structpb.Value, error) {
+	rawJson, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	value := &structpb.Value{}
+	err = value.UnmarshalJSON(rawJson)
+
+	return value, err
+}
+
+func grpcStatusFromError(err error) error {
+	var handlerErr ierr.Error
+	if !errors.As(err, &handlerErr) {
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	var code codes.Code
+	switch handlerErr.Code {
+	case ierr.ErrorCodeInvalidArgument:
+		code = codes.InvalidArgument
+	case ierr.ErrorCodeNotFound:
+		code = codes.NotFound
+	case ierr.ErrorCodeAlreadyExists:
+		code = codes.AlreadyExists
+	case ierr.ErrorCodeFailedPrecondition:
+		code = codes.FailedPrecondition
+	case ierr.ErrorCodePermissionDenied:
+		code = codes.PermissionDenied
+	case ierr.ErrorCodeUnauthenticated:
+		code = codes.Unauthenticated
+	default:
+		code = codes.Internal
+	}
+
+	return status.Error(code, handlerErr.Message)
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	broadcasterv1 "github.com/goevery/broadcaster/api/broadcaster/v1"
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGRPCServer(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	apiKeys := append(testAPIKeys, auth.APIKey{
+		Name:     "consumer",
+		Hash:     auth.HashAPIKey("consumer-api-key"),
+		Scope:    []string{auth.ScopeSubscribe, auth.ScopeHistory},
+		Channels: []string{"consumer:*"},
+	}, auth.APIKey{
+		Name:     "listener",
+		Hash:     auth.HashAPIKey("listener-api-key"),
+		Scope:    []string{auth.ScopeSubscribe},
+		Channels: []string{"consumer:*"},
+	})
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", apiKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+
+	grpcServer := NewGRPCServer(
+		logger,
+		registry,
+		handler.NewPublishHandler(channelValidator, registry),
+		handler.NewSubscribeHandler(channelValidator, registry, 100),
+		authenticator,
+	)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Shutdown(context.Background())
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	client := broadcasterv1.NewBroadcasterClient(conn)
+
+	withAPIKey := func(apiKey string) context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		t.Cleanup(cancel)
+
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+apiKey)
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		payload, _ := structpb.NewValue(map[string]any{"count": 1})
+
+		message, err := client.Publish(withAPIKey("test-api-key"), &broadcasterv1.PublishRequest{
+			Channel: "test-channel",
+			Event:   "update",
+			Payload: &broadcasterv1.PublishRequest_Json{Json: payload},
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, message.Id)
+		assert.Equal(t, "test-channel", message.Channel)
+		assert.Equal(t, map[string]any{"count": float64(1)}, message.GetJson().AsInterface())
+	})
+
+	t.Run("publish binary", func(t *testing.T) {
+		message, err := client.Publish(withAPIKey("test-api-key"), &broadcasterv1.PublishRequest{
+			Channel: "test-channel",
+			Payload: &broadcasterv1.PublishRequest_Binary{Binary: []byte{0x00, 0xff}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x00, 0xff}, message.GetBinary())
+	})
+
+	t.Run("missing api key", func(t *testing.T) {
+		_, err := client.Publish(context.Background(), &broadcasterv1.PublishRequest{Channel: "test-channel"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("invalid api key", func(t *testing.T) {
+		_, err := client.Publish(withAPIKey("invalid"), &broadcasterv1.PublishRequest{Channel: "test-channel"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unauthorized channel", func(t *testing.T) {
+		_, err := client.Publish(withAPIKey("partner-api-key"), &broadcasterv1.PublishRequest{Channel: "test-channel"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("publish batch", func(t *testing.T) {
+		response, err := client.PublishBatch(withAPIKey("test-api-key"), &broadcasterv1.PublishBatchRequest{
+			Requests: []*broadcasterv1.PublishRequest{
+				{Channel: "batch-channel", Event: "first"},
+				{Channel: "batch-channel", Event: "second"},
+			},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, response.Messages, 2) {
+			assert.Equal(t, uint64(1), response.Messages[0].Offset)
+			assert.Equal(t, uint64(2), response.Messages[1].Offset)
+		}
+
+		_, err = client.PublishBatch(withAPIKey("test-api-key"), &broadcasterv1.PublishBatchRequest{
+			Requests: []*broadcasterv1.PublishRequest{
+				{Channel: "batch-channel"},
+				{Channel: "batch-channel", Event: "presence.join"},
+			},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		stream, err := client.Subscribe(withAPIKey("consumer-api-key"), &broadcasterv1.SubscribeRequest{
+			Channels: []string{"consumer:a"},
+		})
+		assert.NoError(t, err)
+
+		// The subscriptions are made once the headers are received.
+		_, err = stream.Header()
+		assert.NoError(t, err)
+
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "consumer:a", Payload: "hello"})
+
+		message, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, "msg-1", message.Id)
+		assert.Equal(t, "hello", message.GetJson().GetStringValue())
+	})
+
+	t.Run("subscribe after offset", func(t *testing.T) {
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "consumer:resume"})
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-2", Channel: "consumer:resume"})
+
+		stream, err := client.Subscribe(withAPIKey("consumer-api-key"), &broadcasterv1.SubscribeRequest{
+			Channels:     []string{"consumer:resume"},
+			AfterOffsets: map[string]uint64{"consumer:resume": 1},
+		})
+		assert.NoError(t, err)
+
+		message, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, "msg-2", message.Id)
+
+		stream, err = client.Subscribe(withAPIKey("consumer-api-key"), &broadcasterv1.SubscribeRequest{
+			Channels:     []string{"consumer:gone"},
+			AfterOffsets: map[string]uint64{"consumer:gone": 41},
+		})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("subscribe after offset without history scope", func(t *testing.T) {
+		stream, err := client.Subscribe(withAPIKey("listener-api-key"), &broadcasterv1.SubscribeRequest{
+			Channels:     []string{"consumer:resume"},
+			AfterOffsets: map[string]uint64{"consumer:resume": 1},
+		})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("subscribe without scope", func(t *testing.T) {
+		stream, err := client.Subscribe(withAPIKey("partner-api-key"), &broadcasterv1.SubscribeRequest{
+			Channels: []string{"partner:a"},
+		})
+		assert.NoError(t, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func TestGRPCStatusFromError(t *testing.T) {
+	for code, expected := range map[ierr.ErrorCode]codes.Code{
+		ierr.ErrorCodeInvalidArgument:    codes.InvalidArgument,
+		ierr.ErrorCodeNotFound:           codes.NotFound,
+		ierr.ErrorCodeAlreadyExists:      codes.AlreadyExists,
+		ierr.ErrorCodeFailedPrecondition: codes.FailedPrecondition,
+		ierr.ErrorCodePermissionDenied:   codes.PermissionDenied,
+		ierr.ErrorCodeUnauthenticated:    codes.Unauthenticated,
+		ierr.ErrorCodeInternal:           codes.Internal,
+	} {
+		err := grpcStatusFromError(ierr.New(code, assert.AnError))
+		assert.Equal(t, expected, status.Code(err), code)
+		assert.Equal(t, assert.AnError.Error(), status.Convert(err).Message())
+	}
+
+	assert.Equal(t, codes.Internal, status.Code(grpcStatusFromError(assert.AnError)))
+}
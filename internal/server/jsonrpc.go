@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+)
+
+// JSONRPCSubprotocol selects the strict JSON-RPC 2.0 format, with string or
+// number ids, standard error codes and batches, instead of the default format.
+const JSONRPCSubprotocol = WebSocketSubprotocol + ".jsonrpc"
+
+const jsonRPCVersion = "2.0"
+
+// Error codes of the JSON-RPC 2.0 specification, and of the server errors
+// mapped from the other error codes.
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+
+	jsonRPCUnauthenticated    = -32001
+	jsonRPCPermissionDenied   = -32002
+	jsonRPCNotFound           = -32003
+	jsonRPCAlreadyExists      = -32004
+	jsonRPCFailedPrecondition = -32005
+)
+
+var jsonRPCNullId = json.RawMessage("null")
+
+type jsonRPCRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	// Id is nil for notifications, which are not replied to.
+	Id     json.RawMessage  `json:"id"`
+	Method string           `json:"method"`
+	Params *json.RawMessage `json:"params"`
+}
+
+type jsonRPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	Id      json.RawMessage  `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError    `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *jsonRPCErrorData `json:"data,omitempty"`
+}
+
+// jsonRPCErrorData keeps the error code of the default format, and the data
+// of the error if any.
+type jsonRPCErrorData struct {
+	Code    ierr.ErrorCode  `json:"code"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// RouteJSONRPC routes a JSON-RPC 2.0 request, or a batch of requests in
+// order, with RouteRequest. It returns the response, the array of responses
+// of a batch, or nil when no reply is expected.
+func (r *Router) RouteJSONRPC(ctx context.Context, data []byte) any {
+	data = bytes.TrimSpace(data)
+
+	if len(data) == 0 || data[0] != '[' {
+		var rawRequest json.RawMessage
+		if err := json.Unmarshal(data, &rawRequest); err != nil {
+			return newJSONRPCErrorResponse(jsonRPCNullId, jsonRPCParseError, "parse error")
+		}
+
+		// A nil response is returned as such rather than as a typed nil.
+		if response := r.routeJSONRPCRequest(ctx, rawRequest); response != nil {
+			return response
+		}
+
+		return nil
+	}
+
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(data, &rawRequests); err != nil {
+		return newJSONRPCErrorResponse(jsonRPCNullId, jsonRPCParseError, "parse error")
+	}
+
+	if len(rawRequests) == 0 {
+		return newJSONRPCErrorResponse(jsonRPCNullId, jsonRPCInvalidRequest, "empty batch")
+	}
+
+	var responses []*jsonRPCResponse
+	for _, rawRequest := range rawRequests {
+		if response := r.routeJSONRPCRequest(ctx, rawRequest); response != nil {
+			responses = append(responses, response)
+		}
+	}
+
+	// A batch of notifications is not replied to either.
+	if len(responses) == 0 {
+		return nil
+	}
+
+	return responses
+}
+
+func (r *Router) routeJSONRPCRequest(ctx context.Context, rawRequest json.RawMessage) *jsonRPCResponse {
+	var request jsonRPCRequest
+	if err := json.Unmarshal(rawRequest, &request); err != nil {
+		return newJSONRPCErrorResponse(jsonRPCNullId, jsonRPCInvalidRequest, "invalid request")
+	}
+
+	if !isJSONRPCId(request.Id) {
+		return newJSONRPCErrorResponse(jsonRPCNullId, jsonRPCInvalidRequest, "id must be a string, a number or null")
+	}
+
+	id := request.Id
+	if id == nil {
+		id = jsonRPCNullId
+	}
+
+	if request.JSONRPC != jsonRPCVersion {
+		return newJSONRPCErrorResponse(id, jsonRPCInvalidRequest, `jsonrpc must be "2.0"`)
+	}
+
+	if request.Method == "" {
+		return newJSONRPCErrorResponse(id, jsonRPCInvalidRequest, "method is required")
+	}
+
+	// Notifications are not replied to, whatever their method returns.
+	if request.Id == nil {
+		_, err := r.Handle(ctx, request.Method, func(v any) error {
+			return decodeParams(request.Params, v)
+		})
+		if err != nil {
+			// Only logs the unexpected errors.
+			_ = r.mapError(err)
+		}
+
+		return nil
+	}
+
+	// The ids of the default format are numbers, so the request is routed with
+	// a placeholder id and its response is given the original one.
+	response := r.RouteRequest(ctx, handler.Request{
+		Id:     1,
+		Method: request.Method,
+		Params: request.Params,
+	})
+	if response == nil {
+		return nil
+	}
+
+	if response.Error != nil {
+		return &jsonRPCResponse{
+			JSONRPC: jsonRPCVersion,
+			Id:      id,
+			Error:   newJSONRPCError(*response.Error),
+		}
+	}
+
+	return &jsonRPCResponse{
+		JSONRPC: jsonRPCVersion,
+		Id:      id,
+		Result:  response.Result,
+	}
+}
+
+// isJSONRPCId reports whether the id is missing, a string, a number or null.
+func isJSONRPCId(id json.RawMessage) bool {
+	if id == nil || bytes.Equal(id, jsonRPCNullId) {
+		return true
+	}
+
+	switch c := id[0]; {
+	case c == '"':
+		return true
+	case c == '-' || (c >= '0' && c <= '9'):
+		return true
+	default:
+		return false
+	}
+}
+
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	return &jsonRPCResponse{
+		JSONRPC: jsonRPCVersion,
+		Id:      id,
+		Error: &jsonRPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+func newJSONRPCError(err ierr.Error) *jsonRPCError {
+	return &jsonRPCError{
+		Code:    jsonRPCCode(err),
+		Message: err.Message,
+		Data: &jsonRPCErrorData{
+			Code:    err.Code,
+			Details: err.Data,
+		},
+	}
+}
+
+func jsonRPCCode(err ierr.Error) int {
+	if errors.Is(err, errMethodNotFound) {
+		return jsonRPCMethodNotFound
+	}
+
+	switch err.Code {
+	case ierr.ErrorCodeInvalidArgument:
+		return jsonRPCInvalidParams
+	case ierr.ErrorCodeUnauthenticated:
+		return jsonRPCUnauthenticated
+	case ierr.ErrorCodePermissionDenied:
+		return jsonRPCPermissionDenied
+	case ierr.ErrorCodeNotFound:
+		return jsonRPCNotFound
+	case ierr.ErrorCodeAlreadyExists:
+		return jsonRPCAlreadyExists
+	case ierr.ErrorCodeFailedPrecondition:
+		return jsonRPCFailedPrecondition
+	default:
+		return jsonRPCInternalError
+	}
+}
+
+// jsonRPCMessage converts the notifications sent to a JSON-RPC 2.0 connection,
+// whose responses are already in that format.
+func jsonRPCMessage(message any) any {
+	notification, ok := message.(rpcNotification)
+	if !ok {
+		return message
+	}
+
+	return jsonRPCNotification{
+		JSONRPC: jsonRPCVersion,
+		Method:  notification.Method,
+		Params:  notification.Params,
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRouter_RouteJSONRPC(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(core)
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+
+	router := NewRouter(
+		logger,
+		handler.NewHeartbeatHandler(),
+		handler.NewSubscribeHandler(channelValidator, registry, 100),
+		handler.NewUnsubscribeHandler(channelValidator, registry),
+		handler.NewPublishHandler(channelValidator, registry),
+		handler.NewAuthHandler(authenticator),
+		handler.NewResumeHandler(channelValidator, registry),
+		handler.NewPresenceHandler(channelValidator, registry),
+		handler.NewRefreshHandler(authenticator, registry),
+	)
+
+	connection := &broadcaster.Connection{
+		Id:   "jsonrpc-connection",
+		Send: make(chan broadcaster.Message, 10),
+	}
+	connection.SetAuthentication(&auth.Authentication{
+		Subject:            "jsonrpc-user",
+		Scope:              []string{auth.ScopeSubscribe},
+		AuthorizedChannels: []string{"allowed"},
+	})
+	registry.Connect(connection)
+	defer registry.Disconnect(connection.Id)
+
+	ctx := broadcaster.WithConnection(context.Background(), connection)
+
+	route := func(t *testing.T, request string) string {
+		t.Helper()
+
+		response := router.RouteJSONRPC(ctx, []byte(request))
+		if response == nil {
+			return ""
+		}
+
+		rawJson, err := json.Marshal(response)
+		assert.NoError(t, err)
+
+		return string(rawJson)
+	}
+
+	t.Run("ids", func(t *testing.T) {
+		for _, id := range []string{`"abc"`, `42`, `null`} {
+			var response jsonRPCResponse
+			err := json.Unmarshal([]byte(route(t, `{"jsonrpc":"2.0","id":`+id+`,"method":"heartbeat"}`)), &response)
+			assert.NoError(t, err)
+			assert.Equal(t, "2.0", response.JSONRPC)
+			assert.JSONEq(t, id, string(response.Id))
+			assert.Nil(t, response.Error)
+			assert.NotNil(t, response.Result)
+		}
+	})
+
+	t.Run("notification", func(t *testing.T) {
+		assert.Empty(t, route(t, `{"jsonrpc":"2.0","method":"heartbeat"}`))
+		assert.Empty(t, route(t, `{"jsonrpc":"2.0","method":"unknown"}`))
+		assert.Empty(t, route(t, `[{"jsonrpc":"2.0","method":"heartbeat"}]`))
+		assert.Empty(t, route(t, `{"jsonrpc":"2.0","method":"subscribe","params":{"channel":"denied"}}`))
+
+		assert.Zero(t, logs.FilterLevelExact(zap.ErrorLevel).Len())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for request, expected := range map[string]string{
+			`{"jsonrpc":"2.0","id":1,"method":"subscribe","params":{"channel":"allowed"`: `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`,
+			`[]`:                            `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch"}}`,
+			`{"id":1,"method":"heartbeat"}`: `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"jsonrpc must be \"2.0\""}}`,
+			`{"jsonrpc":"2.0","id":{},"method":"heartbeat"}`:                              `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"id must be a string, a number or null"}}`,
+			`{"jsonrpc":"2.0","id":1,"method":"unknown"}`:                                 `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found: unknown","data":{"code":"NotFound"}}}`,
+			`{"jsonrpc":"2.0","id":1,"method":"subscribe"}`:                               `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"missing params","data":{"code":"InvalidArgument"}}}`,
+			`{"jsonrpc":"2.0","id":1,"method":"subscribe","params":["allowed"]}`:          `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"invalid params: json: cannot unmarshal array into Go value of type handler.SubscribeRequest","data":{"code":"InvalidArgument"}}}`,
+			`{"jsonrpc":"2.0","id":1,"method":"subscribe","params":{"channel":"denied"}}`: `{"jsonrpc":"2.0","id":1,"error":{"code":-32001,"message":"user not authorized to access this channel","data":{"code":"Unauthenticated"}}}`,
+		} {
+			assert.JSONEq(t, expected, route(t, request), request)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		response := route(t, `[
+			{"jsonrpc":"2.0","id":1,"method":"subscribe","params":{"channel":"allowed"}},
+			{"jsonrpc":"2.0","method":"heartbeat"},
+			1,
+			{"jsonrpc":"2.0","id":"again","method":"subscribe","params":{"channel":"allowed"}}
+		]`)
+
+		var responses []jsonRPCResponse
+		err := json.Unmarshal([]byte(response), &responses)
+		assert.NoError(t, err)
+
+		if assert.Len(t, responses, 3) {
+			assert.JSONEq(t, `1`, string(responses[0].Id))
+			assert.Nil(t, responses[0].Error)
+
+			assert.JSONEq(t, `null`, string(responses[1].Id))
+			assert.Equal(t, jsonRPCInvalidRequest, responses[1].Error.Code)
+
+			// Requests are routed in order, so the second subscription fails.
+			assert.JSONEq(t, `"again"`, string(responses[2].Id))
+			if assert.NotNil(t, responses[2].Error) {
+				assert.Equal(t, jsonRPCInternalError, responses[2].Error.Code)
+			}
+		}
+	})
+}
+
+func TestJSONRPCCode(t *testing.T) {
+	for code, expected := range map[ierr.ErrorCode]int{
+		ierr.ErrorCodeInvalidArgument:    jsonRPCInvalidParams,
+		ierr.ErrorCodeUnauthenticated:    jsonRPCUnauthenticated,
+		ierr.ErrorCodePermissionDenied:   jsonRPCPermissionDenied,
+		ierr.ErrorCodeNotFound:           jsonRPCNotFound,
+		ierr.ErrorCodeAlreadyExists:      jsonRPCAlreadyExists,
+		ierr.ErrorCodeFailedPrecondition: jsonRPCFailedPrecondition,
+		ierr.ErrorCodeInternal:           jsonRPCInternalError,
+	} {
+		assert.Equal(t, expected, jsonRPCCode(ierr.New(code, assert.AnError)), code)
+	}
+}
@@ -0,0 +1,479 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/gorilla/mux"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.uber.org/zap"
+)
+
+// longPollMaxQueue is the number of items a session queues before it stops
+// taking messages from its connection, which is then handled as any slow
+// connection by the registry.
+const longPollMaxQueue = 1024
+
+type longPollSessionResponse struct {
+	SessionId string `json:"sessionId"`
+}
+
+type longPollCloseResponse struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// longPollSession queues the responses and notifications of a connection
+// until the client polls them.
+type longPollSession struct {
+	id         string
+	connection *broadcaster.Connection
+
+	mu       sync.Mutex
+	queue    []any
+	closed   bool
+	pollers  int
+	lastPoll time.Time
+
+	// ready is signalled when items are queued or the session is closed.
+	ready chan struct{}
+	// drained is signalled when a poll empties the queue.
+	drained chan struct{}
+	// done is closed when the session is removed.
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newLongPollSession(connection *broadcaster.Connection) *longPollSession {
+	return &longPollSession{
+		id:         gonanoid.Must(),
+		connection: connection,
+		queue:      []any{},
+		lastPoll:   time.Now(),
+		ready:      make(chan struct{}, 1),
+		drained:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+func (s *longPollSession) enqueue(item any) {
+	s.mu.Lock()
+	s.queue = append(s.queue, item)
+	s.mu.Unlock()
+
+	signal(s.ready)
+}
+
+func (s *longPollSession) isFull() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue) >= longPollMaxQueue
+}
+
+func (s *longPollSession) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	signal(s.ready)
+}
+
+// isIdle reports whether the session has not been polled for the ttl.
+func (s *longPollSession) isIdle(ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pollers == 0 && time.Since(s.lastPoll) > ttl
+}
+
+// poll waits for queued items until the timeout. It reports whether the
+// session is closed once no item is left.
+func (s *longPollSession) poll(ctx context.Context, timeout time.Duration) ([]any, bool) {
+	s.mu.Lock()
+	s.pollers++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.pollers--
+		s.lastPoll = time.Now()
+		s.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		items, closed := s.queue, s.closed
+		if len(items) > 0 {
+			s.queue = []any{}
+		}
+		s.mu.Unlock()
+
+		if len(items) > 0 {
+			signal(s.drained)
+
+			return items, false
+		}
+
+		if closed {
+			return nil, true
+		}
+
+		select {
+		case <-s.ready:
+		case <-timer.C:
+			return []any{}, false
+		case <-ctx.Done():
+			return []any{}, false
+		case <-s.done:
+			return nil, true
+		}
+	}
+}
+
+func (s *longPollSession) remove() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// signal wakes up the receiver of a channel with a buffer of one, if it is not
+// already signalled.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// LongPollServer exposes the RPC methods of the WebSocket protocol over plain
+// HTTP requests, for the clients that cannot keep a WebSocket open.
+type LongPollServer struct {
+	logger        *zap.Logger
+	registry      broadcaster.Registry
+	router        *Router
+	authenticator *auth.Authenticator
+	originChecker *OriginChecker
+	// tokenExpiryWarning is how long before its token expires a session is
+	// asked to refresh it.
+	tokenExpiryWarning time.Duration
+	// authDeadline is how long a session may stay unauthenticated. Zero
+	// disables the deadline.
+	authDeadline time.Duration
+	// authCookie is the name of the cookie holding the token when a session
+	// is created. Cookies are ignored when it is empty.
+	authCookie string
+	// pollTimeout is how long a poll waits for an item before returning an
+	// empty list.
+	pollTimeout time.Duration
+	// sessionTTL is how long a session is kept without being polled.
+	sessionTTL time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*longPollSession
+}
+
+func NewLongPollServer(
+	logger *zap.Logger,
+	registry broadcaster.Registry,
+	router *Router,
+	authenticator *auth.Authenticator,
+	originChecker *OriginChecker,
+	tokenExpiryWarning time.Duration,
+	authDeadline time.Duration,
+	authCookie string,
+	pollTimeout time.Duration,
+	sessionTTL time.Duration,
+) *LongPollServer {
+	return &LongPollServer{
+		logger:             logger,
+		registry:           registry,
+		router:             router,
+		authenticator:      authenticator,
+		originChecker:      originChecker,
+		tokenExpiryWarning: tokenExpiryWarning,
+		authDeadline:       authDeadline,
+		authCookie:         authCookie,
+		pollTimeout:        pollTimeout,
+		sessionTTL:         sessionTTL,
+		sessions:           make(map[string]*longPollSession),
+	}
+}
+
+func (s *LongPollServer) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.originChecker.AllowCORS(w, r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *LongPollServer) Register(router *mux.Router) {
+	longPollRouter := router.PathPrefix("/longpoll").Subrouter()
+	longPollRouter.Use(s.corsMiddleware)
+
+	longPollRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		var authentication *auth.Authentication
+		if token := upgradeToken(r, s.authCookie); token != "" {
+			var err error
+			authentication, err = s.authenticator.AuthenticateJWT(token)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		session := s.open(authentication)
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(longPollSessionResponse{SessionId: session.id})
+		if err != nil {
+			http.Error(w, "failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	}).Methods("POST", "OPTIONS")
+
+	longPollRouter.HandleFunc("/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		session, ok := s.session(mux.Vars(r)["sessionId"])
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		// Requests are limited as WebSocket messages are.
+		var request handler.Request
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&request)
+		if err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ctx := broadcaster.WithConnection(r.Context(), session.connection)
+
+		response := s.router.RouteRequest(ctx, request)
+		if response != nil {
+			session.enqueue(response)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}).Methods("POST", "OPTIONS")
+
+	longPollRouter.HandleFunc("/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		session, ok := s.session(mux.Vars(r)["sessionId"])
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		items, closed := session.poll(r.Context(), s.pollTimeout)
+		if closed {
+			s.remove(session)
+
+			var closeResponse longPollCloseResponse
+			if reason, ok := session.connection.CloseReason(); ok {
+				closeResponse = longPollCloseResponse{Code: reason.Code, Reason: reason.Text}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(closeResponse)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(items)
+		if err != nil {
+			http.Error(w, "failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	}).Methods("GET", "OPTIONS")
+
+	longPollRouter.HandleFunc("/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		session, ok := s.session(mux.Vars(r)["sessionId"])
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		s.remove(session)
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE", "OPTIONS")
+}
+
+func (s *LongPollServer) session(sessionId string) (*longPollSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionId]
+
+	return session, ok
+}
+
+func (s *LongPollServer) remove(session *longPollSession) {
+	s.mu.Lock()
+	delete(s.sessions, session.id)
+	s.mu.Unlock()
+
+	session.remove()
+	s.registry.Disconnect(session.connection.Id)
+}
+
+func (s *LongPollServer) open(authentication *auth.Authentication) *longPollSession {
+	connectionId := gonanoid.Must()
+	broadcasterChannel := make(chan broadcaster.Message, 1024)
+
+	broadcasterConn := &broadcaster.Connection{
+		Id:   connectionId,
+		Send: broadcasterChannel,
+		Seq:  0,
+	}
+
+	session := newLongPollSession(broadcasterConn)
+
+	s.mu.Lock()
+	s.sessions[session.id] = session
+	s.mu.Unlock()
+
+	s.registry.Connect(broadcasterConn)
+
+	// Subscribed before any request is routed, so that no authentication goes
+	// unnoticed.
+	authenticationChanged := broadcasterConn.AuthenticationChanged()
+
+	if authentication != nil {
+		broadcasterConn.SetAuthentication(authentication)
+
+		rawJson, _ := json.Marshal(handler.AuthResponse{
+			Success:   true,
+			SessionId: connectionId,
+		})
+
+		payload := json.RawMessage(rawJson)
+		session.enqueue(handler.NewNotification("authenticated", &payload))
+	}
+
+	go s.run(session, broadcasterChannel, authenticationChanged, authentication == nil)
+
+	return session
+}
+
+// run queues the messages of the session's connection and enforces its
+// deadlines until the session is removed.
+func (s *LongPollServer) run(
+	session *longPollSession,
+	broadcasterChannel chan broadcaster.Message,
+	authenticationChanged <-chan struct{},
+	unauthenticated bool,
+) {
+	connectionId := session.connection.Id
+	logger := s.logger.With(zap.String("connectionId", connectionId))
+
+	expiry := newTokenExpiry(s.tokenExpiryWarning)
+	defer expiry.stop()
+
+	var authDeadline <-chan time.Time
+	if unauthenticated && s.authDeadline > 0 {
+		authDeadlineTimer := time.NewTimer(s.authDeadline)
+		defer authDeadlineTimer.Stop()
+
+		authDeadline = authDeadlineTimer.C
+	}
+
+	idleTicker := time.NewTicker(s.sessionTTL / 4)
+	defer idleTicker.Stop()
+
+	var source <-chan broadcaster.Message = broadcasterChannel
+
+	for {
+		// Messages are left to the connection while the queue is full.
+		messages := source
+		if session.isFull() {
+			messages = nil
+		}
+
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				source = nil
+				session.close()
+
+				logger.Info("long polling connection closed")
+
+				continue
+			}
+
+			rawJson, err := json.Marshal(message)
+			if err != nil {
+				logger.Error("failed to marshal message", zap.Error(err))
+
+				continue
+			}
+
+			payload := json.RawMessage(rawJson)
+			session.enqueue(handler.NewNotification("broadcast", &payload))
+		case <-session.drained:
+		case <-authenticationChanged:
+			authentication := session.connection.GetAuthentication()
+			if authentication != nil {
+				authDeadline = nil
+				expiry.reset(authentication.ExpireTime)
+			}
+		case <-authDeadline:
+			authDeadline = nil
+
+			// The authentication may be set while the deadline fires.
+			if session.connection.GetUserId() != "" {
+				continue
+			}
+
+			logger.Info("closing unauthenticated long polling session")
+
+			session.connection.SetCloseReason(broadcaster.CloseReasonAuthenticationTimeout)
+			s.registry.Disconnect(connectionId)
+		case <-expiry.warningC():
+			rawJson, _ := json.Marshal(tokenExpiringParams{
+				ExpireTime: session.connection.GetAuthentication().ExpireTime,
+			})
+
+			payload := json.RawMessage(rawJson)
+			session.enqueue(handler.NewNotification("tokenExpiring", &payload))
+		case <-expiry.expireC():
+			logger.Info("closing long polling session with expired token")
+
+			session.connection.SetCloseReason(broadcaster.CloseReasonTokenExpired)
+			s.registry.Disconnect(connectionId)
+		case <-idleTicker.C:
+			if session.isIdle(s.sessionTTL) {
+				logger.Info("removing idle long polling session")
+
+				s.remove(session)
+
+				return
+			}
+		case <-session.done:
+			return
+		}
+	}
+}
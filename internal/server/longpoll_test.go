@@ -0,0 +1,257 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestLongPollServer(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+
+	router := NewRouter(
+		logger,
+		handler.NewHeartbeatHandler(),
+		handler.NewSubscribeHandler(channelValidator, registry, 100),
+		handler.NewUnsubscribeHandler(channelValidator, registry),
+		handler.NewPublishHandler(channelValidator, registry),
+		handler.NewAuthHandler(authenticator),
+		handler.NewResumeHandler(channelValidator, registry),
+		handler.NewPresenceHandler(channelValidator, registry),
+		handler.NewRefreshHandler(authenticator, registry),
+	)
+
+	longPollServer := NewLongPollServer(logger, registry, router, authenticator, NewOriginChecker([]string{"https://app.example.com"}), time.Second, 500*time.Millisecond, "", 300*time.Millisecond, time.Second)
+
+	mainRouter := mux.NewRouter()
+	longPollServer.Register(mainRouter)
+
+	server := httptest.NewServer(mainRouter)
+	defer server.Close()
+
+	claims := jwt.MapClaims{
+		"sub":                "long-poll-user",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"aud":                "broadcaster",
+		"authorizedChannels": []string{"long-poll:*"},
+		"scope":              []string{"subscribe"},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte("test-secret"))
+	assert.NoError(t, err)
+
+	openSession := func(t *testing.T, token string) string {
+		req, err := http.NewRequest("POST", server.URL+"/longpoll", nil)
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+
+		var sessionResponse longPollSessionResponse
+		err = json.NewDecoder(resp.Body).Decode(&sessionResponse)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, sessionResponse.SessionId)
+
+		return sessionResponse.SessionId
+	}
+
+	send := func(t *testing.T, sessionId string, request string) int {
+		resp, err := http.Post(server.URL+"/longpoll/"+sessionId, "application/json", strings.NewReader(request))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	poll := func(t *testing.T, sessionId string) (int, []json.RawMessage) {
+		resp, err := http.Get(server.URL + "/longpoll/" + sessionId)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+
+		var items []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&items)
+		assert.NoError(t, err)
+
+		return resp.StatusCode, items
+	}
+
+	t.Run("successful flow", func(t *testing.T) {
+		sessionId := openSession(t, tokenString)
+
+		status, items := poll(t, sessionId)
+		assert.Equal(t, http.StatusOK, status)
+		if assert.Len(t, items, 1) {
+			var notification handler.Request
+			err := json.Unmarshal(items[0], &notification)
+			assert.NoError(t, err)
+			assert.Equal(t, "authenticated", notification.Method)
+		}
+
+		status = send(t, sessionId, `{"id":1,"method":"subscribe","params":{"channel":"long-poll:a"}}`)
+		assert.Equal(t, http.StatusAccepted, status)
+
+		registry.Broadcast(broadcaster.Message{Id: "msg-1", Channel: "long-poll:a", Payload: "hello"})
+
+		status, items = poll(t, sessionId)
+		assert.Equal(t, http.StatusOK, status)
+		if assert.Len(t, items, 2) {
+			var response handler.Response
+			err := json.Unmarshal(items[0], &response)
+			assert.NoError(t, err)
+			assert.Nil(t, response.Error)
+
+			var notification handler.Request
+			err = json.Unmarshal(items[1], &notification)
+			assert.NoError(t, err)
+			assert.Equal(t, "broadcast", notification.Method)
+
+			var message broadcaster.Message
+			err = json.Unmarshal(*notification.Params, &message)
+			assert.NoError(t, err)
+			assert.Equal(t, "msg-1", message.Id)
+		}
+	})
+
+	t.Run("poll timeout", func(t *testing.T) {
+		sessionId := openSession(t, tokenString)
+		poll(t, sessionId)
+
+		start := time.Now()
+		status, items := poll(t, sessionId)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, items)
+		assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	})
+
+	t.Run("authenticate with request", func(t *testing.T) {
+		sessionId := openSession(t, "")
+
+		status := send(t, sessionId, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+		assert.Equal(t, http.StatusAccepted, status)
+
+		status, items := poll(t, sessionId)
+		assert.Equal(t, http.StatusOK, status)
+		if assert.Len(t, items, 1) {
+			var response handler.Response
+			err := json.Unmarshal(items[0], &response)
+			assert.NoError(t, err)
+			assert.Nil(t, response.Error)
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		req, err := http.NewRequest("POST", server.URL+"/longpoll", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer invalid")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("origins", func(t *testing.T) {
+		preflight := func(origin string) *http.Response {
+			req, err := http.NewRequest("OPTIONS", server.URL+"/longpoll", nil)
+			assert.NoError(t, err)
+			req.Header.Set("Origin", origin)
+
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			resp.Body.Close()
+
+			return resp
+		}
+
+		resp := preflight("https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+		resp = preflight("https://app.example.com")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		status, _ := poll(t, "unknown")
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status = send(t, "unknown", `{"id":1,"method":"heartbeat"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("close session", func(t *testing.T) {
+		sessionId := openSession(t, tokenString)
+
+		req, err := http.NewRequest("DELETE", server.URL+"/longpoll/"+sessionId, nil)
+		assert.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		status, _ := poll(t, sessionId)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("authentication deadline", func(t *testing.T) {
+		sessionId := openSession(t, "")
+
+		time.Sleep(600 * time.Millisecond)
+
+		resp, err := http.Get(server.URL + "/longpoll/" + sessionId)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		var closeResponse longPollCloseResponse
+		err = json.NewDecoder(resp.Body).Decode(&closeResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, 4002, closeResponse.Code)
+
+		status, _ := poll(t, sessionId)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("idle session", func(t *testing.T) {
+		sessionId := openSession(t, tokenString)
+
+		time.Sleep(1500 * time.Millisecond)
+
+		status, _ := poll(t, sessionId)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
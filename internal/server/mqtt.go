@@ -0,0 +1,843 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	mqttMaxPacketSize = 256 * 1024
+	// mqttEventProperty is the MQTT 5 user property holding the event of a
+	// message.
+	mqttEventProperty  = "event"
+	mqttTopicSeparator = "/"
+	mqttSingleWildcard = "+"
+	mqttMultiWildcard  = "#"
+)
+
+var errMQTTProtocol = errors.New("mqtt protocol violation")
+
+// MQTTServer is an MQTT 3.1.1 and 5 front-end of the registry. Topic levels
+// are the segments of channels, so that MQTT clients and the clients of the
+// other transports see each other's messages.
+//
+// Messages are delivered at most once: QoS 1 publications are acknowledged
+// once broadcast, and subscriptions are granted QoS 0. Sessions, retained
+// messages and shared subscriptions are not supported.
+type MQTTServer struct {
+	logger             *zap.Logger
+	registry           broadcaster.Registry
+	publishHandler     handler.PublishHandlerInterface
+	subscribeHandler   handler.SubscribeHandlerInterface
+	unsubscribeHandler handler.UnsubscribeHandlerInterface
+	authenticator      *auth.Authenticator
+	// authDeadline is how long clients have to send their CONNECT packet.
+	authDeadline time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*mqttConn]struct{}
+	closed   bool
+}
+
+func NewMQTTServer(
+	logger *zap.Logger,
+	registry broadcaster.Registry,
+	publishHandler handler.PublishHandlerInterface,
+	subscribeHandler handler.SubscribeHandlerInterface,
+	unsubscribeHandler handler.UnsubscribeHandlerInterface,
+	authenticator *auth.Authenticator,
+	authDeadline time.Duration,
+) *MQTTServer {
+	return &MQTTServer{
+		logger:             logger,
+		registry:           registry,
+		publishHandler:     publishHandler,
+		subscribeHandler:   subscribeHandler,
+		unsubscribeHandler: unsubscribeHandler,
+		authenticator:      authenticator,
+		authDeadline:       authDeadline,
+		conns:              make(map[*mqttConn]struct{}),
+	}
+}
+
+// Serve accepts connections on the listener until the server is closed.
+func (s *MQTTServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections and disconnects the clients.
+func (s *MQTTServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	for c := range s.conns {
+		c.disconnect(packets.ErrServerShuttingDown)
+	}
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Close()
+}
+
+// authenticate reads the password of a CONNECT packet as a JWT, or as an API
+// key for backend clients.
+func (s *MQTTServer) authenticate(password string) (*auth.Authentication, error) {
+	if strings.Count(password, ".") == 2 {
+		return s.authenticator.AuthenticateJWT(password)
+	}
+
+	return s.authenticator.AuthenticateAPIKey(password)
+}
+
+type mqttConn struct {
+	server          *MQTTServer
+	conn            net.Conn
+	reader          *bufio.Reader
+	logger          *zap.Logger
+	protocolVersion byte
+	connection      *broadcaster.Connection
+
+	writeMu sync.Mutex
+
+	// channels counts the filters subscribed to each channel, as filters
+	// ending with `#` share the channel of their parent level.
+	channels map[string]int
+	filters  map[string][]string
+	// will is published when the connection is lost without a DISCONNECT.
+	will *handler.PublishRequest
+}
+
+func (s *MQTTServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	c := &mqttConn{
+		server:   s,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		logger:   s.logger.With(zap.String("remoteAddress", conn.RemoteAddr().String())),
+		channels: make(map[string]int),
+		filters:  make(map[string][]string),
+	}
+
+	if s.authDeadline > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.authDeadline))
+	}
+
+	pk, err := readMQTTPacket(c.reader, 0)
+	if err != nil || pk.FixedHeader.Type != packets.Connect {
+		c.logger.Info("mqtt connection closed before connect", zap.Error(err))
+		return
+	}
+
+	c.protocolVersion = pk.ProtocolVersion
+
+	if code := pk.ConnectValidate(); code != packets.CodeSuccess {
+		c.connack(code, "")
+		return
+	}
+
+	authentication, err := s.authenticate(string(pk.Connect.Password))
+	if err != nil {
+		c.logger.Info("mqtt authentication failed", zap.Error(err))
+		c.connack(packets.ErrBadUsernameOrPassword, "")
+		return
+	}
+
+	clientId := pk.Connect.ClientIdentifier
+	assignedClientId := ""
+	if clientId == "" {
+		if c.protocolVersion != 5 && !pk.Connect.Clean {
+			c.connack(packets.ErrClientIdentifierNotValid, "")
+			return
+		}
+
+		clientId = gonanoid.Must()
+		assignedClientId = clientId
+	}
+
+	if pk.Connect.WillFlag {
+		c.will = &handler.PublishRequest{
+			Payload: payloadFromMQTT(pk.Connect.WillPayload),
+			Event:   mqttEvent(pk.Connect.WillProperties),
+		}
+
+		c.will.Channel, err = channelFromTopic(pk.Connect.WillTopic)
+		if err != nil {
+			c.connack(packets.ErrTopicNameInvalid, "")
+			return
+		}
+	}
+
+	c.connection = &broadcaster.Connection{
+		Id:   gonanoid.Must(),
+		Send: make(chan broadcaster.Message, 1024),
+		Seq:  0,
+	}
+	c.connection.SetAuthentication(authentication)
+	c.logger = c.logger.With(
+		zap.String("connectionId", c.connection.Id),
+		zap.String("clientId", clientId))
+
+	s.registry.Connect(c.connection)
+	defer s.registry.Disconnect(c.connection.Id)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		c.connack(packets.ErrServerShuttingDown, "")
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	err = c.connack(packets.CodeSuccess, assignedClientId)
+	if err != nil {
+		return
+	}
+
+	c.logger.Info("mqtt client connected",
+		zap.Uint8("protocolVersion", c.protocolVersion))
+
+	go c.writePump(authentication.ExpireTime)
+
+	// Connections closed by the server, for instance once revoked, do not
+	// publish their will.
+	graceful := c.readPump(pk.Connect.Keepalive)
+	_, closedByServer := c.connection.CloseReason()
+	if !graceful && !closedByServer && c.will != nil {
+		_, err := s.publishHandler.Handle(broadcaster.WithConnection(context.Background(), c.connection), *c.will)
+		if err != nil {
+			c.logger.Info("failed to publish will", zap.Error(err))
+		}
+	}
+
+	c.logger.Info("mqtt client disconnected")
+}
+
+// readPump handles the packets of the client until the connection closes. It
+// reports whether the client disconnected gracefully.
+func (c *mqttConn) readPump(keepAlive uint16) bool {
+	ctx := broadcaster.WithConnection(context.Background(), c.connection)
+
+	for {
+		// Clients that send nothing for one and a half keep alive periods are
+		// gone.
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive) * 1500 * time.Millisecond))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+
+		pk, err := readMQTTPacket(c.reader, c.protocolVersion)
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+			case errors.As(err, &netErr) && netErr.Timeout():
+				c.logger.Info("mqtt keep alive timeout")
+				c.disconnect(packets.ErrKeepAliveTimeout)
+			default:
+				c.logger.Info("failed to read mqtt packet", zap.Error(err))
+				c.disconnect(packets.ErrMalformedPacket)
+			}
+
+			return false
+		}
+
+		switch pk.FixedHeader.Type {
+		case packets.Publish:
+			err = c.handlePublish(ctx, pk)
+		case packets.Subscribe:
+			err = c.handleSubscribe(ctx, pk)
+		case packets.Unsubscribe:
+			err = c.handleUnsubscribe(ctx, pk)
+		case packets.Pingreq:
+			err = c.write(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pingresp}})
+		case packets.Disconnect:
+			// MQTT 5 clients may ask for their will to be published.
+			return pk.ReasonCode != packets.CodeDisconnectWillMessage.Code
+		default:
+			c.disconnect(packets.ErrProtocolViolation)
+
+			return false
+		}
+
+		if err != nil {
+			return false
+		}
+	}
+}
+
+func (c *mqttConn) handlePublish(ctx context.Context, pk packets.Packet) error {
+	if code := pk.PublishValidate(0); code != packets.CodeSuccess {
+		c.disconnect(code)
+		return errMQTTProtocol
+	}
+
+	if pk.FixedHeader.Qos > 1 {
+		c.disconnect(packets.ErrQosNotSupported)
+		return errMQTTProtocol
+	}
+
+	if pk.FixedHeader.Retain && c.protocolVersion == 5 {
+		c.disconnect(packets.ErrRetainNotSupported)
+		return errMQTTProtocol
+	}
+
+	code := packets.CodeSuccess
+
+	channel, err := channelFromTopic(pk.TopicName)
+	if err == nil {
+		_, err = c.server.publishHandler.Handle(ctx, handler.PublishRequest{
+			Channel: channel,
+			Event:   mqttEvent(pk.Properties),
+			Payload: payloadFromMQTT(pk.Payload),
+		})
+	}
+
+	if err != nil {
+		// MQTT 3.1.1 clients cannot be told, so their publication is
+		// acknowledged and dropped.
+		c.logger.Info("failed to handle mqtt publish",
+			zap.String("topic", pk.TopicName), zap.Error(err))
+		code = mqttCodeFromError(err, packets.ErrTopicNameInvalid)
+	}
+
+	if pk.FixedHeader.Qos == 0 {
+		return nil
+	}
+
+	puback := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Puback},
+		PacketID:    pk.PacketID,
+	}
+	if c.protocolVersion == 5 {
+		puback.ReasonCode = code.Code
+	}
+
+	return c.write(puback)
+}
+
+func (c *mqttConn) handleSubscribe(ctx context.Context, pk packets.Packet) error {
+	if code := pk.SubscribeValidate(); code != packets.CodeSuccess {
+		c.disconnect(code)
+		return errMQTTProtocol
+	}
+
+	reasonCodes := make([]byte, 0, len(pk.Filters))
+	for _, filter := range pk.Filters {
+		code := c.subscribe(ctx, filter.Filter)
+		if code != packets.CodeGrantedQos0 && c.protocolVersion != 5 {
+			code = packets.ErrUnspecifiedError
+		}
+
+		reasonCodes = append(reasonCodes, code.Code)
+	}
+
+	return c.write(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Suback},
+		PacketID:    pk.PacketID,
+		ReasonCodes: reasonCodes,
+	})
+}
+
+// subscribe subscribes to the channels of a filter, whose subscription is
+// replaced if it already exists.
+func (c *mqttConn) subscribe(ctx context.Context, filter string) packets.Code {
+	if _, ok := c.filters[filter]; ok {
+		return packets.CodeGrantedQos0
+	}
+
+	if strings.HasPrefix(filter, "$share/") {
+		return packets.ErrSharedSubscriptionsNotSupported
+	}
+
+	channels, err := channelsFromFilter(filter)
+	if err != nil {
+		return packets.ErrTopicFilterInvalid
+	}
+
+	// The parent level of a `#` filter is left out when only the levels below
+	// it are authorized.
+	if len(channels) == 2 && !c.connection.Can(auth.ScopeSubscribe, channels[0]) {
+		channels = channels[1:]
+	}
+
+	for i, channel := range channels {
+		if c.channels[channel] == 0 {
+			_, err = c.server.subscribeHandler.Handle(ctx, handler.SubscribeRequest{Channel: channel})
+		}
+
+		if err != nil {
+			c.logger.Info("failed to handle mqtt subscribe",
+				zap.String("filter", filter), zap.Error(err))
+			c.unsubscribeChannels(ctx, channels[:i])
+
+			return mqttCodeFromError(err, packets.ErrTopicFilterInvalid)
+		}
+
+		c.channels[channel]++
+	}
+
+	c.filters[filter] = channels
+
+	return packets.CodeGrantedQos0
+}
+
+func (c *mqttConn) handleUnsubscribe(ctx context.Context, pk packets.Packet) error {
+	if code := pk.UnsubscribeValidate(); code != packets.CodeSuccess {
+		c.disconnect(code)
+		return errMQTTProtocol
+	}
+
+	reasonCodes := make([]byte, 0, len(pk.Filters))
+	for _, filter := range pk.Filters {
+		channels, ok := c.filters[filter.Filter]
+		if !ok {
+			reasonCodes = append(reasonCodes, packets.CodeNoSubscriptionExisted.Code)
+			continue
+		}
+
+		delete(c.filters, filter.Filter)
+		c.unsubscribeChannels(ctx, channels)
+
+		reasonCodes = append(reasonCodes, packets.CodeSuccess.Code)
+	}
+
+	return c.write(packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Unsuback},
+		PacketID:    pk.PacketID,
+		ReasonCodes: reasonCodes,
+	})
+}
+
+// unsubscribeChannels unsubscribes from the channels that no other filter
+// subscribes to.
+func (c *mqttConn) unsubscribeChannels(ctx context.Context, channels []string) {
+	for _, channel := range channels {
+		c.channels[channel]--
+		if c.channels[channel] > 0 {
+			continue
+		}
+
+		delete(c.channels, channel)
+
+		_, err := c.server.unsubscribeHandler.Handle(ctx, handler.UnsubscribeRequest{Channel: channel})
+		if err != nil {
+			c.logger.Error("failed to handle mqtt unsubscribe",
+				zap.String("channel", channel), zap.Error(err))
+		}
+	}
+}
+
+// writePump delivers the messages of the subscribed channels, until the
+// connection is disconnected from the registry or its token expires.
+func (c *mqttConn) writePump(expireTime time.Time) {
+	defer c.conn.Close()
+
+	var expireC <-chan time.Time
+	if !expireTime.IsZero() {
+		expireTimer := time.NewTimer(time.Until(expireTime))
+		defer expireTimer.Stop()
+
+		expireC = expireTimer.C
+	}
+
+	for {
+		select {
+		case message, ok := <-c.connection.Send:
+			if !ok {
+				if reason, ok := c.connection.CloseReason(); ok {
+					c.logger.Info("closing mqtt connection", zap.String("reason", reason.Text))
+					c.disconnectWithReason(packets.ErrNotAuthorized, reason.Text)
+				}
+
+				return
+			}
+
+			payload, err := payloadToMQTT(message.Payload)
+			if err != nil {
+				c.logger.Error("failed to encode mqtt payload", zap.Error(err))
+				continue
+			}
+
+			publish := packets.Packet{
+				FixedHeader: packets.FixedHeader{Type: packets.Publish},
+				TopicName:   topicFromChannel(message.Channel),
+				Payload:     payload,
+			}
+			if message.Event != "" {
+				publish.Properties.User = []packets.UserProperty{{Key: mqttEventProperty, Val: message.Event}}
+			}
+
+			err = c.write(publish)
+			if err != nil {
+				return
+			}
+		case <-expireC:
+			c.logger.Info("closing mqtt connection with expired token")
+
+			c.connection.SetCloseReason(broadcaster.CloseReasonTokenExpired)
+			c.server.registry.Disconnect(c.connection.Id)
+			expireC = nil
+		}
+	}
+}
+
+func (c *mqttConn) connack(code packets.Code, assignedClientId string) error {
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Connack},
+		ReasonCode:  code.Code,
+	}
+
+	if c.protocolVersion == 5 {
+		pk.Properties = packets.Properties{
+			AssignedClientID:         assignedClientId,
+			MaximumPacketSize:        mqttMaxPacketSize,
+			MaximumQos:               1,
+			MaximumQosFlag:           true,
+			RetainAvailable:          0,
+			RetainAvailableFlag:      true,
+			SharedSubAvailable:       0,
+			SharedSubAvailableFlag:   true,
+			SubIDAvailable:           0,
+			SubIDAvailableFlag:       true,
+			WildcardSubAvailable:     1,
+			WildcardSubAvailableFlag: true,
+		}
+	} else if code != packets.CodeSuccess {
+		pk.ReasonCode = mqtt3ConnackCode(code)
+	}
+
+	return c.write(pk)
+}
+
+// disconnect tells MQTT 5 clients why the server closes their connection.
+func (c *mqttConn) disconnect(code packets.Code) {
+	c.disconnectWithReason(code, "")
+}
+
+func (c *mqttConn) disconnectWithReason(code packets.Code, reason string) {
+	if c.protocolVersion == 5 {
+		_ = c.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Disconnect},
+			ReasonCode:  code.Code,
+			Properties:  packets.Properties{ReasonString: reason},
+		})
+	}
+
+	c.conn.Close()
+}
+
+func (c *mqttConn) write(pk packets.Packet) error {
+	pk.ProtocolVersion = c.protocolVersion
+
+	var buffer bytes.Buffer
+	err := encodeMQTTPacket(&buffer, pk)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.conn.Write(buffer.Bytes())
+
+	return err
+}
+
+// readMQTTPacket reads the next packet. The protocol version is that of the
+// CONNECT packet, and is read from the packet itself until then.
+func readMQTTPacket(reader *bufio.Reader, protocolVersion byte) (packets.Packet, error) {
+	pk := packets.Packet{ProtocolVersion: protocolVersion}
+
+	header, err := reader.ReadByte()
+	if err != nil {
+		return pk, err
+	}
+
+	err = pk.FixedHeader.Decode(header)
+	if err != nil {
+		return pk, err
+	}
+
+	remaining, _, err := packets.DecodeLength(reader)
+	if err != nil {
+		return pk, err
+	}
+
+	if remaining > mqttMaxPacketSize {
+		return pk, packets.ErrPacketTooLarge
+	}
+
+	pk.FixedHeader.Remaining = remaining
+
+	buf := make([]byte, remaining)
+	_, err = io.ReadFull(reader, buf)
+	if err != nil {
+		return pk, err
+	}
+
+	switch pk.FixedHeader.Type {
+	case packets.Connect:
+		err = pk.ConnectDecode(buf)
+	case packets.Connack:
+		err = pk.ConnackDecode(buf)
+	case packets.Publish:
+		err = pk.PublishDecode(buf)
+	case packets.Puback:
+		err = pk.PubackDecode(buf)
+	case packets.Subscribe:
+		err = pk.SubscribeDecode(buf)
+	case packets.Suback:
+		err = pk.SubackDecode(buf)
+	case packets.Unsubscribe:
+		err = pk.UnsubscribeDecode(buf)
+	case packets.Unsuback:
+		err = pk.UnsubackDecode(buf)
+	case packets.Pingreq, packets.Pingresp:
+	case packets.Disconnect:
+		err = pk.DisconnectDecode(buf)
+	default:
+		err = fmt.Errorf("unsupported packet type %d", pk.FixedHeader.Type)
+	}
+
+	return pk, err
+}
+
+func encodeMQTTPacket(buffer *bytes.Buffer, pk packets.Packet) error {
+	switch pk.FixedHeader.Type {
+	case packets.Connect:
+		return pk.ConnectEncode(buffer)
+	case packets.Connack:
+		return pk.ConnackEncode(buffer)
+	case packets.Publish:
+		return pk.PublishEncode(buffer)
+	case packets.Puback:
+		return pk.PubackEncode(buffer)
+	case packets.Subscribe:
+		return pk.SubscribeEncode(buffer)
+	case packets.Suback:
+		return pk.SubackEncode(buffer)
+	case packets.Unsubscribe:
+		return pk.UnsubscribeEncode(buffer)
+	case packets.Unsuback:
+		return pk.UnsubackEncode(buffer)
+	case packets.Pingreq:
+		return pk.PingreqEncode(buffer)
+	case packets.Pingresp:
+		return pk.PingrespEncode(buffer)
+	case packets.Disconnect:
+		return pk.DisconnectEncode(buffer)
+	default:
+		return fmt.Errorf("unsupported packet type %d", pk.FixedHeader.Type)
+	}
+}
+
+// mqtt3ConnackCode returns the MQTT 3.1.1 return code of a refused
+// connection.
+func mqtt3ConnackCode(code packets.Code) byte {
+	switch code {
+	case packets.ErrUnsupportedProtocolVersion, packets.ErrProtocolViolationProtocolVersion:
+		return packets.Err3UnsupportedProtocolVersion.Code
+	case packets.ErrClientIdentifierNotValid:
+		return packets.Err3ClientIdentifierNotValid.Code
+	case packets.ErrBadUsernameOrPassword:
+		return packets.ErrMalformedUsernameOrPassword.Code
+	case packets.ErrNotAuthorized:
+		return packets.Err3NotAuthorized.Code
+	default:
+		return packets.Err3ServerUnavailable.Code
+	}
+}
+
+// mqttCodeFromError returns the MQTT 5 reason code of a handler error. Invalid
+// arguments are reported with the code of an invalid topic or filter.
+func mqttCodeFromError(err error, invalidCode packets.Code) packets.Code {
+	var handlerErr ierr.Error
+	if !errors.As(err, &handlerErr) {
+		return packets.ErrUnspecifiedError
+	}
+
+	switch handlerErr.Code {
+	case ierr.ErrorCodeInvalidArgument:
+		return invalidCode
+	case ierr.ErrorCodePermissionDenied, ierr.ErrorCodeUnauthenticated:
+		return packets.ErrNotAuthorized
+	default:
+		return packets.ErrImplementationSpecificError
+	}
+}
+
+func mqttEvent(properties packets.Properties) string {
+	for _, property := range properties.User {
+		if property.Key == mqttEventProperty {
+			return property.Val
+		}
+	}
+
+	return ""
+}
+
+// channelFromTopic maps the levels of a topic onto the segments of a channel.
+func channelFromTopic(topic string) (string, error) {
+	levels := strings.Split(topic, mqttTopicSeparator)
+	for _, level := range levels {
+		if strings.ContainsAny(level, mqttSingleWildcard+mqttMultiWildcard) {
+			return "", errors.New("topic names cannot contain wildcards")
+		}
+
+		err := validateTopicLevel(level)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(levels, pattern.Separator), nil
+}
+
+// channelsFromFilter maps a topic filter onto channel patterns. A `+` level
+// matches one segment like `*`. As `#` also matches its parent level, unlike
+// `**`, it is mapped onto both the parent and the parent followed by `**`.
+func channelsFromFilter(filter string) ([]string, error) {
+	levels := strings.Split(filter, mqttTopicSeparator)
+
+	multi := levels[len(levels)-1] == mqttMultiWildcard
+	if multi {
+		levels = levels[:len(levels)-1]
+	}
+
+	segments := make([]string, 0, len(levels)+1)
+	for _, level := range levels {
+		if level == mqttSingleWildcard {
+			segments = append(segments, pattern.SingleWildcard)
+			continue
+		}
+
+		if strings.ContainsAny(level, mqttSingleWildcard+mqttMultiWildcard) {
+			return nil, errors.New("wildcards must be whole levels, and # the last level")
+		}
+
+		err := validateTopicLevel(level)
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, level)
+	}
+
+	if !multi {
+		return []string{strings.Join(segments, pattern.Separator)}, nil
+	}
+
+	channels := []string{}
+	if len(segments) > 0 {
+		channels = append(channels, strings.Join(segments, pattern.Separator))
+	}
+
+	return append(channels, strings.Join(append(segments, pattern.MultiWildcard), pattern.Separator)), nil
+}
+
+// validateTopicLevel rejects the levels that cannot be channel segments. Other
+// invalid characters are rejected by the channel validator.
+func validateTopicLevel(level string) error {
+	if strings.HasPrefix(level, "$") {
+		return errors.New("system topics are not supported")
+	}
+
+	if strings.ContainsAny(level, pattern.Separator+pattern.SingleWildcard) {
+		return errors.New("topic levels cannot contain : or *")
+	}
+
+	return nil
+}
+
+func topicFromChannel(channel string) string {
+	return strings.ReplaceAll(channel, pattern.Separator, mqttTopicSeparator)
+}
+
+// payloadFromMQTT decodes JSON objects and arrays, so that the clients of the
+// other transports receive them as JSON. Other payloads are kept as strings
+// when they are UTF-8, and as bytes otherwise.
+func payloadFromMQTT(payload []byte) any {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var decoded any
+		if json.Unmarshal(trimmed, &decoded) == nil {
+			return decoded
+		}
+	}
+
+	if utf8.Valid(payload) {
+		return string(payload)
+	}
+
+	return payload
+}
+
+func payloadToMQTT(payload any) ([]byte, error) {
+	switch payload := payload.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(payload), nil
+	case []byte:
+		return payload, nil
+	default:
+		return json.Marshal(payload)
+	}
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type mqttTestClient struct {
+	t               *testing.T
+	conn            net.Conn
+	reader          *bufio.Reader
+	protocolVersion byte
+}
+
+func (c *mqttTestClient) write(pk packets.Packet) {
+	c.t.Helper()
+
+	pk.ProtocolVersion = c.protocolVersion
+
+	var buffer bytes.Buffer
+	err := encodeMQTTPacket(&buffer, pk)
+	assert.NoError(c.t, err)
+
+	_, err = c.conn.Write(buffer.Bytes())
+	assert.NoError(c.t, err)
+}
+
+func (c *mqttTestClient) read() packets.Packet {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	pk, err := readMQTTPacket(c.reader, c.protocolVersion)
+	if !assert.NoError(c.t, err) {
+		c.t.FailNow()
+	}
+
+	return pk
+}
+
+func TestMQTTServer(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+
+	mqttServer := NewMQTTServer(
+		logger,
+		registry,
+		handler.NewPublishHandler(channelValidator, registry),
+		handler.NewSubscribeHandler(channelValidator, registry, 100),
+		handler.NewUnsubscribeHandler(channelValidator, registry),
+		authenticator,
+		time.Second,
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go mqttServer.Serve(listener)
+	defer mqttServer.Close()
+
+	claims := jwt.MapClaims{
+		"sub":                "device-1",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"aud":                "broadcaster",
+		"authorizedChannels": []string{"devices:**"},
+		"scope":              []string{"subscribe", "publish"},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte("test-secret"))
+	assert.NoError(t, err)
+
+	connect := func(t *testing.T, protocolVersion byte, password string) (*mqttTestClient, packets.Packet) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		client := &mqttTestClient{t, conn, bufio.NewReader(conn), protocolVersion}
+		client.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Connect},
+			Connect: packets.ConnectParams{
+				ProtocolName:     []byte("MQTT"),
+				Clean:            true,
+				Keepalive:        30,
+				ClientIdentifier: "client-" + t.Name(),
+				UsernameFlag:     true,
+				Username:         []byte("device"),
+				PasswordFlag:     true,
+				Password:         []byte(password),
+			},
+		})
+
+		return client, client.read()
+	}
+
+	subscribe := func(client *mqttTestClient, filters ...string) packets.Packet {
+		subscriptions := packets.Subscriptions{}
+		for _, filter := range filters {
+			subscriptions = append(subscriptions, packets.Subscription{Filter: filter})
+		}
+
+		client.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Subscribe, Qos: 1},
+			PacketID:    1,
+			Filters:     subscriptions,
+		})
+
+		suback := client.read()
+		assert.Equal(t, packets.Suback, suback.FixedHeader.Type)
+
+		return suback
+	}
+
+	t.Run("bad credentials", func(t *testing.T) {
+		_, connack := connect(t, 4, "invalid")
+		assert.Equal(t, packets.Connack, connack.FixedHeader.Type)
+		assert.Equal(t, packets.ErrMalformedUsernameOrPassword.Code, connack.ReasonCode)
+
+		_, connack = connect(t, 5, "invalid")
+		assert.Equal(t, packets.ErrBadUsernameOrPassword.Code, connack.ReasonCode)
+	})
+
+	for _, protocolVersion := range []byte{4, 5} {
+		t.Run("subscribe", func(t *testing.T) {
+			client, connack := connect(t, protocolVersion, tokenString)
+			assert.Equal(t, packets.CodeSuccess.Code, connack.ReasonCode)
+
+			suback := subscribe(client, "devices/+/status", "other/#")
+			if protocolVersion == 5 {
+				assert.Equal(t, []byte{packets.CodeGrantedQos0.Code, packets.ErrNotAuthorized.Code}, suback.ReasonCodes)
+			} else {
+				assert.Equal(t, []byte{packets.CodeGrantedQos0.Code, packets.ErrUnspecifiedError.Code}, suback.ReasonCodes)
+			}
+
+			registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "devices:42:status", Event: "update", Payload: map[string]any{"online": true}})
+
+			publish := client.read()
+			assert.Equal(t, packets.Publish, publish.FixedHeader.Type)
+			assert.Equal(t, "devices/42/status", publish.TopicName)
+			assert.JSONEq(t, `{"online":true}`, string(publish.Payload))
+			if protocolVersion == 5 {
+				assert.Equal(t, "update", mqttEvent(publish.Properties))
+			}
+
+			client.write(packets.Packet{
+				FixedHeader: packets.FixedHeader{Type: packets.Unsubscribe, Qos: 1},
+				PacketID:    2,
+				Filters:     packets.Subscriptions{{Filter: "devices/+/status"}},
+			})
+
+			unsuback := client.read()
+			assert.Equal(t, packets.Unsuback, unsuback.FixedHeader.Type)
+			assert.Equal(t, uint16(2), unsuback.PacketID)
+		})
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		subscriber, _ := connect(t, 5, tokenString)
+		subscribe(subscriber, "devices/#")
+
+		publisher, connack := connect(t, 4, tokenString)
+		assert.Equal(t, packets.CodeSuccess.Code, connack.ReasonCode)
+
+		publisher.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+			PacketID:    7,
+			TopicName:   "devices/42/telemetry",
+			Payload:     []byte(`{"temperature":21.5}`),
+		})
+
+		puback := publisher.read()
+		assert.Equal(t, packets.Puback, puback.FixedHeader.Type)
+		assert.Equal(t, uint16(7), puback.PacketID)
+
+		message := subscriber.read()
+		assert.Equal(t, "devices/42/telemetry", message.TopicName)
+		assert.JSONEq(t, `{"temperature":21.5}`, string(message.Payload))
+
+		stored, err := registry.History("devices:42:telemetry", broadcaster.HistoryQuery{})
+		assert.NoError(t, err)
+		if assert.Len(t, stored, 1) {
+			assert.Equal(t, map[string]any{"temperature": 21.5}, stored[0].Payload)
+		}
+	})
+
+	t.Run("unauthorized publish", func(t *testing.T) {
+		client, _ := connect(t, 5, tokenString)
+
+		client.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+			PacketID:    3,
+			TopicName:   "other/topic",
+			Payload:     []byte("hello"),
+		})
+
+		puback := client.read()
+		assert.Equal(t, packets.ErrNotAuthorized.Code, puback.ReasonCode)
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		client, connack := connect(t, 5, "partner-api-key")
+		assert.Equal(t, packets.CodeSuccess.Code, connack.ReasonCode)
+
+		client.write(packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: 1},
+			PacketID:    1,
+			TopicName:   "partner/news",
+			Payload:     []byte("hello"),
+		})
+
+		puback := client.read()
+		assert.Equal(t, packets.CodeSuccess.Code, puback.ReasonCode)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		client, _ := connect(t, 4, tokenString)
+
+		client.write(packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pingreq}})
+		assert.Equal(t, packets.Pingresp, client.read().FixedHeader.Type)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		client, _ := connect(t, 5, tokenString)
+		subscribe(client, "devices/revoked")
+
+		disconnected := registry.DisconnectMatching(func(connection *broadcaster.Connection) bool {
+			return connection.GetUserId() == "device-1"
+		}, broadcaster.CloseReasonRevoked)
+		assert.Positive(t, disconnected)
+
+		disconnect := client.read()
+		assert.Equal(t, packets.Disconnect, disconnect.FixedHeader.Type)
+		assert.Equal(t, packets.ErrNotAuthorized.Code, disconnect.ReasonCode)
+		assert.Equal(t, "authentication revoked", disconnect.Properties.ReasonString)
+	})
+
+	t.Run("will", func(t *testing.T) {
+		watcher := &broadcaster.Connection{Id: "will-watcher", Send: make(chan broadcaster.Message, 10)}
+		assert.NoError(t, registry.Connect(watcher))
+		defer registry.Disconnect(watcher.Id)
+		assert.NoError(t, registry.Subscribe("devices:will", watcher.Id, broadcaster.SubscribeOptions{}))
+
+		connectWithWill := func(t *testing.T) *mqttTestClient {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			t.Cleanup(func() { conn.Close() })
+
+			client := &mqttTestClient{t, conn, bufio.NewReader(conn), 5}
+			client.write(packets.Packet{
+				FixedHeader: packets.FixedHeader{Type: packets.Connect},
+				Connect: packets.ConnectParams{
+					ProtocolName:     []byte("MQTT"),
+					Clean:            true,
+					Keepalive:        30,
+					ClientIdentifier: "client-" + t.Name(),
+					PasswordFlag:     true,
+					Password:         []byte(tokenString),
+					WillFlag:         true,
+					WillTopic:        "devices/will",
+					WillPayload:      []byte("gone"),
+				},
+			})
+			assert.Equal(t, packets.Connack, client.read().FixedHeader.Type)
+
+			return client
+		}
+
+		t.Run("published when the connection is lost", func(t *testing.T) {
+			client := connectWithWill(t)
+			client.conn.Close()
+
+			select {
+			case message := <-watcher.Send:
+				assert.Equal(t, "gone", message.Payload)
+			case <-time.After(time.Second):
+				t.Fatal("will not published")
+			}
+		})
+
+		t.Run("not published when revoked", func(t *testing.T) {
+			client := connectWithWill(t)
+			subscribe(client, "devices/revoked-will")
+
+			disconnected := registry.DisconnectMatching(func(connection *broadcaster.Connection) bool {
+				return connection.GetUserId() == "device-1" && connection.Id != watcher.Id
+			}, broadcaster.CloseReasonRevoked)
+			assert.Positive(t, disconnected)
+
+			assert.Equal(t, packets.Disconnect, client.read().FixedHeader.Type)
+
+			select {
+			case message := <-watcher.Send:
+				t.Fatalf("will %v published", message.Payload)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	})
+}
+
+func TestChannelsFromFilter(t *testing.T) {
+	for filter, expected := range map[string][]string{
+		"devices/42/status": {"devices:42:status"},
+		"devices/+/status":  {"devices:*:status"},
+		"devices/#":         {"devices", "devices:**"},
+		"devices/+/#":       {"devices:*", "devices:*:**"},
+		"#":                 {"**"},
+	} {
+		channels, err := channelsFromFilter(filter)
+		assert.NoError(t, err, filter)
+		assert.Equal(t, expected, channels, filter)
+	}
+
+	for _, filter := range []string{"devices/#/status", "devices/a+", "$SYS/#", "devices:42", "devices/*"} {
+		_, err := channelsFromFilter(filter)
+		assert.Error(t, err, filter)
+	}
+
+	channel, err := channelFromTopic("devices/42")
+	assert.NoError(t, err)
+	assert.Equal(t, "devices:42", channel)
+	assert.Equal(t, "devices/42", topicFromChannel(channel))
+
+	_, err = channelFromTopic("devices/+")
+	assert.Error(t, err)
+}
+
+func TestMQTTPayload(t *testing.T) {
+	assert.Equal(t, map[string]any{"a": float64(1)}, payloadFromMQTT([]byte(`{"a":1}`)))
+	assert.Equal(t, "42", payloadFromMQTT([]byte("42")))
+	assert.Equal(t, "{not json", payloadFromMQTT([]byte("{not json")))
+	assert.Equal(t, []byte{0xff, 0x00}, payloadFromMQTT([]byte{0xff, 0x00}))
+
+	payload, err := payloadToMQTT(map[string]any{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(payload))
+
+	payload, err = payloadToMQTT("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(payload))
+}
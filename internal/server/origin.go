@@ -1,14 +1,73 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
 
+// OriginChecker checks the origin of WebSocket upgrades and of the HTTP
+// streaming transports, so that pages of other sites cannot open connections
+// authenticated by cookies nor read their messages.
 type OriginChecker struct {
+	// allowedOrigins are the origins allowed besides the server's own. Any
+	// origin is allowed when it is empty.
+	allowedOrigins map[string]bool
 }
 
-func NewOriginChecker() *OriginChecker {
-	return &OriginChecker{}
+func NewOriginChecker(allowedOrigins []string) *OriginChecker {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return &OriginChecker{origins}
 }
 
 func (o *OriginChecker) Check(r *http.Request) bool {
+	if len(o.allowedOrigins) == 0 {
+		return true
+	}
+
+	// Clients other than browsers do not send an origin.
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if o.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// AllowCORS reports whether the origin of the request is allowed and, if so,
+// sets the CORS headers letting it read the response. Only the allowed origin
+// is echoed when origins are configured, with credentials so that cookies can
+// be used.
+func (o *OriginChecker) AllowCORS(w http.ResponseWriter, r *http.Request) bool {
+	if !o.Check(r) {
+		return false
+	}
+
+	if len(o.allowedOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		return true
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
 	return true
 }
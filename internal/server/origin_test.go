@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginChecker_Check(t *testing.T) {
+	check := func(checker *OriginChecker, origin string) bool {
+		r := httptest.NewRequest("GET", "http://broadcaster.example.com/websocket", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+
+		return checker.Check(r)
+	}
+
+	t.Run("any origin", func(t *testing.T) {
+		checker := NewOriginChecker(nil)
+
+		assert.True(t, check(checker, "https://evil.example.com"))
+	})
+
+	t.Run("allowed origins", func(t *testing.T) {
+		checker := NewOriginChecker([]string{"https://app.example.com/"})
+
+		assert.True(t, check(checker, ""))
+		assert.True(t, check(checker, "https://app.example.com"))
+		assert.True(t, check(checker, "https://APP.example.com"))
+		assert.True(t, check(checker, "http://broadcaster.example.com"))
+		assert.False(t, check(checker, "https://evil.example.com"))
+		assert.False(t, check(checker, "http://app.example.com"))
+		assert.False(t, check(checker, "null"))
+	})
+}
+
+func TestOriginChecker_AllowCORS(t *testing.T) {
+	allowCORS := func(checker *OriginChecker, origin string) (bool, http.Header) {
+		r := httptest.NewRequest("GET", "http://broadcaster.example.com/events", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+
+		return checker.AllowCORS(w, r), w.Header()
+	}
+
+	t.Run("any origin", func(t *testing.T) {
+		allowed, header := allowCORS(NewOriginChecker(nil), "https://evil.example.com")
+
+		assert.True(t, allowed)
+		assert.Equal(t, "*", header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("allowed origins", func(t *testing.T) {
+		checker := NewOriginChecker([]string{"https://app.example.com"})
+
+		allowed, header := allowCORS(checker, "https://app.example.com")
+		assert.True(t, allowed)
+		assert.Equal(t, "https://app.example.com", header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", header.Get("Access-Control-Allow-Credentials"))
+
+		allowed, header = allowCORS(checker, "https://evil.example.com")
+		assert.False(t, allowed)
+		assert.Empty(t, header.Get("Access-Control-Allow-Origin"))
+	})
+}
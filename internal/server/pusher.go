@@ -0,0 +1,883 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	pusherPrivatePrefix  = "private-"
+	pusherPresencePrefix = "presence-"
+	pusherClientPrefix   = "client-"
+
+	// pusherActivityTimeout is the number of seconds after which clients and
+	// the server ping each other when no message was received.
+	pusherActivityTimeout = 120
+	pusherMaxMessageSize  = 10 * 1024
+	pusherMaxBodySize     = 256 * 1024
+	// pusherMaxTriggerChannels and pusherMaxBatchSize are the limits of the
+	// Pusher HTTP API.
+	pusherMaxTriggerChannels = 100
+	pusherMaxBatchSize       = 10
+	// pusherSignatureMaxAge is how far the timestamp of a signed HTTP request
+	// may be from the server time.
+	pusherSignatureMaxAge = 600 * time.Second
+
+	// Close codes of the Pusher protocol.
+	pusherCloseApplicationNotFound  = 4001
+	pusherCloseUnsupportedProtocol  = 4007
+	pusherCloseReconnectImmediately = 4200
+)
+
+var pusherChannelNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-=@,.;]{1,164}$`)
+
+// PusherServer speaks the Pusher Channels protocol, so that pusher-js and the
+// Pusher server libraries can be pointed at the broadcaster. Pusher channel
+// names map to broadcaster channels, with dots as segment separators and the
+// `presence-` prefix standing for `presence:`.
+//
+// Private and presence channels are authorized with the signatures of the
+// Pusher app secret. Public channels are only open when they match one of the
+// public channel patterns.
+type PusherServer struct {
+	logger         *zap.Logger
+	upgrader       *websocket.Upgrader
+	registry       broadcaster.Registry
+	router         *Router
+	publishHandler handler.PublishHandlerInterface
+	appId          string
+	key            string
+	secret         string
+	// publicChannels are the patterns of the broadcaster channels anyone may
+	// subscribe to without a signature.
+	publicChannels []string
+	// triggerAuthentication is the authentication of the requests signed with
+	// the app secret.
+	triggerAuthentication *auth.Authentication
+}
+
+func NewPusherServer(
+	logger *zap.Logger,
+	upgrader *websocket.Upgrader,
+	registry broadcaster.Registry,
+	router *Router,
+	publishHandler handler.PublishHandlerInterface,
+	appId string,
+	key string,
+	secret string,
+	publicChannels []string,
+) *PusherServer {
+	return &PusherServer{
+		logger:         logger,
+		upgrader:       upgrader,
+		registry:       registry,
+		router:         router,
+		publishHandler: publishHandler,
+		appId:          appId,
+		key:            key,
+		secret:         secret,
+		publicChannels: publicChannels,
+		triggerAuthentication: &auth.Authentication{
+			Subject:            "pusher:" + appId,
+			Scope:              []string{auth.ScopePublish},
+			AuthorizedChannels: []string{pattern.MultiWildcard},
+		},
+	}
+}
+
+// Register adds the Pusher routes, which clients expect at the root of the
+// server.
+func (s *PusherServer) Register(router *mux.Router) {
+	router.HandleFunc("/app/{key}", s.serveWebSocket).Methods("GET")
+	router.HandleFunc("/apps/{appId}/events", s.verifySignature(s.triggerEvents)).Methods("POST")
+	router.HandleFunc("/apps/{appId}/batch_events", s.verifySignature(s.triggerBatchEvents)).Methods("POST")
+}
+
+// pusherEvent is a message of the Pusher protocol read from clients.
+type pusherEvent struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// pusherMessage is a message of the Pusher protocol sent to clients. The data
+// of events is a JSON-encoded string, while the data of the `pusher:` events
+// is an object.
+type pusherMessage struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel,omitempty"`
+	Data    any    `json:"data"`
+}
+
+type pusherError struct {
+	Message string `json:"message"`
+	Code    *int   `json:"code"`
+}
+
+type pusherSubscriptionError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+type pusherSubscribeData struct {
+	Channel     string `json:"channel"`
+	Auth        string `json:"auth"`
+	ChannelData string `json:"channel_data"`
+}
+
+type pusherChannelData struct {
+	UserId   json.RawMessage `json:"user_id"`
+	UserInfo any             `json:"user_info,omitempty"`
+}
+
+type pusherMember struct {
+	UserId   string `json:"user_id"`
+	UserInfo any    `json:"user_info,omitempty"`
+}
+
+type pusherPresence struct {
+	Ids   []string       `json:"ids"`
+	Hash  map[string]any `json:"hash"`
+	Count int            `json:"count"`
+}
+
+type pusherConn struct {
+	server     *PusherServer
+	connection *broadcaster.Connection
+	send       chan pusherMessage
+	// closed is closed once the connection is disconnected from the
+	// registry, after which nothing is sent.
+	closed chan struct{}
+	// writeDone is closed once writePump returns, after which nothing is
+	// written anymore.
+	writeDone chan struct{}
+	// channels maps the Pusher names of the subscribed channels to the
+	// broadcaster channels.
+	channels map[string]string
+	// permissions are the grants of the subscribed channels, from which the
+	// authentication of the connection is rebuilt.
+	permissions map[string][]string
+	// userId is the user of the presence channels, if any.
+	userId string
+}
+
+func (s *PusherServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warn("failed to upgrade to websocket", zap.Error(err))
+
+		return
+	}
+
+	// Pusher reports connection errors over the socket, so that clients know
+	// not to reconnect.
+	if mux.Vars(r)["key"] != s.key {
+		s.closeWithError(wsConn, pusherCloseApplicationNotFound, "application does not exist")
+
+		return
+	}
+
+	protocol, _ := strconv.Atoi(r.URL.Query().Get("protocol"))
+	if protocol < 5 || protocol > 7 {
+		s.closeWithError(wsConn, pusherCloseUnsupportedProtocol, "unsupported protocol version")
+
+		return
+	}
+
+	socketId := fmt.Sprintf("%d.%d", rand.Uint32(), rand.Uint32())
+	broadcasterChannel := make(chan broadcaster.Message, 1024)
+
+	broadcasterConn := &broadcaster.Connection{
+		Id:   socketId,
+		Send: broadcasterChannel,
+		Seq:  0,
+	}
+
+	s.registry.Connect(broadcasterConn)
+
+	ctx := broadcaster.WithConnection(r.Context(), broadcasterConn)
+
+	c := &pusherConn{
+		server:      s,
+		connection:  broadcasterConn,
+		send:        make(chan pusherMessage, 1024),
+		closed:      make(chan struct{}),
+		writeDone:   make(chan struct{}),
+		channels:    make(map[string]string),
+		permissions: make(map[string][]string),
+	}
+
+	established, _ := json.Marshal(map[string]any{
+		"socket_id":        socketId,
+		"activity_timeout": pusherActivityTimeout,
+	})
+	c.write(pusherMessage{
+		Event: "pusher:connection_established",
+		Data:  string(established),
+	})
+
+	go c.readPump(ctx, wsConn)
+	go c.writePump(ctx, wsConn)
+
+	for message := range broadcasterChannel {
+		event, err := pusherMessageFromBroadcast(message)
+		if err != nil {
+			s.logger.Error("failed to convert message",
+				zap.String("connectionId", socketId), zap.Error(err))
+
+			continue
+		}
+
+		c.write(event)
+	}
+
+	close(c.closed)
+
+	s.logger.Info("pusher connection closed", zap.String("connectionId", socketId))
+}
+
+func (s *PusherServer) closeWithError(wsConn *websocket.Conn, code int, message string) {
+	defer wsConn.Close()
+
+	wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	wsConn.WriteJSON(pusherMessage{
+		Event: "pusher:error",
+		Data:  pusherError{Message: message, Code: &code},
+	})
+	wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, message))
+}
+
+func (c *pusherConn) readPump(ctx context.Context, wsConn *websocket.Conn) {
+	defer func() {
+		c.server.registry.Disconnect(c.connection.Id)
+	}()
+
+	readTimeout := 2 * pusherActivityTimeout * time.Second
+
+	wsConn.SetReadLimit(pusherMaxMessageSize)
+	wsConn.SetReadDeadline(time.Now().Add(readTimeout))
+
+	for {
+		var event pusherEvent
+
+		err := wsConn.ReadJSON(&event)
+		if err != nil {
+			isExpectedClose := websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure)
+			if !isExpectedClose {
+				c.server.logger.Error("failed to read pusher event", zap.Error(err))
+			}
+
+			return
+		}
+
+		wsConn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		switch {
+		case event.Event == "pusher:ping":
+			c.write(pusherMessage{Event: "pusher:pong", Data: struct{}{}})
+		case event.Event == "pusher:pong":
+		case event.Event == "pusher:subscribe":
+			c.subscribe(ctx, event.Data)
+		case event.Event == "pusher:unsubscribe":
+			c.unsubscribe(ctx, event.Data)
+		case strings.HasPrefix(event.Event, pusherClientPrefix):
+			c.trigger(ctx, event)
+		default:
+			c.server.logger.Debug("ignoring unsupported pusher event",
+				zap.String("connectionId", c.connection.Id), zap.String("event", event.Event))
+		}
+	}
+}
+
+func (c *pusherConn) writePump(ctx context.Context, wsConn *websocket.Conn) {
+	defer func() {
+		_ = wsConn.Close()
+		close(c.writeDone)
+	}()
+
+	// The server pings the clients that only receive messages, which do not
+	// ping by themselves.
+	pingTicker := time.NewTicker(pusherActivityTimeout * time.Second)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case message := <-c.send:
+			wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err := wsConn.WriteJSON(message)
+			if err != nil {
+				c.server.logger.Error("failed to send pusher event", zap.Error(err))
+
+				return
+			}
+
+			pingTicker.Reset(pusherActivityTimeout * time.Second)
+		case <-pingTicker.C:
+			wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err := wsConn.WriteJSON(pusherMessage{Event: "pusher:ping", Data: struct{}{}})
+			if err != nil {
+				return
+			}
+		case <-c.closed:
+			// Clients reconnect after the generic close code, but not after the
+			// close reasons of the registry.
+			code := pusherCloseReconnectImmediately
+			text := "connection closed"
+			if reason, ok := c.connection.CloseReason(); ok {
+				code = reason.Code
+				text = reason.Text
+			}
+
+			wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			wsConn.WriteJSON(pusherMessage{
+				Event: "pusher:error",
+				Data:  pusherError{Message: text, Code: &code},
+			})
+			wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write queues a message, unless the connection is closed or can no longer
+// be written to.
+func (c *pusherConn) write(message pusherMessage) {
+	select {
+	case c.send <- message:
+	case <-c.closed:
+	case <-c.writeDone:
+	}
+}
+
+func (c *pusherConn) sendError(message string) {
+	c.write(pusherMessage{
+		Event: "pusher:error",
+		Data:  pusherError{Message: message},
+	})
+}
+
+func (c *pusherConn) subscribe(ctx context.Context, data json.RawMessage) {
+	var req pusherSubscribeData
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		c.sendError("invalid subscribe data")
+
+		return
+	}
+
+	err = c.authorize(req)
+	if err == nil {
+		_, err = c.server.router.Handle(ctx, "subscribe", routerParams(handler.SubscribeRequest{
+			Channel:  channelFromPusher(req.Channel),
+			Metadata: c.metadata(req),
+		}))
+	}
+
+	if err != nil {
+		handlerErr := c.server.router.mapError(err)
+
+		c.write(pusherMessage{
+			Event:   "pusher:subscription_error",
+			Channel: req.Channel,
+			Data: pusherSubscriptionError{
+				Error:  handlerErr.Message,
+				Status: httpStatusFromError(handlerErr),
+			},
+		})
+
+		return
+	}
+
+	channel := channelFromPusher(req.Channel)
+	c.channels[req.Channel] = channel
+
+	succeeded := []byte("{}")
+	if strings.HasPrefix(req.Channel, pusherPresencePrefix) {
+		succeeded, err = json.Marshal(map[string]any{
+			"presence": presenceToPusher(c.server.registry.Presence(channel)),
+		})
+		if err != nil {
+			c.server.logger.Error("failed to encode presence", zap.Error(err))
+		}
+	}
+
+	c.write(pusherMessage{
+		Event:   "pusher_internal:subscription_succeeded",
+		Channel: req.Channel,
+		Data:    string(succeeded),
+	})
+}
+
+// authorize checks the signature of a private or presence channel, or that a
+// public channel is open, and grants the channel to the connection.
+func (c *pusherConn) authorize(req pusherSubscribeData) error {
+	if !pusherChannelNameRegexp.MatchString(req.Channel) {
+		return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid channel name"))
+	}
+
+	if _, ok := c.channels[req.Channel]; ok {
+		return ierr.New(ierr.ErrorCodeAlreadyExists, errors.New("already subscribed to channel"))
+	}
+
+	channel := channelFromPusher(req.Channel)
+	actions := []string{auth.ScopeSubscribe}
+
+	switch {
+	case strings.HasPrefix(req.Channel, pusherPresencePrefix):
+		var channelData pusherChannelData
+		err := json.Unmarshal([]byte(req.ChannelData), &channelData)
+		if err != nil {
+			return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid channel data"))
+		}
+
+		userId, err := pusherUserId(channelData.UserId)
+		if err != nil {
+			return err
+		}
+
+		if !c.server.verifyChannelAuth(req.Auth, c.connection.Id, req.Channel, req.ChannelData) {
+			return ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("invalid signature"))
+		}
+
+		c.userId = userId
+		actions = append(actions, auth.ScopePublish)
+	case strings.HasPrefix(req.Channel, pusherPrivatePrefix):
+		if !c.server.verifyChannelAuth(req.Auth, c.connection.Id, req.Channel) {
+			return ierr.New(ierr.ErrorCodeUnauthenticated, errors.New("invalid signature"))
+		}
+
+		actions = append(actions, auth.ScopePublish)
+	default:
+		isPublic := slices.ContainsFunc(c.server.publicChannels, func(publicChannel string) bool {
+			return pattern.Matches(publicChannel, channel)
+		})
+		if !isPublic {
+			return ierr.New(ierr.ErrorCodePermissionDenied, errors.New("channel is not public"))
+		}
+	}
+
+	c.permissions[channel] = actions
+	c.updateAuthentication()
+
+	return nil
+}
+
+// updateAuthentication replaces the authentication of the connection, which
+// must not be changed once used, with the current permissions.
+func (c *pusherConn) updateAuthentication() {
+	subject := c.userId
+	if subject == "" {
+		subject = c.connection.Id
+	}
+
+	c.connection.SetAuthentication(&auth.Authentication{
+		Subject:     subject,
+		Permissions: maps.Clone(c.permissions),
+	})
+}
+
+func (c *pusherConn) metadata(req pusherSubscribeData) any {
+	if !strings.HasPrefix(req.Channel, pusherPresencePrefix) {
+		return nil
+	}
+
+	var channelData pusherChannelData
+	_ = json.Unmarshal([]byte(req.ChannelData), &channelData)
+
+	return channelData.UserInfo
+}
+
+func (c *pusherConn) unsubscribe(ctx context.Context, data json.RawMessage) {
+	var req pusherSubscribeData
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		c.sendError("invalid unsubscribe data")
+
+		return
+	}
+
+	channel, ok := c.channels[req.Channel]
+	if !ok {
+		return
+	}
+
+	_, err = c.server.router.Handle(ctx, "unsubscribe", routerParams(handler.UnsubscribeRequest{
+		Channel: channel,
+	}))
+	if err != nil {
+		c.sendError(c.server.router.mapError(err).Message)
+
+		return
+	}
+
+	delete(c.channels, req.Channel)
+	delete(c.permissions, channel)
+	c.updateAuthentication()
+}
+
+// trigger publishes a client event to the other subscribers of a private or
+// presence channel.
+func (c *pusherConn) trigger(ctx context.Context, event pusherEvent) {
+	channel, ok := c.channels[event.Channel]
+	isAuthorizedChannel := strings.HasPrefix(event.Channel, pusherPrivatePrefix) ||
+		strings.HasPrefix(event.Channel, pusherPresencePrefix)
+	if !ok || !isAuthorizedChannel {
+		c.sendError("client events are only allowed on subscribed private and presence channels")
+
+		return
+	}
+
+	var payload any
+	err := json.Unmarshal(event.Data, &payload)
+	if err != nil {
+		c.sendError("invalid event data")
+
+		return
+	}
+
+	if data, ok := payload.(string); ok {
+		payload = payloadFromPusher(data)
+	}
+
+	_, err = c.server.router.Handle(ctx, "publish", routerParams(handler.PublishRequest{
+		Channel:              channel,
+		Event:                event.Event,
+		Payload:              payload,
+		ExcludedConnectionId: c.connection.Id,
+	}))
+	if err != nil {
+		c.sendError(c.server.router.mapError(err).Message)
+	}
+}
+
+// verifyChannelAuth checks the `<key>:<signature>` auth string of a private
+// or presence channel subscription, which signs the socket id, the channel and
+// the channel data joined with colons.
+func (s *PusherServer) verifyChannelAuth(authString string, parts ...string) bool {
+	key, signature, ok := strings.Cut(authString, ":")
+	if !ok || key != s.key {
+		return false
+	}
+
+	return s.verify(signature, strings.Join(parts, ":"))
+}
+
+func (s *PusherServer) verify(signature string, value string) bool {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(value))
+
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+// verifySignature authenticates the requests of the Pusher HTTP API, whose
+// query is signed with the app secret.
+func (s *PusherServer) verifySignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["appId"] != s.appId {
+			http.Error(w, "unknown app id", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, pusherMaxBodySize))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		query := r.URL.Query()
+
+		if query.Get("auth_key") != s.key {
+			http.Error(w, "invalid auth key", http.StatusUnauthorized)
+			return
+		}
+
+		timestamp, err := strconv.ParseInt(query.Get("auth_timestamp"), 10, 64)
+		if err != nil || time.Since(time.Unix(timestamp, 0)).Abs() > pusherSignatureMaxAge {
+			http.Error(w, "invalid auth timestamp", http.StatusUnauthorized)
+			return
+		}
+
+		if len(body) > 0 {
+			bodyMD5 := md5.Sum(body)
+			if query.Get("body_md5") != hex.EncodeToString(bodyMD5[:]) {
+				http.Error(w, "invalid body md5", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if !s.verify(query.Get("auth_signature"), pusherStringToSign(r.Method, r.URL.Path, query)) {
+			http.Error(w, "invalid auth signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		ctx := auth.WithAuthentication(r.Context(), s.triggerAuthentication)
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// pusherStringToSign is the method, the path and the sorted query parameters
+// but the signature, as signed by the Pusher server libraries.
+func pusherStringToSign(method string, path string, query map[string][]string) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		if key == "auth_signature" || len(values) == 0 {
+			continue
+		}
+
+		params = append(params, strings.ToLower(key)+"="+values[0])
+	}
+
+	slices.Sort(params)
+
+	return method + "\n" + path + "\n" + strings.Join(params, "&")
+}
+
+type pusherTriggerRequest struct {
+	Name     string   `json:"name"`
+	Data     string   `json:"data"`
+	Channels []string `json:"channels"`
+	Channel  string   `json:"channel"`
+	SocketId string   `json:"socket_id"`
+}
+
+func (s *PusherServer) triggerEvents(w http.ResponseWriter, r *http.Request) {
+	var req pusherTriggerRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Channels) > pusherMaxTriggerChannels {
+		http.Error(w, fmt.Sprintf("at most %d channels can be triggered at once", pusherMaxTriggerChannels), http.StatusBadRequest)
+		return
+	}
+
+	if !s.trigger(w, r, req) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func (s *PusherServer) triggerBatchEvents(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Batch []pusherTriggerRequest `json:"batch"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Batch) > pusherMaxBatchSize {
+		http.Error(w, fmt.Sprintf("at most %d events can be triggered at once", pusherMaxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	for _, triggerRequest := range req.Batch {
+		if len(triggerRequest.Channels) > 0 {
+			http.Error(w, "batch events have a single channel", http.StatusBadRequest)
+			return
+		}
+
+		if !s.trigger(w, r, triggerRequest) {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// trigger publishes the event on its channels, and reports whether it was
+// published on all of them.
+func (s *PusherServer) trigger(w http.ResponseWriter, r *http.Request, req pusherTriggerRequest) bool {
+	channels := req.Channels
+	if req.Channel != "" {
+		channels = append(channels, req.Channel)
+	}
+
+	if len(channels) == 0 {
+		http.Error(w, "at least one channel is required", http.StatusBadRequest)
+		return false
+	}
+
+	for _, channel := range channels {
+		if !pusherChannelNameRegexp.MatchString(channel) {
+			http.Error(w, "invalid channel name: "+channel, http.StatusBadRequest)
+			return false
+		}
+
+		_, err := s.publishHandler.Handle(r.Context(), handler.PublishRequest{
+			Channel:              channelFromPusher(channel),
+			Event:                req.Name,
+			Payload:              payloadFromPusher(req.Data),
+			ExcludedConnectionId: req.SocketId,
+		})
+		if err != nil {
+			s.logger.Error("failed to handle pusher trigger request", zap.Error(err))
+			http.Error(w, "failed to handle trigger request", httpStatusFromError(err))
+			return false
+		}
+	}
+
+	return true
+}
+
+// routerParams passes a request translated from another protocol to the
+// handlers of the router.
+func routerParams(params any) func(v any) error {
+	return func(v any) error {
+		target := reflect.ValueOf(v).Elem()
+
+		value := reflect.ValueOf(params)
+		if !value.Type().AssignableTo(target.Type()) {
+			return ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid params"))
+		}
+
+		target.Set(value)
+
+		return nil
+	}
+}
+
+func pusherUserId(rawUserId json.RawMessage) (string, error) {
+	var userId string
+	err := json.Unmarshal(rawUserId, &userId)
+	if err != nil {
+		// Numeric user ids are kept as written.
+		var number json.Number
+		if json.Unmarshal(rawUserId, &number) != nil {
+			return "", ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("invalid user id"))
+		}
+
+		userId = number.String()
+	}
+
+	if userId == "" {
+		return "", ierr.New(ierr.ErrorCodeInvalidArgument, errors.New("user id is required"))
+	}
+
+	return userId, nil
+}
+
+func channelFromPusher(name string) string {
+	if userChannel, ok := strings.CutPrefix(name, pusherPresencePrefix); ok {
+		name = broadcaster.PresenceChannelPrefix + userChannel
+	}
+
+	return strings.ReplaceAll(name, ".", pattern.Separator)
+}
+
+func channelToPusher(channel string) string {
+	if userChannel, ok := strings.CutPrefix(channel, broadcaster.PresenceChannelPrefix); ok {
+		channel = pusherPresencePrefix + userChannel
+	}
+
+	return strings.ReplaceAll(channel, pattern.Separator, ".")
+}
+
+// payloadFromPusher decodes JSON objects and arrays, so that the clients of
+// the other transports receive them as such, and keeps other data as strings.
+func payloadFromPusher(data string) any {
+	trimmed := strings.TrimSpace(data)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return data
+	}
+
+	var payload any
+	if json.Unmarshal([]byte(trimmed), &payload) != nil {
+		return data
+	}
+
+	return payload
+}
+
+func payloadToPusher(payload any) (string, error) {
+	if data, ok := payload.(string); ok {
+		return data, nil
+	}
+
+	rawJson, err := json.Marshal(payload)
+
+	return string(rawJson), err
+}
+
+func presenceToPusher(members []broadcaster.PresenceMember) pusherPresence {
+	presence := pusherPresence{
+		Ids:   make([]string, 0, len(members)),
+		Hash:  make(map[string]any, len(members)),
+		Count: len(members),
+	}
+
+	for _, member := range members {
+		presence.Ids = append(presence.Ids, member.UserId)
+		presence.Hash[member.UserId] = member.Metadata
+	}
+
+	return presence
+}
+
+// pusherMessageFromBroadcast converts a message to a Pusher event, and presence
+// events to the member events of Pusher.
+func pusherMessageFromBroadcast(message broadcaster.Message) (pusherMessage, error) {
+	event := pusherMessage{
+		Event:   message.Event,
+		Channel: channelToPusher(message.Channel),
+	}
+
+	payload := message.Payload
+
+	switch member, isMember := message.Payload.(broadcaster.PresenceMember); {
+	case isMember && message.Event == broadcaster.PresenceJoinEvent:
+		event.Event = "pusher_internal:member_added"
+		payload = pusherMember{UserId: member.UserId, UserInfo: member.Metadata}
+	case isMember && message.Event == broadcaster.PresenceLeaveEvent:
+		event.Event = "pusher_internal:member_removed"
+		payload = pusherMember{UserId: member.UserId}
+	case event.Event == "":
+		event.Event = "broadcast"
+	}
+
+	data, err := payloadToPusher(payload)
+	if err != nil {
+		return pusherMessage{}, err
+	}
+
+	event.Data = data
+
+	return event, nil
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func pusherSign(secret string, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPusherServer(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+	publishHandler := handler.NewPublishHandler(channelValidator, registry)
+
+	router := NewRouter(
+		logger,
+		handler.NewHeartbeatHandler(),
+		handler.NewSubscribeHandler(channelValidator, registry, 100),
+		handler.NewUnsubscribeHandler(channelValidator, registry),
+		publishHandler,
+		handler.NewAuthHandler(authenticator),
+		handler.NewResumeHandler(channelValidator, registry),
+		handler.NewPresenceHandler(channelValidator, registry),
+		handler.NewRefreshHandler(authenticator, registry),
+	)
+
+	pusherServer := NewPusherServer(
+		logger,
+		&websocket.Upgrader{},
+		registry,
+		router,
+		publishHandler,
+		"app-1",
+		"app-key",
+		"app-secret",
+		[]string{"news:**"},
+	)
+
+	mainRouter := mux.NewRouter()
+	pusherServer.Register(mainRouter)
+
+	server := httptest.NewServer(mainRouter)
+	defer server.Close()
+
+	read := func(t *testing.T, conn *websocket.Conn) pusherEvent {
+		t.Helper()
+
+		var event pusherEvent
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		err := conn.ReadJSON(&event)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		return event
+	}
+
+	dataString := func(t *testing.T, event pusherEvent) string {
+		t.Helper()
+
+		var data string
+		assert.NoError(t, json.Unmarshal(event.Data, &data))
+
+		return data
+	}
+
+	connect := func(t *testing.T) (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/app/app-key?protocol=7", nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		established := read(t, conn)
+		assert.Equal(t, "pusher:connection_established", established.Event)
+
+		var data struct {
+			SocketId string `json:"socket_id"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(dataString(t, established)), &data))
+
+		return conn, data.SocketId
+	}
+
+	subscribe := func(t *testing.T, conn *websocket.Conn, data map[string]string) pusherEvent {
+		err := conn.WriteJSON(map[string]any{"event": "pusher:subscribe", "data": data})
+		assert.NoError(t, err)
+
+		return read(t, conn)
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/app/other-key?protocol=7", nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer conn.Close()
+
+		event := read(t, conn)
+		assert.Equal(t, "pusher:error", event.Event)
+		assert.JSONEq(t, `{"message":"application does not exist","code":4001}`, string(event.Data))
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, pusherCloseApplicationNotFound))
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		conn, _ := connect(t)
+
+		assert.NoError(t, conn.WriteJSON(map[string]any{"event": "pusher:ping", "data": map[string]any{}}))
+		assert.Equal(t, "pusher:pong", read(t, conn).Event)
+	})
+
+	t.Run("public channel", func(t *testing.T) {
+		conn, _ := connect(t)
+
+		succeeded := subscribe(t, conn, map[string]string{"channel": "news.sports"})
+		assert.Equal(t, "pusher_internal:subscription_succeeded", succeeded.Event)
+		assert.Equal(t, "news.sports", succeeded.Channel)
+
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "news:sports", Event: "goal", Payload: map[string]any{"score": 1}})
+
+		event := read(t, conn)
+		assert.Equal(t, "goal", event.Event)
+		assert.Equal(t, "news.sports", event.Channel)
+		assert.JSONEq(t, `{"score":1}`, dataString(t, event))
+
+		failed := subscribe(t, conn, map[string]string{"channel": "secret"})
+		assert.Equal(t, "pusher:subscription_error", failed.Event)
+		assert.JSONEq(t, `{"error":"channel is not public","status":403}`, string(failed.Data))
+	})
+
+	t.Run("private channel", func(t *testing.T) {
+		conn, socketId := connect(t)
+
+		failed := subscribe(t, conn, map[string]string{"channel": "private-chat", "auth": "app-key:invalid"})
+		assert.Equal(t, "pusher:subscription_error", failed.Event)
+
+		succeeded := subscribe(t, conn, map[string]string{
+			"channel": "private-chat",
+			"auth":    "app-key:" + pusherSign("app-secret", socketId+":private-chat"),
+		})
+		assert.Equal(t, "pusher_internal:subscription_succeeded", succeeded.Event)
+
+		other, otherSocketId := connect(t)
+		subscribe(t, other, map[string]string{
+			"channel": "private-chat",
+			"auth":    "app-key:" + pusherSign("app-secret", otherSocketId+":private-chat"),
+		})
+
+		err := conn.WriteJSON(map[string]any{"event": "client-typing", "channel": "private-chat", "data": map[string]any{"typing": true}})
+		assert.NoError(t, err)
+
+		event := read(t, other)
+		assert.Equal(t, "client-typing", event.Event)
+		assert.JSONEq(t, `{"typing":true}`, dataString(t, event))
+
+		// The sender does not receive its own client events.
+		assert.NoError(t, conn.WriteJSON(map[string]any{"event": "pusher:ping"}))
+		assert.Equal(t, "pusher:pong", read(t, conn).Event)
+	})
+
+	t.Run("client event on public channel", func(t *testing.T) {
+		conn, _ := connect(t)
+		subscribe(t, conn, map[string]string{"channel": "news.weather"})
+
+		err := conn.WriteJSON(map[string]any{"event": "client-hello", "channel": "news.weather", "data": "hello"})
+		assert.NoError(t, err)
+
+		event := read(t, conn)
+		assert.Equal(t, "pusher:error", event.Event)
+	})
+
+	t.Run("presence channel", func(t *testing.T) {
+		join := func(t *testing.T, userId string) (*websocket.Conn, pusherEvent) {
+			conn, socketId := connect(t)
+			channelData := `{"user_id":"` + userId + `","user_info":{"name":"` + userId + `"}}`
+
+			return conn, subscribe(t, conn, map[string]string{
+				"channel":      "presence-room.1",
+				"auth":         "app-key:" + pusherSign("app-secret", socketId+":presence-room.1:"+channelData),
+				"channel_data": channelData,
+			})
+		}
+
+		alice, succeeded := join(t, "alice")
+		assert.Equal(t, "pusher_internal:subscription_succeeded", succeeded.Event)
+		assert.JSONEq(t, `{"presence":{"ids":["alice"],"hash":{"alice":{"name":"alice"}},"count":1}}`, dataString(t, succeeded))
+
+		bob, succeeded := join(t, "bob")
+		assert.Contains(t, dataString(t, succeeded), `"count":2`)
+
+		added := read(t, alice)
+		assert.Equal(t, "pusher_internal:member_added", added.Event)
+		assert.Equal(t, "presence-room.1", added.Channel)
+		assert.JSONEq(t, `{"user_id":"bob","user_info":{"name":"bob"}}`, dataString(t, added))
+
+		bob.Close()
+
+		removed := read(t, alice)
+		assert.Equal(t, "pusher_internal:member_removed", removed.Event)
+		assert.JSONEq(t, `{"user_id":"bob"}`, dataString(t, removed))
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		conn, socketId := connect(t)
+
+		registry.DisconnectMatching(func(connection *broadcaster.Connection) bool {
+			return connection.Id == socketId
+		}, broadcaster.CloseReasonRevoked)
+
+		event := read(t, conn)
+		assert.Equal(t, "pusher:error", event.Event)
+
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, broadcaster.CloseReasonRevoked.Code))
+	})
+
+	trigger := func(t *testing.T, path string, body string, secret string) *http.Response {
+		bodyMD5 := md5.Sum([]byte(body))
+
+		query := url.Values{}
+		query.Set("auth_key", "app-key")
+		query.Set("auth_timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		query.Set("auth_version", "1.0")
+		query.Set("body_md5", hex.EncodeToString(bodyMD5[:]))
+		query.Set("auth_signature", pusherSign(secret, pusherStringToSign("POST", path, query)))
+
+		response, err := http.Post(server.URL+path+"?"+query.Encode(), "application/json", bytes.NewBufferString(body))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { response.Body.Close() })
+
+		return response
+	}
+
+	t.Run("trigger", func(t *testing.T) {
+		conn, socketId := connect(t)
+		subscribe(t, conn, map[string]string{"channel": "news.tech"})
+
+		response := trigger(t, "/apps/app-1/events", `{"name":"article","data":"{\"title\":\"Go\"}","channels":["news.tech","news.science"]}`, "app-secret")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		event := read(t, conn)
+		assert.Equal(t, "article", event.Event)
+		assert.JSONEq(t, `{"title":"Go"}`, dataString(t, event))
+
+		stored, err := registry.History("news:science", broadcaster.HistoryQuery{})
+		assert.NoError(t, err)
+		if assert.Len(t, stored, 1) {
+			assert.Equal(t, map[string]any{"title": "Go"}, stored[0].Payload)
+		}
+
+		// Events triggered with the socket id of a connection skip it.
+		response = trigger(t, "/apps/app-1/batch_events", `{"batch":[{"name":"skipped","data":"a","channel":"news.tech","socket_id":"`+socketId+`"},{"name":"delivered","data":"b","channel":"news.tech"}]}`, "app-secret")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		event = read(t, conn)
+		assert.Equal(t, "delivered", event.Event)
+		assert.Equal(t, "b", dataString(t, event))
+	})
+
+	t.Run("trigger with invalid signature", func(t *testing.T) {
+		response := trigger(t, "/apps/app-1/events", `{"name":"article","data":"{}","channel":"news.tech"}`, "other-secret")
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+		response = trigger(t, "/apps/app-2/events", `{"name":"article","data":"{}","channel":"news.tech"}`, "app-secret")
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+}
+
+func TestPusherChannel(t *testing.T) {
+	for name, channel := range map[string]string{
+		"news":            "news",
+		"news.sports":     "news:sports",
+		"private-user.42": "private-user:42",
+		"presence-room.1": "presence:room:1",
+	} {
+		assert.Equal(t, channel, channelFromPusher(name))
+		assert.Equal(t, name, channelToPusher(channel))
+	}
+}
+
+func TestPusherPayload(t *testing.T) {
+	assert.Equal(t, map[string]any{"a": float64(1)}, payloadFromPusher(`{"a":1}`))
+	assert.Equal(t, "42", payloadFromPusher("42"))
+	assert.Equal(t, "{not json", payloadFromPusher("{not json"))
+
+	data, err := payloadToPusher(map[string]any{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, data)
+
+	data, err = payloadToPusher("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", data)
+}
+
+func TestPusherConn_Write(t *testing.T) {
+	c := &pusherConn{
+		send:      make(chan pusherMessage),
+		closed:    make(chan struct{}),
+		writeDone: make(chan struct{}),
+	}
+
+	// Nothing drains the queue once the write pump is gone.
+	close(c.writeDone)
+
+	written := make(chan struct{})
+	go func() {
+		c.write(pusherMessage{Event: "pusher:pong"})
+		close(written)
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write blocked after the write pump returned")
+	}
+}
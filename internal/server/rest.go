@@ -2,11 +2,16 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
@@ -14,18 +19,27 @@ import (
 type RESTServer struct {
 	logger *zap.Logger
 
-	publishHandler   *handler.PublishHandler
-	authenticator *auth.Authenticator
+	publishHandler  *handler.PublishHandler
+	historyHandler  *handler.HistoryHandler
+	presenceHandler *handler.PresenceHandler
+	revokeHandler   *handler.RevokeHandler
+	authenticator   *auth.Authenticator
 }
 
 func NewRESTServer(
 	logger *zap.Logger,
 	publishHandler *handler.PublishHandler,
+	historyHandler *handler.HistoryHandler,
+	presenceHandler *handler.PresenceHandler,
+	revokeHandler *handler.RevokeHandler,
 	authenticator *auth.Authenticator,
 ) *RESTServer {
 	return &RESTServer{
 		logger,
 		publishHandler,
+		historyHandler,
+		presenceHandler,
+		revokeHandler,
 		authenticator,
 	}
 }
@@ -34,7 +48,7 @@ func (s *RESTServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -60,22 +74,53 @@ func (s *RESTServer) authenticationMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if !authentication.IsPublisher() {
-			http.Error(w, "invalid token for publisher authentication", http.StatusUnauthorized)
-			return
-		}
-
 		ctx := auth.WithAuthentication(r.Context(), authentication)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requireScope rejects the requests whose API key lacks the scope.
+func (s *RESTServer) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authentication, ok := auth.AuthenticationFromContext(r.Context())
+		if !ok || !authentication.HasScope(scope) {
+			http.Error(w, "api key not allowed to "+scope, http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requestLogger identifies the API key behind a request in the logs.
+func (s *RESTServer) requestLogger(r *http.Request) *zap.Logger {
+	authentication, ok := auth.AuthenticationFromContext(r.Context())
+	if !ok {
+		return s.logger
+	}
+
+	return s.logger.With(zap.String("apiKey", authentication.Subject))
+}
+
 func (s *RESTServer) Register(router *mux.Router) {
 	publishRouter := router.Methods("POST", "OPTIONS").Subrouter()
 	publishRouter.Use(s.corsMiddleware, s.authenticationMiddleware)
-	publishRouter.HandleFunc("/publish", func(w http.ResponseWriter, r *http.Request) {
+	publishRouter.HandleFunc("/publish", s.requireScope(auth.ScopePublish, func(w http.ResponseWriter, r *http.Request) {
+		// Bodies of other media types, such as the form content type sent by
+		// default by curl, are read as JSON.
+		requestCodec, ok := codecForContentType(r.Header.Get("Content-Type"))
+		if !ok {
+			requestCodec = JSONCodec
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+
 		var publishRequest handler.PublishRequest
-		err := json.NewDecoder(r.Body).Decode(&publishRequest)
+		err = requestCodec.Unmarshal(body, &publishRequest)
 		if err != nil {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
@@ -83,21 +128,140 @@ func (s *RESTServer) Register(router *mux.Router) {
 
 		publishResponse, err := s.publishHandler.Handle(r.Context(), publishRequest)
 		if err != nil {
-			s.logger.Error("failed to handle publish request", zap.Error(err))
-			http.Error(w, "failed to handle publish request", http.StatusInternalServerError)
+			s.requestLogger(r).Error("failed to handle publish request", zap.Error(err))
+			http.Error(w, "failed to handle publish request", httpStatusFromError(err))
 			return
 		}
 
+		s.writeResponse(w, responseCodec(r, requestCodec), publishResponse)
+	}))
+
+	publishRouter.HandleFunc("/revocations", s.requireScope(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		var revokeRequest handler.RevokeRequest
+		err := json.NewDecoder(r.Body).Decode(&revokeRequest)
+		if err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		revokeResponse, err := s.revokeHandler.Handle(r.Context(), revokeRequest)
+		if err != nil {
+			s.requestLogger(r).Error("failed to handle revoke request", zap.Error(err))
+			http.Error(w, "failed to handle revoke request", httpStatusFromError(err))
+			return
+		}
+
+		s.requestLogger(r).Info("revoked authentication",
+			zap.String("subject", revokeRequest.Subject),
+			zap.String("tokenId", revokeRequest.TokenId),
+			zap.Int("disconnected", revokeResponse.Disconnected))
+
 		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(publishResponse)
+		err = json.NewEncoder(w).Encode(revokeResponse)
 		if err != nil {
 			http.Error(w, "failed to encode response", http.StatusInternalServerError)
 			return
 		}
-	})
+	}))
+
+	historyRouter := router.Methods("GET", "OPTIONS").Subrouter()
+	historyRouter.Use(s.corsMiddleware, s.authenticationMiddleware)
+	historyRouter.HandleFunc("/channels/{id}/history", s.requireScope(auth.ScopeHistory, func(w http.ResponseWriter, r *http.Request) {
+		historyRequest := handler.HistoryRequest{
+			Channel: mux.Vars(r)["id"],
+		}
+
+		query := r.URL.Query()
+
+		if limit := query.Get("limit"); limit != "" {
+			value, err := strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+
+			historyRequest.Limit = value
+		}
+
+		if since := query.Get("since"); since != "" {
+			value, err := time.Parse(time.RFC3339Nano, since)
+			if err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+
+			historyRequest.Since = value
+		}
+
+		if afterOffset := query.Get("afterOffset"); afterOffset != "" {
+			value, err := strconv.ParseUint(afterOffset, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid afterOffset", http.StatusBadRequest)
+				return
+			}
+
+			historyRequest.AfterOffset = &value
+		}
+
+		historyResponse, err := s.historyHandler.Handle(r.Context(), historyRequest)
+		if err != nil {
+			s.requestLogger(r).Error("failed to handle history request", zap.Error(err))
+			http.Error(w, "failed to handle history request", httpStatusFromError(err))
+			return
+		}
+
+		s.writeResponse(w, responseCodec(r, JSONCodec), historyResponse)
+	}))
+
+	historyRouter.HandleFunc("/channels/{id}/presence", s.requireScope(auth.ScopePresence, func(w http.ResponseWriter, r *http.Request) {
+		presenceResponse, err := s.presenceHandler.Handle(r.Context(), handler.PresenceRequest{
+			Channel: mux.Vars(r)["id"],
+		})
+		if err != nil {
+			s.requestLogger(r).Error("failed to handle presence request", zap.Error(err))
+			http.Error(w, "failed to handle presence request", httpStatusFromError(err))
+			return
+		}
+
+		s.writeResponse(w, responseCodec(r, JSONCodec), presenceResponse)
+	}))
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	}).Methods("GET")
 }
+
+// writeResponse encodes the response with the codec.
+func (s *RESTServer) writeResponse(w http.ResponseWriter, codec Codec, response any) {
+	data, err := codec.Marshal(response)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Write(data)
+}
+
+func httpStatusFromError(err error) int {
+	var handlerErr ierr.Error
+	if !errors.As(err, &handlerErr) {
+		return http.StatusInternalServerError
+	}
+
+	switch handlerErr.Code {
+	case ierr.ErrorCodeInvalidArgument, ierr.ErrorCodeFailedPrecondition:
+		return http.StatusBadRequest
+	case ierr.ErrorCodeNotFound:
+		return http.StatusNotFound
+	case ierr.ErrorCodeAlreadyExists:
+		return http.StatusConflict
+	case ierr.ErrorCodePermissionDenied:
+		return http.StatusForbidden
+	case ierr.ErrorCodeUnauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/broadcaster"
@@ -15,14 +18,22 @@ import (
 	"go.uber.org/zap"
 )
 
+var testAPIKeys = []auth.APIKey{
+	{Name: "api", Hash: auth.HashAPIKey("test-api-key"), Scope: []string{auth.ScopePublish, auth.ScopeAdmin}},
+	{Name: "partner", Hash: auth.HashAPIKey("partner-api-key"), Scope: []string{auth.ScopePublish}, Channels: []string{"partner:*"}},
+}
+
 func TestRESTServer_Publish(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
-	authenticator := auth.NewAuthenticator("test-secret", []string{"test-api-key"})
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
 	registry := broadcaster.NewMockRegistry(t)
 	channelValidator := handler.NewChannelValidator()
 	publishHandler := handler.NewPublishHandler(channelValidator, registry)
+	historyHandler := handler.NewHistoryHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
+	revokeHandler := handler.NewRevokeHandler(auth.NewInMemoryRevocationList(), registry, time.Hour)
 
-	restServer := NewRESTServer(logger, publishHandler, authenticator)
+	restServer := NewRESTServer(logger, publishHandler, historyHandler, presenceHandler, revokeHandler, authenticator)
 
 	router := mux.NewRouter()
 	restServer.Register(router)
@@ -35,7 +46,10 @@ func TestRESTServer_Publish(t *testing.T) {
 
 		registry.On("Broadcast", mock.MatchedBy(func(msg broadcaster.Message) bool {
 			return msg.Channel == "test-channel" && msg.Event == "test-event" && msg.Payload == "test-payload"
-		})).Return().Once()
+		})).Return(func(msg broadcaster.Message) (broadcaster.Message, error) {
+			msg.Offset = 42
+			return msg, nil
+		}).Once()
 
 		req, _ := http.NewRequest("POST", server.URL+"/publish", bytes.NewBuffer([]byte(body)))
 		req.Header.Set("Authorization", "Bearer test-api-key")
@@ -46,6 +60,11 @@ func TestRESTServer_Publish(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 		registry.AssertExpectations(t)
+
+		var publishResponse broadcaster.Message
+		err = json.NewDecoder(resp.Body).Decode(&publishResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(42), publishResponse.Offset)
 	})
 
 	t.Run("invalid api key", func(t *testing.T) {
@@ -60,4 +79,246 @@ func TestRESTServer_Publish(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	})
+
+	t.Run("scoped api key", func(t *testing.T) {
+		registry.On("Broadcast", mock.MatchedBy(func(msg broadcaster.Message) bool {
+			return msg.Channel == "partner:news"
+		})).Return(func(msg broadcaster.Message) (broadcaster.Message, error) {
+			return msg, nil
+		}).Once()
+
+		publish := func(channel string) int {
+			body := `{"channel":"` + channel + `","event":"test-event","payload":"test-payload"}`
+
+			req, _ := http.NewRequest("POST", server.URL+"/publish", bytes.NewBuffer([]byte(body)))
+			req.Header.Set("Authorization", "Bearer partner-api-key")
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+
+			return resp.StatusCode
+		}
+
+		assert.Equal(t, http.StatusOK, publish("partner:news"))
+		assert.Equal(t, http.StatusForbidden, publish("test-channel"))
+		registry.AssertExpectations(t)
+	})
+
+	t.Run("binary encodings", func(t *testing.T) {
+		payload := []byte{0x00, 0xff, 0x10}
+
+		for _, codec := range []Codec{MsgpackCodec, CBORCodec} {
+			registry.On("Broadcast", mock.MatchedBy(func(msg broadcaster.Message) bool {
+				msgPayload, ok := msg.Payload.([]byte)
+
+				return msg.Channel == "binary-channel" && ok && bytes.Equal(msgPayload, payload)
+			})).Return(func(msg broadcaster.Message) (broadcaster.Message, error) {
+				return msg, nil
+			}).Once()
+
+			body, err := codec.Marshal(handler.PublishRequest{
+				Channel: "binary-channel",
+				Event:   "test-event",
+				Payload: payload,
+			})
+			assert.NoError(t, err)
+
+			req, _ := http.NewRequest("POST", server.URL+"/publish", bytes.NewBuffer(body))
+			req.Header.Set("Authorization", "Bearer test-api-key")
+			req.Header.Set("Content-Type", codec.ContentType())
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, codec.ContentType())
+			assert.Equal(t, codec.ContentType(), resp.Header.Get("Content-Type"))
+
+			responseBody, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			var publishResponse broadcaster.Message
+			err = codec.Unmarshal(responseBody, &publishResponse)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, publishResponse.Payload)
+		}
+
+		registry.AssertExpectations(t)
+	})
+}
+
+func TestRESTServer_History(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	registry := broadcaster.NewMockRegistry(t)
+	channelValidator := handler.NewChannelValidator()
+	publishHandler := handler.NewPublishHandler(channelValidator, registry)
+	historyHandler := handler.NewHistoryHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
+	revokeHandler := handler.NewRevokeHandler(auth.NewInMemoryRevocationList(), registry, time.Hour)
+
+	restServer := NewRESTServer(logger, publishHandler, historyHandler, presenceHandler, revokeHandler, authenticator)
+
+	router := mux.NewRouter()
+	restServer.Register(router)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("valid api key", func(t *testing.T) {
+		messages := []broadcaster.Message{
+			{Id: "msg-1", Channel: "test-channel", Event: "test-event", Payload: "first"},
+			{Id: "msg-2", Channel: "test-channel", Event: "test-event", Payload: "second"},
+		}
+
+		registry.On("History", "test-channel", broadcaster.HistoryQuery{Limit: 2}).Return(messages, nil).Once()
+
+		req, _ := http.NewRequest("GET", server.URL+"/channels/test-channel/history?limit=2", nil)
+		req.Header.Set("Authorization", "Bearer test-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var historyResponse handler.HistoryResponse
+		err = json.NewDecoder(resp.Body).Decode(&historyResponse)
+		assert.NoError(t, err)
+		assert.Len(t, historyResponse.Messages, 2)
+		assert.Equal(t, "msg-1", historyResponse.Messages[0].Id)
+		assert.Equal(t, "msg-2", historyResponse.Messages[1].Id)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/channels/test-channel/history?limit=-1", nil)
+		req.Header.Set("Authorization", "Bearer test-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("invalid api key", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/channels/test-channel/history", nil)
+		req.Header.Set("Authorization", "Bearer invalid-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestRESTServer_Presence(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	registry := broadcaster.NewMockRegistry(t)
+	channelValidator := handler.NewChannelValidator()
+	publishHandler := handler.NewPublishHandler(channelValidator, registry)
+	historyHandler := handler.NewHistoryHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
+	revokeHandler := handler.NewRevokeHandler(auth.NewInMemoryRevocationList(), registry, time.Hour)
+
+	restServer := NewRESTServer(logger, publishHandler, historyHandler, presenceHandler, revokeHandler, authenticator)
+
+	router := mux.NewRouter()
+	restServer.Register(router)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("valid api key", func(t *testing.T) {
+		members := []broadcaster.PresenceMember{
+			{UserId: "alice", Metadata: map[string]any{"name": "Alice"}},
+		}
+
+		registry.On("Presence", "presence:room").Return(members).Once()
+
+		req, _ := http.NewRequest("GET", server.URL+"/channels/presence:room/presence", nil)
+		req.Header.Set("Authorization", "Bearer test-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var presenceResponse handler.PresenceResponse
+		err = json.NewDecoder(resp.Body).Decode(&presenceResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, members[0].UserId, presenceResponse.Members[0].UserId)
+		assert.Equal(t, members[0].Metadata, presenceResponse.Members[0].Metadata)
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/channels/presence:partner/presence", nil)
+		req.Header.Set("Authorization", "Bearer partner-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("not a presence channel", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/channels/test-channel/presence", nil)
+		req.Header.Set("Authorization", "Bearer test-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestRESTServer_Revoke(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	revocations := auth.NewInMemoryRevocationList()
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, revocations)
+	registry := broadcaster.NewMockRegistry(t)
+	channelValidator := handler.NewChannelValidator()
+	publishHandler := handler.NewPublishHandler(channelValidator, registry)
+	historyHandler := handler.NewHistoryHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
+	revokeHandler := handler.NewRevokeHandler(revocations, registry, time.Hour)
+
+	restServer := NewRESTServer(logger, publishHandler, historyHandler, presenceHandler, revokeHandler, authenticator)
+
+	router := mux.NewRouter()
+	restServer.Register(router)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	revoke := func(apiKey string, body string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/revocations", bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("admin api key", func(t *testing.T) {
+		registry.On("DisconnectMatching", mock.Anything, broadcaster.CloseReasonRevoked).Return(2).Once()
+
+		resp := revoke("test-api-key", `{"subject":"banned-user"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var revokeResponse handler.RevokeResponse
+		err := json.NewDecoder(resp.Body).Decode(&revokeResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, revokeResponse.Disconnected)
+		assert.True(t, revocations.IsRevoked("", "banned-user", time.Now().Add(-time.Minute)))
+	})
+
+	t.Run("missing subject and token id", func(t *testing.T) {
+		resp := revoke("test-api-key", `{}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("missing admin scope", func(t *testing.T) {
+		resp := revoke("partner-api-key", `{"subject":"partner"}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.False(t, revocations.IsRevoked("", "partner", time.Now().Add(-time.Minute)))
+	})
 }
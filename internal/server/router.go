@@ -4,20 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/goevery/broadcaster/internal/handler"
 	"github.com/goevery/broadcaster/internal/ierr"
 	"go.uber.org/zap"
 )
 
+var errMethodNotFound = errors.New("method not found")
+
 type Router struct {
 	logger *zap.Logger
 
-	heartbeatHandler handler.HeartbeatHandlerInterface
-	subscribeHandler      handler.SubscribeHandlerInterface
-	unsubscribeHandler     handler.UnsubscribeHandlerInterface
-	publishHandler      handler.PublishHandlerInterface
-	authHandler      handler.AuthHandlerInterface
+	heartbeatHandler   handler.HeartbeatHandlerInterface
+	subscribeHandler   handler.SubscribeHandlerInterface
+	unsubscribeHandler handler.UnsubscribeHandlerInterface
+	publishHandler     handler.PublishHandlerInterface
+	authHandler        handler.AuthHandlerInterface
+	resumeHandler      handler.ResumeHandlerInterface
+	presenceHandler    handler.PresenceHandlerInterface
+	refreshHandler     handler.RefreshHandlerInterface
 }
 
 func NewRouter(
@@ -27,6 +33,9 @@ func NewRouter(
 	unsubscribeHandler handler.UnsubscribeHandlerInterface,
 	publishHandler handler.PublishHandlerInterface,
 	authHandler handler.AuthHandlerInterface,
+	resumeHandler handler.ResumeHandlerInterface,
+	presenceHandler handler.PresenceHandlerInterface,
+	refreshHandler handler.RefreshHandlerInterface,
 ) *Router {
 	return &Router{
 		logger,
@@ -35,85 +44,149 @@ func NewRouter(
 		unsubscribeHandler,
 		publishHandler,
 		authHandler,
+		resumeHandler,
+		presenceHandler,
+		refreshHandler,
 	}
 }
 
 func (r *Router) RouteRequest(ctx context.Context, request handler.Request) *handler.Response {
-	response, err := r.Handle(ctx, request)
+	response, handlerErr, ok := r.route(ctx, rpcRequest{
+		Id:     request.Id,
+		Method: request.Method,
+		decode: func(v any) error {
+			return decodeParams(request.Params, v)
+		},
+	})
+	if !ok {
+		return nil
+	}
+
+	if handlerErr != nil {
+		response := request.ReplyWithError(*handlerErr)
+
+		return &response
+	}
+
+	rawJson, err := json.Marshal(response)
 	if err != nil {
 		response := request.ReplyWithError(r.mapError(err))
 
 		return &response
 	}
 
-	hasResponse := response != nil
+	payload := json.RawMessage(rawJson)
+	reply := request.Reply(&payload)
 
-	if request.ReplyExpected() && !hasResponse {
-		r.logger.Error("handler did not return a response but one was expected", zap.String("method", request.Method))
+	return &reply
+}
 
-		response := request.ReplyWithError(
-			ierr.New(ierr.ErrorCodeInternal, errors.New("internal error")),
-		)
+// routeEncodedRequest routes a request read with a codec, whose response is
+// encoded with the same codec.
+func (r *Router) routeEncodedRequest(ctx context.Context, request rpcRequest) *rpcResponse {
+	response, handlerErr, ok := r.route(ctx, request)
+	if !ok {
+		return nil
+	}
 
-		return &response
+	if handlerErr != nil {
+		return &rpcResponse{
+			RequestId: request.Id,
+			Error:     newRPCError(*handlerErr),
+		}
 	}
 
-	if !request.ReplyExpected() && hasResponse {
-		r.logger.Error("handler returned a response but none was expected", zap.String("method", request.Method))
+	return &rpcResponse{
+		RequestId: request.Id,
+		Result:    response,
+	}
+}
 
-		return nil
+// route handles a request and returns its response or its error, unless no
+// reply is expected.
+func (r *Router) route(ctx context.Context, request rpcRequest) (any, *ierr.Error, bool) {
+	response, err := r.Handle(ctx, request.Method, request.decode)
+	if err != nil {
+		handlerErr := r.mapError(err)
+
+		return nil, &handlerErr, true
 	}
 
-	if hasResponse {
-		rawJson, err := json.Marshal(response)
-		if err != nil {
-			response := request.ReplyWithError(r.mapError(err))
+	hasResponse := response != nil
+	replyExpected := request.Id != 0
 
-			return &response
-		}
+	if replyExpected && !hasResponse {
+		r.logger.Error("handler did not return a response but one was expected", zap.String("method", request.Method))
 
-		payload := json.RawMessage(rawJson)
-		response := request.Reply(&payload)
+		handlerErr := ierr.New(ierr.ErrorCodeInternal, errors.New("internal error"))
 
-		return &response
+		return nil, &handlerErr, true
 	}
 
-	return nil
+	if !replyExpected && hasResponse {
+		r.logger.Error("handler returned a response but none was expected", zap.String("method", request.Method))
+
+		return nil, nil, false
+	}
+
+	return response, nil, hasResponse
 }
 
-func (r *Router) Handle(ctx context.Context, request handler.Request) (any, error) {
-	switch request.Method {
+// Handle calls the handler of the method, with the params decoded by decode.
+func (r *Router) Handle(ctx context.Context, method string, decode func(v any) error) (any, error) {
+	switch method {
 	case "heartbeat":
 		return r.heartbeatHandler.Handle(), nil
 	case "auth":
 		var authReq handler.AuthRequest
-		if err := decodeParams(request.Params, &authReq); err != nil {
+		if err := decode(&authReq); err != nil {
 			return nil, err
 		}
 		return r.authHandler.Handle(ctx, authReq)
+	case "refresh":
+		var refreshReq handler.RefreshRequest
+		if err := decode(&refreshReq); err != nil {
+			return nil, err
+		}
+
+		return r.refreshHandler.Handle(ctx, refreshReq)
+	case "resume":
+		var resumeReq handler.ResumeRequest
+		if err := decode(&resumeReq); err != nil {
+			return nil, err
+		}
+
+		return r.resumeHandler.Handle(ctx, resumeReq)
 	case "subscribe":
 		var subscribeReq handler.SubscribeRequest
-		if err := decodeParams(request.Params, &subscribeReq); err != nil {
+		if err := decode(&subscribeReq); err != nil {
 			return nil, err
 		}
 
 		return r.subscribeHandler.Handle(ctx, subscribeReq)
 	case "unsubscribe":
 		var unsubscribeReq handler.UnsubscribeRequest
-		if err := decodeParams(request.Params, &unsubscribeReq); err != nil {
+		if err := decode(&unsubscribeReq); err != nil {
 			return nil, err
 		}
 
 		return r.unsubscribeHandler.Handle(ctx, unsubscribeReq)
 	case "publish":
 		var publishReq handler.PublishRequest
-		if err := decodeParams(request.Params, &publishReq); err != nil {
+		if err := decode(&publishReq); err != nil {
 			return nil, err
 		}
 
 		return r.publishHandler.Handle(ctx, publishReq)
+	case "presence":
+		var presenceReq handler.PresenceRequest
+		if err := decode(&presenceReq); err != nil {
+			return nil, err
+		}
+
+		return r.presenceHandler.Handle(ctx, presenceReq)
 	default:
-		return nil, ierr.New(ierr.ErrorCodeNotFound, errors.New("method not found: "+request.Method))
+		return nil, ierr.New(ierr.ErrorCodeNotFound, fmt.Errorf("%w: %s", errMethodNotFound, method))
 	}
 }
 
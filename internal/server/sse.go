@@ -0,0 +1,311 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
+	"github.com/goevery/broadcaster/internal/pattern"
+	"github.com/gorilla/mux"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.uber.org/zap"
+)
+
+const sseChannelQueryParam = "channel"
+
+type sseCloseParams struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+type sseHistoryUnavailableParams struct {
+	Channels []string `json:"channels"`
+}
+
+// SSEServer streams the messages of channels as Server-Sent Events, for the
+// clients that only receive and cannot use WebSockets.
+type SSEServer struct {
+	logger           *zap.Logger
+	registry         broadcaster.Registry
+	router           *Router
+	subscribeHandler handler.SubscribeHandlerInterface
+	authenticator    *auth.Authenticator
+	originChecker    *OriginChecker
+	// keepAliveInterval is how often a comment is sent on idle streams, so
+	// that proxies do not close them.
+	keepAliveInterval time.Duration
+	// authCookie is the name of the cookie holding the token. Cookies are
+	// ignored when it is empty.
+	authCookie string
+}
+
+func NewSSEServer(
+	logger *zap.Logger,
+	registry broadcaster.Registry,
+	router *Router,
+	subscribeHandler handler.SubscribeHandlerInterface,
+	authenticator *auth.Authenticator,
+	originChecker *OriginChecker,
+	keepAliveInterval time.Duration,
+	authCookie string,
+) *SSEServer {
+	return &SSEServer{
+		logger,
+		registry,
+		router,
+		subscribeHandler,
+		authenticator,
+		originChecker,
+		keepAliveInterval,
+		authCookie,
+	}
+}
+
+func (s *SSEServer) Register(router *mux.Router) {
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if !s.originChecker.AllowCORS(w, r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		token := upgradeToken(r, s.authCookie)
+		if token == "" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+
+		authentication, err := s.authenticator.AuthenticateJWT(token)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		channels := r.URL.Query()[sseChannelQueryParam]
+		if len(channels) == 0 {
+			http.Error(w, "at least one channel is required", http.StatusBadRequest)
+			return
+		}
+
+		positions, err := parseSSEEventId(r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+
+		connectionId := gonanoid.Must()
+		broadcasterChannel := make(chan broadcaster.Message, 1024)
+
+		broadcasterConn := &broadcaster.Connection{
+			Id:   connectionId,
+			Send: broadcasterChannel,
+			Seq:  0,
+		}
+		broadcasterConn.SetAuthentication(authentication)
+
+		s.registry.Connect(broadcasterConn)
+		defer s.registry.Disconnect(connectionId)
+
+		ctx := broadcaster.WithConnection(r.Context(), broadcasterConn)
+		logger := s.logger.With(zap.String("connectionId", connectionId))
+
+		// Only the offsets of the channels subscribed by name are tracked, as
+		// patterns are subscribed again without replay.
+		namedChannels := make(map[string]bool)
+		eventPositions := make(map[string]uint64)
+		unavailable := []string{}
+		for _, channel := range channels {
+			subscribeRequest := handler.SubscribeRequest{
+				Channel: channel,
+			}
+
+			offset, ok := positions[channel]
+			if ok && !pattern.IsPattern(channel) {
+				// Browsers send the event id on their own, so a token that
+				// cannot read the history is told to refetch its state
+				// rather than failing the stream.
+				if broadcasterConn.Can(auth.ScopeHistory, channel) {
+					subscribeRequest.History = &broadcaster.HistoryQuery{
+						AfterOffset: &offset,
+					}
+				} else {
+					unavailable = append(unavailable, channel)
+				}
+			}
+
+			_, err := s.subscribeHandler.Handle(ctx, subscribeRequest)
+
+			var handlerErr ierr.Error
+			if subscribeRequest.History != nil && errors.As(err, &handlerErr) &&
+				handlerErr.Code == ierr.ErrorCodeFailedPrecondition {
+				// The missed messages are gone, so the client is told to
+				// refetch its state instead of failing the stream.
+				unavailable = append(unavailable, channel)
+				subscribeRequest.History = nil
+
+				_, err = s.subscribeHandler.Handle(ctx, subscribeRequest)
+			}
+
+			if err != nil {
+				logger.Info("failed to subscribe sse connection",
+					zap.String("channel", channel), zap.Error(err))
+				http.Error(w, s.router.mapError(err).Message, httpStatusFromError(err))
+
+				return
+			}
+
+			if !pattern.IsPattern(channel) {
+				namedChannels[channel] = true
+				if subscribeRequest.History != nil {
+					eventPositions[channel] = offset
+				}
+			}
+		}
+
+		responseController := http.NewResponseController(w)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Disables the response buffering of nginx.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		write := func(event string) error {
+			responseController.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+			_, err := w.Write([]byte(event))
+			if err != nil {
+				return err
+			}
+
+			return responseController.Flush()
+		}
+
+		if len(unavailable) > 0 {
+			rawJson, _ := json.Marshal(sseHistoryUnavailableParams{Channels: unavailable})
+
+			err := write(formatSSEEvent("historyUnavailable", "", rawJson))
+			if err != nil {
+				return
+			}
+		}
+
+		// Flushes the headers, so that clients know the stream is open.
+		err = write(": connected\n\n")
+		if err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(s.keepAliveInterval)
+		defer keepAlive.Stop()
+
+		// Streams cannot refresh their token, so they end when it expires and
+		// the client reconnects with a new one.
+		var expireC <-chan time.Time
+		if !authentication.ExpireTime.IsZero() {
+			expireTimer := time.NewTimer(time.Until(authentication.ExpireTime))
+			defer expireTimer.Stop()
+
+			expireC = expireTimer.C
+		}
+
+		for {
+			select {
+			case message, ok := <-broadcasterChannel:
+				if !ok {
+					if reason, ok := broadcasterConn.CloseReason(); ok {
+						s.writeClose(write, reason)
+					}
+
+					logger.Info("sse connection closed")
+
+					return
+				}
+
+				if namedChannels[message.Channel] && message.Offset > 0 {
+					eventPositions[message.Channel] = message.Offset
+				}
+
+				rawJson, err := json.Marshal(message)
+				if err != nil {
+					logger.Error("failed to marshal message", zap.Error(err))
+
+					return
+				}
+
+				err = write(formatSSEEvent("broadcast", formatSSEEventId(eventPositions), rawJson))
+				if err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				err := write(": keep-alive\n\n")
+				if err != nil {
+					return
+				}
+			case <-expireC:
+				logger.Info("closing sse connection with expired token")
+
+				s.writeClose(write, broadcaster.CloseReasonTokenExpired)
+
+				return
+			case <-r.Context().Done():
+				logger.Info("sse connection closed")
+
+				return
+			}
+		}
+	}).Methods("GET")
+}
+
+func (s *SSEServer) writeClose(write func(event string) error, reason broadcaster.CloseReason) {
+	rawJson, _ := json.Marshal(sseCloseParams{Code: reason.Code, Reason: reason.Text})
+
+	_ = write(formatSSEEvent("close", "", rawJson))
+}
+
+// formatSSEEvent formats an event whose data is a single line of JSON.
+func formatSSEEvent(event string, id string, data []byte) string {
+	if id != "" {
+		return fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", id, event, data)
+	}
+
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+}
+
+// formatSSEEventId encodes the offset of the last message received on each
+// channel, so that a reconnecting client resumes every channel with the id of
+// the last event it received.
+func formatSSEEventId(positions map[string]uint64) string {
+	values := url.Values{}
+	for channel, offset := range positions {
+		values.Set(channel, strconv.FormatUint(offset, 10))
+	}
+
+	return values.Encode()
+}
+
+func parseSSEEventId(id string) (map[string]uint64, error) {
+	values, err := url.ParseQuery(id)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make(map[string]uint64, len(values))
+	for channel := range values {
+		offset, err := strconv.ParseUint(values.Get(channel), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		positions[channel] = offset
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goevery/broadcaster/internal/auth"
+	"github.com/goevery/broadcaster/internal/broadcaster"
+	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type sseEvent struct {
+	Id    string
+	Event string
+	Data  string
+}
+
+func TestSSEServer(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, auth.NewInMemoryRevocationList())
+	channelValidator := handler.NewChannelValidator()
+	subscribeHandler := handler.NewSubscribeHandler(channelValidator, registry, 100)
+
+	router := NewRouter(
+		logger,
+		handler.NewHeartbeatHandler(),
+		subscribeHandler,
+		handler.NewUnsubscribeHandler(channelValidator, registry),
+		handler.NewPublishHandler(channelValidator, registry),
+		handler.NewAuthHandler(authenticator),
+		handler.NewResumeHandler(channelValidator, registry),
+		handler.NewPresenceHandler(channelValidator, registry),
+		handler.NewRefreshHandler(authenticator, registry),
+	)
+
+	sseServer := NewSSEServer(logger, registry, router, subscribeHandler, authenticator, NewOriginChecker([]string{"https://app.example.com"}), 100*time.Millisecond, "")
+
+	mainRouter := mux.NewRouter()
+	sseServer.Register(mainRouter)
+
+	server := httptest.NewServer(mainRouter)
+	defer server.Close()
+
+	claims := jwt.MapClaims{
+		"sub":                "sse-user",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"aud":                "broadcaster",
+		"authorizedChannels": []string{"sse:*"},
+		"scope":              []string{"subscribe", "history"},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte("test-secret"))
+	assert.NoError(t, err)
+
+	connect := func(t *testing.T, query string, lastEventId string) (*http.Response, *bufio.Reader) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		t.Cleanup(cancel)
+
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events?"+query, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		if lastEventId != "" {
+			req.Header.Set("Last-Event-ID", lastEventId)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/events?channel=sse:a")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("unauthorized channel", func(t *testing.T) {
+		resp, reader := connect(t, "channel=sse:a&channel=other", "")
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		body, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "user not authorized to access this channel\n", string(body))
+	})
+
+	t.Run("origins", func(t *testing.T) {
+		request := func(origin string) *http.Response {
+			req, err := http.NewRequest("GET", server.URL+"/events?channel=sse:origin", nil)
+			assert.NoError(t, err)
+			req.Header.Set("Origin", origin)
+
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			resp.Body.Close()
+
+			return resp
+		}
+
+		resp := request("https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+		resp = request("https://app.example.com")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("stream broadcasts", func(t *testing.T) {
+		resp, reader := connect(t, "channel=sse:stream-a&channel=sse:stream-b", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "sse:stream-a", Payload: "first"})
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-2", Channel: "sse:stream-b", Payload: "second"})
+
+		event := readSSEEvent(t, reader)
+		assert.Equal(t, "broadcast", event.Event)
+		assert.Equal(t, "sse%3Astream-a=1", event.Id)
+
+		var message broadcaster.Message
+		err := json.Unmarshal([]byte(event.Data), &message)
+		assert.NoError(t, err)
+		assert.Equal(t, "msg-1", message.Id)
+		assert.Equal(t, "first", message.Payload)
+
+		event = readSSEEvent(t, reader)
+		assert.Equal(t, "sse%3Astream-a=1&sse%3Astream-b=1", event.Id)
+	})
+
+	t.Run("reconnect with last event id", func(t *testing.T) {
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-1", Channel: "sse:resume", Payload: "first"})
+		registry.Broadcast(broadcaster.Message{CreateTime: time.Now(), Id: "msg-2", Channel: "sse:resume", Payload: "second"})
+
+		resp, reader := connect(t, "channel=sse:resume", "sse%3Aresume=1")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		event := readSSEEvent(t, reader)
+		assert.Equal(t, "sse%3Aresume=2", event.Id)
+
+		var message broadcaster.Message
+		err := json.Unmarshal([]byte(event.Data), &message)
+		assert.NoError(t, err)
+		assert.Equal(t, "msg-2", message.Id)
+	})
+
+	t.Run("reconnect after history is gone", func(t *testing.T) {
+		resp, reader := connect(t, "channel=sse:gone", "sse%3Agone=41")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		event := readSSEEvent(t, reader)
+		assert.Equal(t, "historyUnavailable", event.Event)
+		assert.JSONEq(t, `{"channels":["sse:gone"]}`, event.Data)
+	})
+
+	t.Run("keep alive", func(t *testing.T) {
+		resp, reader := connect(t, "channel=sse:idle", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		found := false
+		for !found {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			found = line == ": keep-alive\n"
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		resp, reader := connect(t, "channel=sse:revoked", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The subscription is made before the response is sent.
+		disconnected := registry.DisconnectMatching(func(connection *broadcaster.Connection) bool {
+			return connection.GetUserId() == "sse-user"
+		}, broadcaster.CloseReasonRevoked)
+		assert.Positive(t, disconnected)
+
+		event := readSSEEvent(t, reader)
+		assert.Equal(t, "close", event.Event)
+		assert.JSONEq(t, `{"code":4003,"reason":"authentication revoked"}`, event.Data)
+	})
+}
+
+// readSSEEvent reads the next event of the stream, skipping comments.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && event.Event != "" {
+			return event
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.Id = value
+		case "event":
+			event.Event = value
+		case "data":
+			event.Data = value
+		}
+	}
+}
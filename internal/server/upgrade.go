@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// WebSocketSubprotocol is the subprotocol selected for the clients that
+	// offer it, which browsers require when the token is passed as a
+	// subprotocol.
+	WebSocketSubprotocol = "broadcaster"
+
+	bearerSubprotocolPrefix = "bearer."
+	tokenQueryParam         = "token"
+)
+
+// upgradeToken returns the token a client passed with its upgrade request, in
+// order of preference as a `bearer.<token>` subprotocol, in the Authorization
+// header, as the `token` query parameter or in the cookie, when a cookie name
+// is set.
+func upgradeToken(r *http.Request, cookieName string) string {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, bearerSubprotocolPrefix); ok {
+			return token
+		}
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	if token := r.URL.Query().Get(tokenQueryParam); token != "" {
+		return token
+	}
+
+	if cookieName != "" {
+		cookie, err := r.Cookie(cookieName)
+		if err == nil {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
@@ -2,10 +2,10 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/broadcaster"
 	"github.com/goevery/broadcaster/internal/handler"
 	"github.com/gorilla/mux"
@@ -14,11 +14,29 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// webSocketMaxMessageSize is the size limit of the requests.
+	webSocketMaxMessageSize = 1024
+	// jsonRPCMaxMessageSize is the size limit of the JSON-RPC 2.0 messages,
+	// which may hold a batch of requests.
+	jsonRPCMaxMessageSize = 64 * 1024
+)
+
 type WebSocketServer struct {
-	logger   *zap.Logger
-	upgrader *websocket.Upgrader
-	registry broadcaster.Registry
-	router   *Router
+	logger        *zap.Logger
+	upgrader      *websocket.Upgrader
+	registry      broadcaster.Registry
+	router        *Router
+	authenticator *auth.Authenticator
+	// tokenExpiryWarning is how long before its token expires a connection is
+	// asked to refresh it.
+	tokenExpiryWarning time.Duration
+	// authDeadline is how long a connection may stay unauthenticated. Zero
+	// disables the deadline.
+	authDeadline time.Duration
+	// authCookie is the name of the cookie holding the token at upgrade time.
+	// Cookies are ignored when it is empty.
+	authCookie string
 }
 
 func NewWebSocketServer(
@@ -26,17 +44,38 @@ func NewWebSocketServer(
 	upgrader *websocket.Upgrader,
 	registry broadcaster.Registry,
 	router *Router,
+	authenticator *auth.Authenticator,
+	tokenExpiryWarning time.Duration,
+	authDeadline time.Duration,
+	authCookie string,
 ) *WebSocketServer {
 	return &WebSocketServer{
 		logger,
 		upgrader,
 		registry,
 		router,
+		authenticator,
+		tokenExpiryWarning,
+		authDeadline,
+		authCookie,
 	}
 }
 
 func (s *WebSocketServer) Register(router *mux.Router) {
 	router.HandleFunc("/websocket", func(w http.ResponseWriter, r *http.Request) {
+		// A token passed with the upgrade request is verified before
+		// upgrading, so that bad tokens never hold a connection.
+		var authentication *auth.Authentication
+		if token := upgradeToken(r, s.authCookie); token != "" {
+			var err error
+			authentication, err = s.authenticator.AuthenticateJWT(token)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+
+				return
+			}
+		}
+
 		wsConn, err := s.upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			s.logger.Warn("failed to upgrade to websocket", zap.Error(err))
@@ -44,10 +83,26 @@ func (s *WebSocketServer) Register(router *mux.Router) {
 			return
 		}
 
+		codec := codecForSubprotocol(wsConn.Subprotocol())
+		jsonRPC := wsConn.Subprotocol() == JSONRPCSubprotocol
+
 		connectionId := gonanoid.Must()
 		broascasterChannel := make(chan broadcaster.Message, 1024)
 
+		// rpcChannel is never closed, since readPump may still be sending a
+		// response when the connection is closed. closed tells writePump to
+		// close the socket, and writeDone tells the senders that nothing is
+		// written anymore.
 		rpcChannel := make(chan any, 1024)
+		closed := make(chan struct{})
+		writeDone := make(chan struct{})
+
+		send := func(message any) {
+			select {
+			case rpcChannel <- message:
+			case <-writeDone:
+			}
+		}
 
 		broadcasterConn := &broadcaster.Connection{
 			Id:   connectionId,
@@ -59,24 +114,84 @@ func (s *WebSocketServer) Register(router *mux.Router) {
 
 		ctx := broadcaster.WithConnection(r.Context(), broadcasterConn)
 
-		go s.readPump(ctx, wsConn, rpcChannel, connectionId)
-		go s.writePump(ctx, wsConn, rpcChannel)
+		// Subscribed before any request is read, so that no authentication
+		// goes unnoticed.
+		authenticationChanged := broadcasterConn.AuthenticationChanged()
+		expiry := newTokenExpiry(s.tokenExpiryWarning)
+		defer expiry.stop()
 
-		for message := range broascasterChannel {
-			rawJson, err := json.Marshal(message)
-			if err != nil {
-				s.logger.Error("failed to marshal message", zap.Error(err))
+		var authDeadline <-chan time.Time
+		if authentication != nil {
+			broadcasterConn.SetAuthentication(authentication)
 
-				return
-			}
+			send(rpcNotification{
+				Method: "authenticated",
+				Params: handler.AuthResponse{
+					Success:   true,
+					SessionId: connectionId,
+				},
+			})
+		} else if s.authDeadline > 0 {
+			authDeadlineTimer := time.NewTimer(s.authDeadline)
+			defer authDeadlineTimer.Stop()
+
+			authDeadline = authDeadlineTimer.C
+		}
+
+		go s.readPump(ctx, wsConn, codec, jsonRPC, rpcChannel, writeDone, connectionId)
+		go func() {
+			s.writePump(ctx, wsConn, codec, jsonRPC, rpcChannel, closed, broadcasterConn)
+			close(writeDone)
+		}()
+
+	loop:
+		for {
+			select {
+			case message, ok := <-broascasterChannel:
+				if !ok {
+					break loop
+				}
+
+				send(rpcNotification{
+					Method: "broadcast",
+					Params: message,
+				})
+			case <-authenticationChanged:
+				authentication := broadcasterConn.GetAuthentication()
+				if authentication != nil {
+					authDeadline = nil
+					expiry.reset(authentication.ExpireTime)
+				}
+			case <-authDeadline:
+				authDeadline = nil
+
+				// The authentication may be set while the deadline fires.
+				if broadcasterConn.GetUserId() != "" {
+					continue
+				}
 
-			payload := json.RawMessage(rawJson)
-			notification := handler.NewNotification("broadcast", &payload)
+				s.logger.Info("closing unauthenticated connection",
+					zap.String("connectionId", connectionId))
 
-			rpcChannel <- notification
+				broadcasterConn.SetCloseReason(broadcaster.CloseReasonAuthenticationTimeout)
+				s.registry.Disconnect(connectionId)
+			case <-expiry.warningC():
+				send(rpcNotification{
+					Method: "tokenExpiring",
+					Params: tokenExpiringParams{
+						ExpireTime: broadcasterConn.GetAuthentication().ExpireTime,
+					},
+				})
+			case <-expiry.expireC():
+				s.logger.Info("closing connection with expired token",
+					zap.String("connectionId", connectionId))
+
+				broadcasterConn.SetCloseReason(broadcaster.CloseReasonTokenExpired)
+				s.registry.Disconnect(connectionId)
+			}
 		}
 
-		close(rpcChannel)
+		close(closed)
 
 		s.logger.Info("websocket connection closed", zap.String("connectionId", connectionId))
 	})
@@ -85,19 +200,31 @@ func (s *WebSocketServer) Register(router *mux.Router) {
 func (s *WebSocketServer) readPump(
 	ctx context.Context,
 	wsConn *websocket.Conn,
-	rpcChannel chan any,
+	codec Codec,
+	jsonRPC bool,
+	rpcChannel chan<- any,
+	writeDone <-chan struct{},
 	connectionId string,
 ) {
 	defer func() {
 		s.registry.Disconnect(connectionId)
 	}()
 
-	wsConn.SetReadLimit(1024)
+	if jsonRPC {
+		wsConn.SetReadLimit(jsonRPCMaxMessageSize)
+	} else {
+		wsConn.SetReadLimit(webSocketMaxMessageSize)
+	}
 	wsConn.SetReadDeadline(time.Now().Add(60 * time.Second))
 
 	for {
-		var request handler.Request
-		err := wsConn.ReadJSON(&request)
+		var request rpcRequest
+
+		_, data, err := wsConn.ReadMessage()
+		if err == nil && !jsonRPC {
+			request, err = codec.DecodeRequest(data)
+		}
+
 		if err != nil {
 			isExpectedClose := websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure)
 			if !isExpectedClose {
@@ -109,40 +236,93 @@ func (s *WebSocketServer) readPump(
 
 		wsConn.SetReadDeadline(time.Now().Add(60 * time.Second))
 
-		response := s.router.RouteRequest(ctx, request)
-		if response != nil {
-			rpcChannel <- response
+		// JSON-RPC 2.0 requests may be batches, which are answered at once.
+		if jsonRPC {
+			if response := s.router.RouteJSONRPC(ctx, data); response != nil && !queue(rpcChannel, response, writeDone) {
+				break
+			}
+
+			continue
+		}
+
+		response := s.router.routeEncodedRequest(ctx, request)
+		if response != nil && !queue(rpcChannel, response, writeDone) {
+			break
 		}
 	}
 }
 
+// queue sends the message to the writer, and reports whether it did before the
+// writer was done.
+func queue(rpcChannel chan<- any, message any, writeDone <-chan struct{}) bool {
+	select {
+	case rpcChannel <- message:
+		return true
+	case <-writeDone:
+		return false
+	}
+}
+
 func (s *WebSocketServer) writePump(
 	ctx context.Context,
 	wsConn *websocket.Conn,
-	rpcChannel chan any,
+	codec Codec,
+	jsonRPC bool,
+	rpcChannel <-chan any,
+	closed <-chan struct{},
+	connection *broadcaster.Connection,
 ) {
 	defer func() {
 		_ = wsConn.Close()
 	}()
 
+	write := func(message any) bool {
+		if jsonRPC {
+			message = jsonRPCMessage(message)
+		}
+
+		data, err := codec.Marshal(message)
+		if err != nil {
+			s.logger.Error("failed to encode message", zap.Error(err))
+
+			return true
+		}
+
+		wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		err = wsConn.WriteMessage(codec.FrameType(), data)
+		if err != nil {
+			s.logger.Error("failed to send broadcast notification", zap.Error(err))
+
+			return false
+		}
+
+		return true
+	}
+
 	for {
 		select {
-		case message, ok := <-rpcChannel:
-			if !ok {
-				// Channel closed, close the connection
-				wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				wsConn.WriteMessage(websocket.CloseMessage, []byte{})
-
+		case message := <-rpcChannel:
+			if !write(message) {
 				return
 			}
+		case <-closed:
+			// The messages queued before the connection was closed are sent
+			// first.
+			for n := len(rpcChannel); n > 0; n-- {
+				if !write(<-rpcChannel) {
+					return
+				}
+			}
+
+			closeMessage := []byte{}
+			if reason, ok := connection.CloseReason(); ok {
+				closeMessage = websocket.FormatCloseMessage(reason.Code, reason.Text)
+			}
 
 			wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			err := wsConn.WriteJSON(message)
-			if err != nil {
-				s.logger.Error("failed to send broadcast notification", zap.Error(err))
+			wsConn.WriteMessage(websocket.CloseMessage, closeMessage)
 
-				return
-			}
+			return
 		case <-ctx.Done():
 			return
 		}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
@@ -10,6 +13,7 @@ import (
 	"github.com/goevery/broadcaster/internal/auth"
 	"github.com/goevery/broadcaster/internal/broadcaster"
 	"github.com/goevery/broadcaster/internal/handler"
+	"github.com/goevery/broadcaster/internal/ierr"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -19,19 +23,25 @@ import (
 
 func TestWebSocketServer(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
-	registry := broadcaster.NewInMemoryRegistry(logger)
-	authenticator := auth.NewAuthenticator("test-secret", []string{"test-api-key"})
+	registry := broadcaster.NewInMemoryRegistry(logger, broadcaster.NewInMemoryMessageStore(100, time.Hour), time.Minute)
+	revocations := auth.NewInMemoryRevocationList()
+	authenticator := auth.NewAuthenticator(auth.NewHMACKeySet("test-secret"), "", "broadcaster", testAPIKeys, revocations)
 	channelValidator := handler.NewChannelValidator()
 	heartbeatHandler := handler.NewHeartbeatHandler()
-	subscribeHandler := handler.NewSubscribeHandler(channelValidator, registry)
+	subscribeHandler := handler.NewSubscribeHandler(channelValidator, registry, 100)
 	unsubscribeHandler := handler.NewUnsubscribeHandler(channelValidator, registry)
 	publishHandler := handler.NewPublishHandler(channelValidator, registry)
 	authHandler := handler.NewAuthHandler(authenticator)
+	resumeHandler := handler.NewResumeHandler(channelValidator, registry)
+	presenceHandler := handler.NewPresenceHandler(channelValidator, registry)
 
-	router := NewRouter(logger, heartbeatHandler, subscribeHandler, unsubscribeHandler, publishHandler, authHandler)
-	upgrader := &websocket.Upgrader{}
+	refreshHandler := handler.NewRefreshHandler(authenticator, registry)
+	revokeHandler := handler.NewRevokeHandler(revocations, registry, time.Hour)
 
-	wsServer := NewWebSocketServer(logger, upgrader, registry, router)
+	router := NewRouter(logger, heartbeatHandler, subscribeHandler, unsubscribeHandler, publishHandler, authHandler, resumeHandler, presenceHandler, refreshHandler)
+	upgrader := &websocket.Upgrader{Subprotocols: WebSocketSubprotocols}
+
+	wsServer := NewWebSocketServer(logger, upgrader, registry, router, authenticator, time.Second, 500*time.Millisecond, "broadcaster_token")
 
 	mainRouter := mux.NewRouter()
 	wsServer.Register(mainRouter)
@@ -314,4 +324,935 @@ func TestWebSocketServer(t *testing.T) {
 		assert.NotNil(t, publishResponse.Error)
 		assert.Equal(t, "PermissionDenied", string(publishResponse.Error.Code))
 	})
+
+	t.Run("subscribe with history", func(t *testing.T) {
+		registry.Broadcast(broadcaster.Message{Id: "msg-1", CreateTime: time.Now(), Channel: "history-channel", Payload: "first"})
+		registry.Broadcast(broadcaster.Message{Id: "msg-2", CreateTime: time.Now(), Channel: "history-channel", Payload: "second"})
+		registry.Broadcast(broadcaster.Message{Id: "msg-3", CreateTime: time.Now(), Channel: "history-channel", Payload: "third"})
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// Auth
+		claims := jwt.MapClaims{
+			"sub":                "test-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"history-channel"},
+			"scope":              []string{"subscribe", "history"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		authRequest := json.RawMessage(`{"id":1,"method":"auth","params":{"token":"` + tokenString + `"}}`)
+		err = conn.WriteJSON(authRequest)
+		assert.NoError(t, err)
+
+		var authResponse handler.Response
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		err = conn.ReadJSON(&authResponse)
+		assert.NoError(t, err)
+
+		// Subscribe replaying the last two messages
+		subscribeRequest := json.RawMessage(`{"id":2,"method":"subscribe","params":{"channel":"history-channel","history":{"limit":2}}}`)
+		err = conn.WriteJSON(subscribeRequest)
+		assert.NoError(t, err)
+
+		var receivedIds []string
+		for len(receivedIds) < 2 {
+			var message struct {
+				handler.Request
+				handler.Response
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			err = conn.ReadJSON(&message)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if message.Method != "broadcast" {
+				assert.Nil(t, message.Error)
+				continue
+			}
+
+			var messagePayload broadcaster.Message
+			err = json.Unmarshal(*message.Params, &messagePayload)
+			assert.NoError(t, err)
+
+			receivedIds = append(receivedIds, messagePayload.Id)
+		}
+
+		assert.Equal(t, []string{"msg-2", "msg-3"}, receivedIds)
+	})
+
+	t.Run("subscribe with history without history permission", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":         "test-user",
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"iat":         time.Now().Unix(),
+			"aud":         "broadcaster",
+			"permissions": map[string][]string{"history-channel": {"subscribe"}},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"history-channel","history":{"limit":2}}}`)
+		if assert.NotNil(t, subscribeResponse.Error) {
+			assert.Equal(t, "PermissionDenied", string(subscribeResponse.Error.Code))
+		}
+
+		subscribeResponse = sendRequest(t, conn, `{"id":3,"method":"subscribe","params":{"channel":"history-channel"}}`)
+		assert.Nil(t, subscribeResponse.Error)
+	})
+
+	t.Run("subscribe with history over the limit", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "test-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"history-channel"},
+			"scope":              []string{"subscribe", "history"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"history-channel","history":{"limit":101}}}`)
+		if assert.NotNil(t, subscribeResponse.Error) {
+			assert.Equal(t, "InvalidArgument", string(subscribeResponse.Error.Code))
+		}
+	})
+
+	t.Run("resume without history permission", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":         "resume-user",
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"iat":         time.Now().Unix(),
+			"aud":         "broadcaster",
+			"permissions": map[string][]string{"resume-channel": {"subscribe"}},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+
+		authResponse := sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		var authResponsePayload handler.AuthResponse
+		err = json.Unmarshal(*authResponse.Result, &authResponsePayload)
+		assert.NoError(t, err)
+
+		sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"resume-channel"}}`)
+		conn.Close()
+
+		conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		resumeResponse := sendRequest(t, conn, `{"id":2,"method":"resume","params":{"sessionId":"`+authResponsePayload.SessionId+`","positions":{"resume-channel":0}}}`)
+		if assert.NotNil(t, resumeResponse.Error) {
+			assert.Equal(t, "PermissionDenied", string(resumeResponse.Error.Code))
+			assert.JSONEq(t, `{"channels":["resume-channel"]}`, string(resumeResponse.Error.Data))
+		}
+	})
+
+	t.Run("resume session", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "resume-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"resume-channel"},
+			"scope":              []string{"subscribe", "history"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		// First connection
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+
+		authResponse := sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		var authResponsePayload handler.AuthResponse
+		err = json.Unmarshal(*authResponse.Result, &authResponsePayload)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, authResponsePayload.SessionId)
+
+		subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"resume-channel"}}`)
+		assert.Nil(t, subscribeResponse.Error)
+
+		registry.Broadcast(broadcaster.Message{Id: "msg-1", CreateTime: time.Now(), Channel: "resume-channel"})
+		assert.Equal(t, "msg-1", readBroadcast(t, conn).Id)
+
+		conn.Close()
+
+		// Messages published while disconnected
+		registry.Broadcast(broadcaster.Message{Id: "msg-2", CreateTime: time.Now(), Channel: "resume-channel"})
+		registry.Broadcast(broadcaster.Message{Id: "msg-3", CreateTime: time.Now(), Channel: "resume-channel"})
+
+		// Second connection
+		conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		err = conn.WriteJSON(json.RawMessage(`{"id":2,"method":"resume","params":{"sessionId":"` + authResponsePayload.SessionId + `","positions":{"resume-channel":1}}}`))
+		assert.NoError(t, err)
+
+		// Replayed messages and the response travel through different
+		// queues, so their relative order is not guaranteed.
+		var resumeResponse handler.Response
+		var replayedIds []string
+		for i := 0; i < 3; i++ {
+			var message struct {
+				handler.Request
+				handler.Response
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			err = conn.ReadJSON(&message)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if message.Method != "broadcast" {
+				resumeResponse = message.Response
+				continue
+			}
+
+			var messagePayload broadcaster.Message
+			err = json.Unmarshal(*message.Params, &messagePayload)
+			assert.NoError(t, err)
+
+			replayedIds = append(replayedIds, messagePayload.Id)
+		}
+
+		assert.Equal(t, []string{"msg-2", "msg-3"}, replayedIds)
+		if !assert.Nil(t, resumeResponse.Error) {
+			return
+		}
+
+		var resumeResponsePayload handler.ResumeResponse
+		err = json.Unmarshal(*resumeResponse.Result, &resumeResponsePayload)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"resume-channel"}, resumeResponsePayload.Channels)
+
+		// Live messages keep flowing
+		registry.Broadcast(broadcaster.Message{Id: "msg-4", CreateTime: time.Now(), Channel: "resume-channel"})
+
+		liveMessage := readBroadcast(t, conn)
+		assert.Equal(t, "msg-4", liveMessage.Id)
+		assert.Equal(t, uint64(4), liveMessage.Offset)
+	})
+
+	t.Run("resume not possible", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "resume-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"resume-channel"},
+			"scope":              []string{"subscribe", "history"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+
+		authResponse := sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		var authResponsePayload handler.AuthResponse
+		err = json.Unmarshal(*authResponse.Result, &authResponsePayload)
+		assert.NoError(t, err)
+
+		sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"resume-channel"}}`)
+		conn.Close()
+
+		conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		resumeResponse := sendRequest(t, conn, `{"id":2,"method":"resume","params":{"sessionId":"`+authResponsePayload.SessionId+`","positions":{"resume-channel":100}}}`)
+		assert.NotNil(t, resumeResponse.Error)
+		assert.Equal(t, "FailedPrecondition", string(resumeResponse.Error.Code))
+		assert.JSONEq(t, `{"channels":["resume-channel"]}`, string(resumeResponse.Error.Data))
+	})
+
+	t.Run("resume not possible keeps the live connection", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "resume-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"resume-live-channel"},
+			"scope":              []string{"subscribe", "history"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		liveConn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer liveConn.Close()
+
+		authResponse := sendRequest(t, liveConn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		var authResponsePayload handler.AuthResponse
+		err = json.Unmarshal(*authResponse.Result, &authResponsePayload)
+		assert.NoError(t, err)
+
+		sendRequest(t, liveConn, `{"id":2,"method":"subscribe","params":{"channel":"resume-live-channel"}}`)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		resumeResponse := sendRequest(t, conn, `{"id":2,"method":"resume","params":{"sessionId":"`+authResponsePayload.SessionId+`","positions":{"resume-live-channel":100}}}`)
+		if assert.NotNil(t, resumeResponse.Error) {
+			assert.Equal(t, "FailedPrecondition", string(resumeResponse.Error.Code))
+		}
+
+		registry.Broadcast(broadcaster.Message{Id: "msg-live", CreateTime: time.Now(), Channel: "resume-live-channel"})
+		assert.Equal(t, "msg-live", readBroadcast(t, liveConn).Id)
+	})
+
+	t.Run("resume unknown session", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "resume-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"resume-channel"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		resumeResponse := sendRequest(t, conn, `{"id":2,"method":"resume","params":{"sessionId":"unknown-session"}}`)
+		assert.NotNil(t, resumeResponse.Error)
+		assert.Equal(t, "NotFound", string(resumeResponse.Error.Code))
+	})
+
+	t.Run("resume with invalid positions", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "resume-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"**"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		resumeResponse := sendRequest(t, conn, `{"id":2,"method":"resume","params":{"sessionId":"unknown-session","positions":{"../room":0}}}`)
+		if assert.NotNil(t, resumeResponse.Error) {
+			assert.Equal(t, "InvalidArgument", string(resumeResponse.Error.Code))
+		}
+	})
+
+	t.Run("presence", func(t *testing.T) {
+		connect := func(userId string) *websocket.Conn {
+			claims := jwt.MapClaims{
+				"sub":                userId,
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"iat":                time.Now().Unix(),
+				"aud":                "broadcaster",
+				"authorizedChannels": []string{"presence:room"},
+				"scope":              []string{"subscribe", "presence"},
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			tokenString, err := token.SignedString([]byte("test-secret"))
+			assert.NoError(t, err)
+
+			conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+			assert.NoError(t, err)
+
+			sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+			subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"presence:room","metadata":{"name":"`+userId+`"}}}`)
+			assert.Nil(t, subscribeResponse.Error)
+
+			return conn
+		}
+
+		bob := connect("bob")
+		defer bob.Close()
+
+		aliceTab1 := connect("alice")
+
+		join := readBroadcast(t, bob)
+		assert.Equal(t, "presence.join", join.Event)
+		assert.Equal(t, "alice", join.Payload.(map[string]any)["userId"])
+		assert.Equal(t, map[string]any{"name": "alice"}, join.Payload.(map[string]any)["metadata"])
+		assert.Zero(t, join.Offset)
+
+		// A second tab of the same user does not join again
+		aliceTab2 := connect("alice")
+
+		presenceResponse := sendRequest(t, bob, `{"id":3,"method":"presence","params":{"channel":"presence:room"}}`)
+		if !assert.Nil(t, presenceResponse.Error) {
+			return
+		}
+
+		var presenceResponsePayload handler.PresenceResponse
+		err := json.Unmarshal(*presenceResponse.Result, &presenceResponsePayload)
+		assert.NoError(t, err)
+		assert.Len(t, presenceResponsePayload.Members, 2)
+		assert.Equal(t, "alice", presenceResponsePayload.Members[0].UserId)
+		assert.Equal(t, "bob", presenceResponsePayload.Members[1].UserId)
+
+		// The user only leaves when its last tab is closed
+		aliceTab1.Close()
+		aliceTab2.Close()
+
+		leave := readBroadcast(t, bob)
+		assert.Equal(t, "presence.leave", leave.Event)
+		assert.Equal(t, "alice", leave.Payload.(map[string]any)["userId"])
+
+		assert.Eventually(t, func() bool {
+			return len(registry.Presence("presence:room")) == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("subscribe to pattern", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "pattern-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"org:42:room"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		// The pattern would grant more than the authorized channel
+		subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"org:42:*"}}`)
+		assert.NotNil(t, subscribeResponse.Error)
+
+		subscribeResponse = sendRequest(t, conn, `{"id":3,"method":"subscribe","params":{"channel":"org:**:room"}}`)
+		if assert.NotNil(t, subscribeResponse.Error) {
+			assert.Equal(t, "InvalidArgument", string(subscribeResponse.Error.Code))
+		}
+	})
+
+	t.Run("channel permissions", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub": "permissions-user",
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(),
+			"aud": "broadcaster",
+			"permissions": map[string][]string{
+				"room:*":            {"subscribe"},
+				"user:{sub}:typing": {"publish"},
+			},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		authResponse := sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+		assert.Nil(t, authResponse.Error)
+
+		subscribeResponse := sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"room:42"}}`)
+		assert.Nil(t, subscribeResponse.Error)
+
+		publishResponse := sendRequest(t, conn, `{"id":3,"method":"publish","params":{"channel":"room:42","event":"test-event"}}`)
+		if assert.NotNil(t, publishResponse.Error) {
+			assert.Equal(t, "PermissionDenied", string(publishResponse.Error.Code))
+		}
+
+		subscribeResponse = sendRequest(t, conn, `{"id":4,"method":"subscribe","params":{"channel":"user:permissions-user:typing"}}`)
+		if assert.NotNil(t, subscribeResponse.Error) {
+			assert.Equal(t, "PermissionDenied", string(subscribeResponse.Error.Code))
+		}
+
+		publishResponse = sendRequest(t, conn, `{"id":5,"method":"publish","params":{"channel":"user:permissions-user:typing","event":"test-event"}}`)
+		assert.Nil(t, publishResponse.Error)
+	})
+
+	t.Run("binary encodings", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "binary-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"binary:*"},
+			"scope":              []string{"subscribe", "publish"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		payload := []byte{0x00, 0xff, 0x10}
+
+		for _, codec := range []Codec{MsgpackCodec, CBORCodec} {
+			dialer := websocket.Dialer{Subprotocols: []string{codec.Subprotocol(), "bearer." + tokenString}}
+			conn, _, err := dialer.Dial(u.String(), nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			assert.Equal(t, codec.Subprotocol(), conn.Subprotocol())
+
+			read := func(v any) {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				messageType, data, err := conn.ReadMessage()
+				assert.NoError(t, err)
+				assert.Equal(t, websocket.BinaryMessage, messageType)
+
+				err = codec.Unmarshal(data, v)
+				assert.NoError(t, err)
+			}
+
+			send := func(request any) {
+				data, err := codec.Marshal(request)
+				assert.NoError(t, err)
+
+				err = conn.WriteMessage(websocket.BinaryMessage, data)
+				assert.NoError(t, err)
+			}
+
+			var authenticated rpcNotification
+			read(&authenticated)
+			assert.Equal(t, "authenticated", authenticated.Method)
+
+			channel := "binary:" + codec.ContentType()[len("application/"):]
+
+			send(map[string]any{"id": 1, "method": "subscribe", "params": map[string]any{"channel": channel}})
+
+			var subscribeResponse rpcResponse
+			read(&subscribeResponse)
+			assert.Equal(t, 1, subscribeResponse.RequestId)
+			assert.Nil(t, subscribeResponse.Error)
+
+			send(map[string]any{"id": 2, "method": "publish", "params": map[string]any{"channel": channel, "event": "test-event", "payload": payload}})
+
+			// The broadcast may arrive before the response of the publish.
+			for range 2 {
+				var frame struct {
+					RequestId int                 `json:"requestId"`
+					Method    string              `json:"method"`
+					Params    broadcaster.Message `json:"params"`
+				}
+				read(&frame)
+
+				if frame.Method == "broadcast" {
+					assert.Equal(t, channel, frame.Params.Channel)
+					assert.Equal(t, payload, frame.Params.Payload)
+				} else {
+					assert.Equal(t, 2, frame.RequestId)
+				}
+			}
+
+			send(map[string]any{"id": 3, "method": "subscribe"})
+
+			var missingParamsResponse rpcResponse
+			read(&missingParamsResponse)
+			if assert.NotNil(t, missingParamsResponse.Error) {
+				assert.Equal(t, ierr.ErrorCodeInvalidArgument, missingParamsResponse.Error.Code)
+			}
+		}
+	})
+
+	t.Run("json-rpc", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "jsonrpc-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"jsonrpc"},
+			"scope":              []string{"subscribe", "publish"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		dialer := websocket.Dialer{Subprotocols: []string{JSONRPCSubprotocol, "bearer." + tokenString}}
+		conn, _, err := dialer.Dial(u.String(), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		assert.Equal(t, JSONRPCSubprotocol, conn.Subprotocol())
+
+		var authenticated jsonRPCNotification
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, conn.ReadJSON(&authenticated))
+		assert.Equal(t, "2.0", authenticated.JSONRPC)
+		assert.Equal(t, "authenticated", authenticated.Method)
+
+		err = conn.WriteMessage(websocket.TextMessage, []byte(`[
+			{"jsonrpc":"2.0","id":"sub","method":"subscribe","params":{"channel":"jsonrpc"}},
+			{"jsonrpc":"2.0","id":7,"method":"unknown"}
+		]`))
+		assert.NoError(t, err)
+
+		var responses []jsonRPCResponse
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, conn.ReadJSON(&responses))
+		if assert.Len(t, responses, 2) {
+			assert.JSONEq(t, `"sub"`, string(responses[0].Id))
+			assert.Nil(t, responses[0].Error)
+			assert.NotNil(t, responses[0].Result)
+
+			assert.JSONEq(t, `7`, string(responses[1].Id))
+			if assert.NotNil(t, responses[1].Error) {
+				assert.Equal(t, jsonRPCMethodNotFound, responses[1].Error.Code)
+			}
+		}
+
+		err = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "publish", "params": map[string]any{"channel": "jsonrpc", "payload": "hello"}})
+		assert.NoError(t, err)
+
+		var broadcast struct {
+			JSONRPC string              `json:"jsonrpc"`
+			Method  string              `json:"method"`
+			Params  broadcaster.Message `json:"params"`
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, conn.ReadJSON(&broadcast))
+		assert.Equal(t, "2.0", broadcast.JSONRPC)
+		assert.Equal(t, "broadcast", broadcast.Method)
+		assert.Equal(t, "hello", broadcast.Params.Payload)
+	})
+
+	t.Run("refresh token", func(t *testing.T) {
+		signToken := func(subject string, authorizedChannels []string) string {
+			claims := jwt.MapClaims{
+				"sub":                subject,
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"iat":                time.Now().Unix(),
+				"aud":                "broadcaster",
+				"authorizedChannels": authorizedChannels,
+				"scope":              []string{"subscribe"},
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			tokenString, err := token.SignedString([]byte("test-secret"))
+			assert.NoError(t, err)
+
+			return tokenString
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+signToken("refresh-user", []string{"refresh:a", "refresh:b"})+`"}}`)
+		sendRequest(t, conn, `{"id":2,"method":"subscribe","params":{"channel":"refresh:a"}}`)
+		sendRequest(t, conn, `{"id":3,"method":"subscribe","params":{"channel":"refresh:b"}}`)
+
+		authResponse := sendRequest(t, conn, `{"id":4,"method":"auth","params":{"token":"`+signToken("refresh-user", []string{"refresh:a"})+`"}}`)
+		if assert.NotNil(t, authResponse.Error) {
+			assert.Equal(t, "FailedPrecondition", string(authResponse.Error.Code))
+		}
+
+		refreshResponse := sendRequest(t, conn, `{"id":5,"method":"refresh","params":{"token":"`+signToken("other-user", []string{"refresh:a"})+`"}}`)
+		if assert.NotNil(t, refreshResponse.Error) {
+			assert.Equal(t, "PermissionDenied", string(refreshResponse.Error.Code))
+		}
+
+		refreshResponse = sendRequest(t, conn, `{"id":6,"method":"refresh","params":{"token":"`+signToken("refresh-user", []string{"refresh:a"})+`"}}`)
+		if !assert.Nil(t, refreshResponse.Error) {
+			return
+		}
+
+		var refreshResponsePayload handler.RefreshResponse
+		err = json.Unmarshal(*refreshResponse.Result, &refreshResponsePayload)
+		assert.NoError(t, err)
+		assert.True(t, refreshResponsePayload.Success)
+		assert.Equal(t, []string{"refresh:b"}, refreshResponsePayload.Unsubscribed)
+
+		registry.Broadcast(broadcaster.Message{Channel: "refresh:b", Payload: "revoked"})
+		registry.Broadcast(broadcaster.Message{Channel: "refresh:a", Payload: "granted"})
+
+		message := readBroadcast(t, conn)
+		assert.Equal(t, "refresh:a", message.Channel)
+	})
+
+	t.Run("token expiry", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "expiring-user",
+			"exp":                time.Now().Add(2 * time.Second).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"test-channel"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		var notification handler.Request
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		err = conn.ReadJSON(&notification)
+		assert.NoError(t, err)
+		assert.Equal(t, "tokenExpiring", notification.Method)
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, _, err = conn.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		if assert.ErrorAs(t, err, &closeErr) {
+			assert.Equal(t, 4001, closeErr.Code)
+			assert.Equal(t, "token expired", closeErr.Text)
+		}
+	})
+
+	t.Run("revoke subject", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "banned-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"test-channel"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+
+		ctx := auth.WithAuthentication(context.Background(), &auth.Authentication{
+			Subject: "admin",
+			Scope:   []string{auth.ScopeAdmin},
+			IsAdmin: true,
+		})
+		revokeResponse, err := revokeHandler.Handle(ctx, handler.RevokeRequest{Subject: "banned-user"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, revokeResponse.Disconnected)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = conn.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		if assert.ErrorAs(t, err, &closeErr) {
+			assert.Equal(t, 4003, closeErr.Code)
+		}
+
+		conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		authResponse := sendRequest(t, conn, `{"id":1,"method":"auth","params":{"token":"`+tokenString+`"}}`)
+		if assert.NotNil(t, authResponse.Error) {
+			assert.Equal(t, "Unauthenticated", string(authResponse.Error.Code))
+		}
+	})
+
+	t.Run("upgrade authentication", func(t *testing.T) {
+		claims := jwt.MapClaims{
+			"sub":                "upgrade-user",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"aud":                "broadcaster",
+			"authorizedChannels": []string{"test-channel"},
+			"scope":              []string{"subscribe"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte("test-secret"))
+		assert.NoError(t, err)
+
+		dialers := map[string]func() (*websocket.Conn, *http.Response, error){
+			"subprotocol": func() (*websocket.Conn, *http.Response, error) {
+				dialer := websocket.Dialer{Subprotocols: []string{WebSocketSubprotocol, "bearer." + tokenString}}
+				return dialer.Dial(u.String(), nil)
+			},
+			"query parameter": func() (*websocket.Conn, *http.Response, error) {
+				return websocket.DefaultDialer.Dial(u.String()+"?token="+tokenString, nil)
+			},
+			"cookie": func() (*websocket.Conn, *http.Response, error) {
+				return websocket.DefaultDialer.Dial(u.String(), http.Header{"Cookie": {"broadcaster_token=" + tokenString}})
+			},
+		}
+
+		for name, dial := range dialers {
+			conn, resp, err := dial()
+			if !assert.NoError(t, err, name) {
+				continue
+			}
+
+			if name == "subprotocol" {
+				assert.Equal(t, WebSocketSubprotocol, resp.Header.Get("Sec-WebSocket-Protocol"))
+			}
+
+			var notification handler.Request
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			err = conn.ReadJSON(&notification)
+			assert.NoError(t, err, name)
+			assert.Equal(t, "authenticated", notification.Method, name)
+
+			subscribeResponse := sendRequest(t, conn, `{"id":1,"method":"subscribe","params":{"channel":"test-channel"}}`)
+			assert.Nil(t, subscribeResponse.Error, name)
+
+			// Authenticated connections outlive the authentication deadline
+			time.Sleep(600 * time.Millisecond)
+			heartbeatResponse := sendRequest(t, conn, `{"id":2,"method":"heartbeat"}`)
+			assert.Nil(t, heartbeatResponse.Error, name)
+
+			conn.Close()
+		}
+	})
+
+	t.Run("upgrade authentication with invalid token", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(u.String()+"?token=invalid", nil)
+
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "invalid token\n", string(body))
+		}
+	})
+
+	t.Run("authentication deadline", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err = conn.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		if assert.ErrorAs(t, err, &closeErr) {
+			assert.Equal(t, 4002, closeErr.Code)
+		}
+	})
+
+	t.Run("closed while responding", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// Requests keep being answered until the authentication deadline
+		// closes the connection, which must not break the server.
+		go func() {
+			for i := 1; ; i++ {
+				err := conn.WriteJSON(map[string]any{"id": i, "method": "heartbeat"})
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			_, _, err = conn.ReadMessage()
+			if err != nil {
+				break
+			}
+		}
+
+		// The close frame may be lost to a reset while the client still
+		// writes, so only the server is checked to be still serving.
+		assert.Error(t, err)
+
+		other, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		assert.NoError(t, err)
+		defer other.Close()
+
+		response := sendRequest(t, other, `{"id":1,"method":"heartbeat"}`)
+		assert.Nil(t, response.Error)
+	})
+}
+
+func sendRequest(t *testing.T, conn *websocket.Conn, request string) handler.Response {
+	t.Helper()
+
+	err := conn.WriteJSON(json.RawMessage(request))
+	assert.NoError(t, err)
+
+	var response handler.Response
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	err = conn.ReadJSON(&response)
+	assert.NoError(t, err)
+
+	return response
+}
+
+func readBroadcast(t *testing.T, conn *websocket.Conn) broadcaster.Message {
+	t.Helper()
+
+	var notification handler.Request
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	err := conn.ReadJSON(&notification)
+	assert.NoError(t, err)
+	assert.Equal(t, "broadcast", notification.Method)
+
+	var message broadcaster.Message
+	if notification.Params != nil {
+		err = json.Unmarshal(*notification.Params, &message)
+		assert.NoError(t, err)
+	}
+
+	return message
 }